@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"flyt-project-template/utils"
+)
+
+// defaultConfigPath is read from the working directory when -config is unset
+const defaultConfigPath = "flyt.config.json"
+
+// AppConfig holds settings that can come from a config file. Empty or nil
+// fields mean "not set", so defaults, env vars, and flags still apply.
+type AppConfig struct {
+	// Mode is the flow mode, as with -mode
+	Mode string `json:"mode" yaml:"mode"`
+
+	LLM    LLMSettings    `json:"llm" yaml:"llm"`
+	Search SearchSettings `json:"search" yaml:"search"`
+}
+
+// LLMSettings configures the LLM provider and sampling
+type LLMSettings struct {
+	// Provider is "openai", "anthropic", "azure", or "ollama", as with LLM_PROVIDER
+	Provider string `json:"provider" yaml:"provider"`
+
+	Model string `json:"model" yaml:"model"`
+
+	// Temperature is a pointer so an explicit 0 can be told apart from unset
+	Temperature *float64 `json:"temperature" yaml:"temperature"`
+}
+
+// SearchSettings configures the agent's web search backend
+type SearchSettings struct {
+	// Provider is "mock", "duckduckgo", "brave", or "google", as with SEARCH_PROVIDER
+	Provider string `json:"provider" yaml:"provider"`
+}
+
+// LoadConfig reads an AppConfig from a JSON file, or YAML when path ends in
+// .yaml or .yml. A missing file yields an empty config; a malformed one,
+// including one with unknown fields, is an error.
+func LoadConfig(path string) (*AppConfig, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &AppConfig{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	config := &AppConfig{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		// An empty YAML document decodes as io.EOF; treat it as empty config
+		if err := decoder.Decode(config); err != nil && len(bytes.TrimSpace(data)) > 0 {
+			return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+		}
+	default:
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(config); err != nil {
+			return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+		}
+	}
+
+	return config, nil
+}
+
+// applyConfig fills mode, model, and temperature from config unless the
+// matching flag was set, selects the LLM provider unless LLM_PROVIDER is
+// set, and returns the searcher to use, honoring SEARCH_PROVIDER first.
+// An unknown provider name, from the environment or config, is an error.
+func applyConfig(config *AppConfig, mode, model *string, temperature *float64) (utils.Searcher, error) {
+	setFlags := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		setFlags[f.Name] = true
+	})
+
+	if !setFlags["mode"] && config.Mode != "" {
+		*mode = config.Mode
+	}
+	if !setFlags["model"] && config.LLM.Model != "" {
+		*model = config.LLM.Model
+	}
+	if !setFlags["temperature"] && config.LLM.Temperature != nil {
+		*temperature = *config.LLM.Temperature
+	}
+
+	switch {
+	case os.Getenv("LLM_PROVIDER") != "":
+		if _, err := utils.ProviderFromEnv(); err != nil {
+			return nil, err
+		}
+	case config.LLM.Provider != "":
+		provider, err := utils.ProviderByName(config.LLM.Provider)
+		if err != nil {
+			return nil, err
+		}
+		utils.SetProvider(provider)
+	}
+
+	if os.Getenv("SEARCH_PROVIDER") == "" && config.Search.Provider != "" {
+		return utils.SearcherByName(config.Search.Provider)
+	}
+	return utils.SearcherFromEnv()
+}
@@ -1,59 +1,661 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+
 	"github.com/mark3labs/flyt"
+
+	"flyt-project-template/utils"
 )
 
-// CreateQAFlow creates a question-answering flow
+// CreateQAFlow creates a question-answering flow. If the LLM call fails,
+// the flow falls back to a canned answer instead of aborting.
 func CreateQAFlow() *flyt.Flow {
 	// Create nodes
-	getQuestionNode := CreateGetQuestionNode()
-	answerNode := CreateAnswerNode()
+	getQuestionNode := traceNode("get_question", CreateGetQuestionNode())
+	answerNode := traceNode("answer", WithErrorAction(CreateAnswerNode(), ActionError))
+	fallbackNode := traceNode("fallback_answer", CreateFallbackAnswerNode())
+
+	// Connect nodes in sequence
+	flow := newFlow(getQuestionNode)
+	connect(flow, getQuestionNode, flyt.DefaultAction, answerNode)
+	connect(flow, answerNode, ActionError, fallbackNode)
+
+	return flow
+}
+
+// CreateCachedQAFlow creates a question-answering flow that serves
+// repeated questions from cache instead of calling the LLM again
+func CreateCachedQAFlow(cache utils.AnswerCache) *flyt.Flow {
+	// Create nodes
+	getQuestionNode := traceNode("get_question", CreateGetQuestionNode())
+	answerNode := traceNode("answer", WithErrorAction(CreateAnswerNodeWithCache(cache), ActionError))
+	fallbackNode := traceNode("fallback_answer", CreateFallbackAnswerNode())
+
+	// Connect nodes in sequence
+	flow := newFlow(getQuestionNode)
+	connect(flow, getQuestionNode, flyt.DefaultAction, answerNode)
+	connect(flow, answerNode, ActionError, fallbackNode)
+
+	return flow
+}
+
+// CreateModeratedQAFlow creates a question-answering flow that checks the
+// question with the moderation node first and refuses flagged ones
+func CreateModeratedQAFlow() *flyt.Flow {
+	// Create nodes
+	getQuestionNode := traceNode("get_question", CreateGetQuestionNode())
+	moderationNode := traceNode("moderate", CreateModerationNode())
+	refusalNode := traceNode("refuse", CreateRefusalNode())
+	answerNode := traceNode("answer", WithErrorAction(CreateAnswerNode(), ActionError))
+	fallbackNode := traceNode("fallback_answer", CreateFallbackAnswerNode())
+
+	// Connect nodes, diverting blocked questions to the refusal
+	flow := newFlow(getQuestionNode)
+	connect(flow, getQuestionNode, flyt.DefaultAction, moderationNode)
+	connect(flow, moderationNode, flyt.DefaultAction, answerNode)
+	connect(flow, moderationNode, ActionBlocked, refusalNode)
+	connect(flow, answerNode, ActionError, fallbackNode)
+
+	return flow
+}
+
+// CreateIntentRoutedFlow creates a flow that asks for a question and hands
+// it to the route, a node or a whole flow, for its intent. Questions that
+// fit no intent go to the IntentDefault route, so routes should have one.
+func CreateIntentRoutedFlow(routes map[string]flyt.Node, opts ...IntentRouterOption) *flyt.Flow {
+	// Create nodes
+	getQuestionNode := traceNode("get_question", CreateGetQuestionNode())
+	routerNode := traceNode("route_intent", CreateIntentRouterNode(routes, opts...))
+
+	// Connect each intent to its route
+	flow := newFlow(getQuestionNode)
+	connect(flow, getQuestionNode, flyt.DefaultAction, routerNode)
+	for _, intent := range slices.Sorted(maps.Keys(routes)) {
+		connect(flow, routerNode, flyt.Action(intent), routes[intent])
+	}
+
+	return flow
+}
+
+// CreateDecomposedQAFlow creates a question-answering flow that splits
+// complex questions into sub-questions, answers them as a batch, and
+// synthesizes the final answer. Simple questions are answered directly.
+func CreateDecomposedQAFlow() *flyt.Flow {
+	// Create nodes
+	getQuestionNode := traceNode("get_question", CreateGetQuestionNode())
+	// A failed decomposition just means answering the question directly
+	decomposeNode := traceNode("decompose", WithErrorAction(CreateDecomposeNode(), ActionError))
+	subQuestionsNode := traceNode("answer_sub_questions", WithErrorAction(CreateAnswerSubQuestionsNode(), ActionError))
+	synthesizeNode := traceNode("synthesize", WithErrorAction(CreateSynthesizeNode(), ActionError))
+	answerNode := traceNode("answer", WithErrorAction(CreateAnswerNode(), ActionError))
+	fallbackNode := traceNode("fallback_answer", CreateFallbackAnswerNode())
+
+	// Connect nodes, batching sub-questions when the question decomposes
+	flow := newFlow(getQuestionNode)
+	connect(flow, getQuestionNode, flyt.DefaultAction, decomposeNode)
+	connect(flow, decomposeNode, flyt.DefaultAction, answerNode)
+	connect(flow, decomposeNode, ActionError, answerNode)
+	connect(flow, decomposeNode, ActionBatch, subQuestionsNode)
+	connect(flow, subQuestionsNode, flyt.DefaultAction, synthesizeNode)
+	connect(flow, subQuestionsNode, ActionError, fallbackNode)
+	connect(flow, synthesizeNode, ActionError, fallbackNode)
+	connect(flow, answerNode, ActionError, fallbackNode)
+
+	return flow
+}
+
+// CreateStreamingQAFlow creates a question-answering flow that prints the
+// answer as it is generated, falling back to a canned answer on failure
+func CreateStreamingQAFlow() *flyt.Flow {
+	// Create nodes
+	getQuestionNode := traceNode("get_question", CreateGetQuestionNode())
+	answerNode := traceNode("streaming_answer", WithErrorAction(CreateStreamingAnswerNode(), ActionError))
+	fallbackNode := traceNode("fallback_answer", CreateFallbackAnswerNode())
 
 	// Connect nodes in sequence
-	flow := flyt.NewFlow(getQuestionNode)
-	flow.Connect(getQuestionNode, flyt.DefaultAction, answerNode)
+	flow := newFlow(getQuestionNode)
+	connect(flow, getQuestionNode, flyt.DefaultAction, answerNode)
+	connect(flow, answerNode, ActionError, fallbackNode)
 
 	return flow
 }
 
-// CreateAgentFlow creates a more complex agent flow with decision making
-func CreateAgentFlow() *flyt.Flow {
+// CreateREPLFlow creates the flow run once per turn in interactive mode.
+// The caller sets "question" before each run; "history" carries earlier
+// turns between runs and is capped at maxTurns question/answer pairs.
+func CreateREPLFlow(maxTurns int) *flyt.Flow {
 	// Create nodes
-	analyzeNode := CreateAnalyzeNode()
-	searchNode := CreateSearchNode()
-	processNode := CreateProcessNode()
-	answerNode := CreateAnswerNode()
+	answerNode := traceNode("answer", WithErrorAction(CreateAnswerNode(), ActionError))
+	fallbackNode := traceNode("fallback_answer", CreateFallbackAnswerNode())
+	trimHistoryNode := traceNode("trim_history", CreateTrimHistoryNode(maxTurns))
+
+	// Connect nodes, trimming history after every turn
+	flow := newFlow(answerNode)
+	connect(flow, answerNode, flyt.DefaultAction, trimHistoryNode)
+	connect(flow, answerNode, ActionError, fallbackNode)
+	connect(flow, fallbackNode, flyt.DefaultAction, trimHistoryNode)
+
+	return flow
+}
+
+// maxAnswerRetries bounds how many times an inadequate answer is regenerated
+const maxAnswerRetries = 2
+
+// CreateValidatedQAFlow creates a question-answering flow that checks each
+// answer with the LLM and regenerates it when it misses the question
+func CreateValidatedQAFlow() *flyt.Flow {
+	// Create nodes
+	getQuestionNode := traceNode("get_question", CreateGetQuestionNode())
+	answerNode := traceNode("answer", CreateAnswerNode())
+	validateNode := traceNode("validate_answer", CreateValidateAnswerNode(maxAnswerRetries))
+
+	// Connect nodes, looping back to answer on a failed validation
+	flow := newFlow(getQuestionNode)
+	connect(flow, getQuestionNode, flyt.DefaultAction, answerNode)
+	connect(flow, answerNode, flyt.DefaultAction, validateNode)
+	connect(flow, validateNode, "retry", answerNode)
+
+	return flow
+}
+
+// CreateConfidentQAFlow creates a question-answering flow that has the
+// LLM rate its confidence in each answer and regenerates answers scoring
+// below threshold (0-100)
+func CreateConfidentQAFlow(threshold int) *flyt.Flow {
+	// Create nodes
+	getQuestionNode := traceNode("get_question", CreateGetQuestionNode())
+	answerNode := traceNode("answer", CreateAnswerNode())
+	confidenceNode := traceNode("confidence", CreateConfidenceNode(WithConfidenceThreshold(threshold)))
+
+	// Connect nodes, looping back to answer on low confidence
+	flow := newFlow(getQuestionNode)
+	connect(flow, getQuestionNode, flyt.DefaultAction, answerNode)
+	connect(flow, answerNode, flyt.DefaultAction, confidenceNode)
+	connect(flow, confidenceNode, "retry", answerNode)
+
+	return flow
+}
+
+// CreateDocumentQAFlow creates a question-answering flow that answers
+// from the text file at path, using the passages most relevant to the
+// question as context
+func CreateDocumentQAFlow(path string, opts ...DocumentOption) *flyt.Flow {
+	// Create nodes
+	loadDocumentNode := traceNode("load_document", CreateLoadDocumentNode(path, opts...))
+	getQuestionNode := traceNode("get_question", CreateGetQuestionNode())
+	documentQANode := traceNode("select_passages", CreateDocumentQANode())
+	answerNode := traceNode("answer", WithErrorAction(CreateAnswerNode(), ActionError))
+	fallbackNode := traceNode("fallback_answer", CreateFallbackAnswerNode())
+
+	// Connect nodes in sequence, loading the document before prompting
+	flow := newFlow(loadDocumentNode)
+	connect(flow, loadDocumentNode, flyt.DefaultAction, getQuestionNode)
+	connect(flow, getQuestionNode, flyt.DefaultAction, documentQANode)
+	connect(flow, documentQANode, flyt.DefaultAction, answerNode)
+	connect(flow, answerNode, ActionError, fallbackNode)
+
+	return flow
+}
+
+// CreateSummarizeDocumentsFlow creates a flow that summarizes the text and
+// markdown files in dir into one summary, stored as "summary"
+func CreateSummarizeDocumentsFlow(dir string, opts ...MapReduceOption) *flyt.Flow {
+	// Create nodes
+	loadDocumentsNode := traceNode("load_documents", CreateLoadDocumentsNode(dir))
+	summarizeNode := traceNode("map_reduce_summary", CreateMapReduceSummaryNode(opts...))
+
+	// Connect nodes
+	flow := newFlow(loadDocumentsNode)
+	connect(flow, loadDocumentsNode, flyt.DefaultAction, summarizeNode)
+
+	return flow
+}
+
+// maxAgentIterations bounds how many times the agent may re-analyze
+const maxAgentIterations = 5
+
+// CreateAgentFlow creates a more complex agent flow with decision making.
+// The searcher determines which web search backend the agent uses.
+func CreateAgentFlow(searcher utils.Searcher) *flyt.Flow {
+	return agentFlow(searcher, CreateAnswerNode())
+}
+
+// CreateCitedAgentFlow creates the agent flow with an answer that cites
+// the search results it used and lists their URLs
+func CreateCitedAgentFlow(searcher utils.Searcher) *flyt.Flow {
+	return agentFlow(searcher, CreateCitedAnswerNode())
+}
+
+// agentFlow wires the agent's analyze/search loop to answer
+func agentFlow(searcher utils.Searcher, answer flyt.Node) *flyt.Flow {
+	// Create nodes
+	resetNode := traceNode("reset_iterations", CreateResetIterationsNode())
+	clarifyNode := traceNode("clarify", CreateClarifyNode())
+	loopGuardNode := traceNode("loop_guard", CreateLoopGuardNode(maxAgentIterations))
+	analyzeNode := traceNode("analyze", CreateAnalyzeNode())
+	// Search hits external APIs, so retry transient failures
+	searchNode := traceNode("search", WithRetry(CreateSearchNode(searcher), 3, time.Second))
+	reformulateNode := traceNode("reformulate", CreateReformulateNode())
+	processNode := traceNode("process", CreateProcessNode())
+	// A failed page fetch shouldn't sink the run; answer from snippets instead
+	fetchPageNode := traceNode("fetch_page", WithErrorAction(CreateFetchPageNode(), ActionError))
+	answerNode := traceNode("answer", answer)
 
 	// Create flow with conditional routing
-	flow := flyt.NewFlow(analyzeNode)
+	flow := newFlow(resetNode)
+
+	// Vague questions are clarified with the user before any analysis
+	connect(flow, resetNode, flyt.DefaultAction, clarifyNode)
+	connect(flow, clarifyNode, ActionClarify, clarifyNode)
+
+	// Every analyze pass goes through the loop guard first
+	connect(flow, clarifyNode, flyt.DefaultAction, loopGuardNode)
+	connect(flow, loopGuardNode, flyt.DefaultAction, analyzeNode)
+	connect(flow, loopGuardNode, "answer", answerNode)
 
 	// Connect based on analysis results
-	flow.Connect(analyzeNode, "search", searchNode)
-	flow.Connect(analyzeNode, "process", processNode)
-	flow.Connect(analyzeNode, "answer", answerNode)
+	connect(flow, analyzeNode, "search", searchNode)
+	connect(flow, analyzeNode, "process", processNode)
+	connect(flow, analyzeNode, "answer", answerNode)
+
+	// Poor results are searched again with a rewritten query before the
+	// agent analyzes them
+	connect(flow, searchNode, "analyze", reformulateNode)
+	connect(flow, reformulateNode, ActionReformulate, searchNode)
+	connect(flow, reformulateNode, "analyze", loopGuardNode)
+
+	// Search can also lead to process
+	connect(flow, searchNode, "process", processNode)
 
-	// Search can lead back to analyze or to process
-	flow.Connect(searchNode, "analyze", analyzeNode)
-	flow.Connect(searchNode, "process", processNode)
+	// Process fetches the top result's page before answering
+	connect(flow, processNode, flyt.DefaultAction, fetchPageNode)
+	connect(flow, fetchPageNode, flyt.DefaultAction, answerNode)
+	connect(flow, fetchPageNode, ActionError, answerNode)
 
-	// Process always leads to answer
-	flow.Connect(processNode, flyt.DefaultAction, answerNode)
+	return flow
+}
+
+// CreateToolAgentFlow creates an agent flow that lets the model call web
+// search and a calculator through function calling, instead of routing on
+// the analyze node's decisions
+func CreateToolAgentFlow(searcher utils.Searcher) *flyt.Flow {
+	// Create nodes
+	toolAgentNode := traceNode("tool_agent", WithErrorAction(CreateToolAgentNode(map[string]Tool{
+		"web_search": SearchTool(searcher),
+		"calculator": CalculatorTool(),
+	}), ActionError))
+	fallbackNode := traceNode("fallback_answer", CreateFallbackAnswerNode())
+
+	// Connect nodes
+	flow := newFlow(toolAgentNode)
+	connect(flow, toolAgentNode, ActionError, fallbackNode)
 
 	return flow
 }
 
+// BatchFlowOptions configures where the batch flow reads and writes items
+type BatchFlowOptions struct {
+	// InputPath is a .txt, .json, or .csv file of items; empty uses sample items
+	InputPath string
+
+	// CSVColumn is the zero-based column to read from a .csv input
+	CSVColumn int
+
+	// CSVHeader skips the first input row and writes a header on CSV output
+	CSVHeader bool
+
+	// CSVOutputPath, when set, also writes item/result pairs as CSV
+	CSVOutputPath string
+
+	// SQLiteOutputPath, when set, also writes item/result rows to
+	// SQLiteTable in this SQLite database. An InputPath ending in .db,
+	// .sqlite, or .sqlite3 loads SQLiteTable's pending rows as items.
+	SQLiteOutputPath string
+	SQLiteTable      string
+
+	// Concurrency caps how many items are processed at once; zero keeps
+	// the default batch node behavior
+	Concurrency int
+
+	// CollectErrors records per-item failures in "batch_errors" instead of
+	// aborting the whole batch on the first error
+	CollectErrors bool
+
+	// JSON stores []BatchItemResult under "final_results" instead of a
+	// human-readable string
+	JSON bool
+
+	// Table formats "final_results" as an item/result table instead of a
+	// numbered list; JSON takes precedence
+	Table bool
+
+	// Items, when non-nil, are processed instead of reading InputPath
+	Items []string
+
+	// ItemsFromStore skips loading and processes the items already under
+	// flyt.KeyItems in the shared store, so one flow can serve many batches
+	ItemsFromStore bool
+
+	// OnItemDone, when set, is called as each item finishes with the run's
+	// context, possibly from several goroutines at once
+	OnItemDone func(ctx context.Context, item, result any, err error)
+}
+
 // CreateBatchFlow creates a flow that processes multiple items
-func CreateBatchFlow() *flyt.Flow {
+func CreateBatchFlow(opts BatchFlowOptions) *flyt.Flow {
+	var csvOpts []CSVOption
+	if opts.CSVHeader {
+		csvOpts = append(csvOpts, WithCSVHeader())
+	}
+
 	// Create nodes
-	loadItemsNode := CreateLoadItemsNode()
-	batchProcessNode := CreateBatchProcessNode()
-	aggregateNode := CreateAggregateResultsNode()
+	var loadItemsNode flyt.Node
+	switch {
+	case opts.Items != nil:
+		loadItemsNode = traceNode("load_items", CreateItemsNode(opts.Items))
+	case opts.InputPath == "":
+		loadItemsNode = traceNode("load_items", CreateLoadItemsNode())
+	case isSQLitePath(opts.InputPath):
+		loadItemsNode = traceNode("load_items", CreateLoadItemsFromSQLiteNode(opts.InputPath, opts.SQLiteTable))
+	case strings.EqualFold(filepath.Ext(opts.InputPath), ".csv"):
+		loadItemsNode = traceNode("load_items", CreateLoadItemsFromCSVNode(opts.InputPath, opts.CSVColumn, csvOpts...))
+	default:
+		loadItemsNode = traceNode("load_items", CreateLoadItemsFromFileNode(opts.InputPath))
+	}
+	batchProcessNode := traceNode("batch_process", CreateBatchProcessNode())
+	if opts.Concurrency > 0 || opts.CollectErrors || opts.OnItemDone != nil {
+		concurrency := opts.Concurrency
+		if concurrency == 0 {
+			concurrency = flyt.DefaultBatchConfig().MaxConcurrency
+		}
+		processFunc := flyt.BatchProcessFunc(processBatchItem)
+		if opts.OnItemDone != nil {
+			processFunc = notifyingProcessFunc(processFunc, opts.OnItemDone)
+		}
+		batchProcessNode = traceNode("batch_process", CreateBatchProcessNodeWithConcurrency(processFunc, concurrency, opts.CollectErrors))
+	}
+	aggregateNode := batchAggregateNode(opts)
 
 	// Connect nodes
-	flow := flyt.NewFlow(loadItemsNode)
-	flow.Connect(loadItemsNode, flyt.DefaultAction, batchProcessNode)
-	flow.Connect(batchProcessNode, flyt.DefaultAction, aggregateNode)
+	var flow *flyt.Flow
+	if opts.ItemsFromStore {
+		flow = newFlow(batchProcessNode)
+	} else {
+		flow = newFlow(loadItemsNode)
+		connect(flow, loadItemsNode, flyt.DefaultAction, batchProcessNode)
+	}
+	connect(flow, batchProcessNode, flyt.DefaultAction, aggregateNode)
+	lastNode := aggregateNode
+
+	if opts.CSVOutputPath != "" {
+		writeCSVNode := traceNode("write_csv", CreateWriteResultsToCSVNode(opts.CSVOutputPath, csvOpts...))
+		connect(flow, aggregateNode, flyt.DefaultAction, writeCSVNode)
+		lastNode = writeCSVNode
+	}
+	if opts.SQLiteOutputPath != "" {
+		writeSQLiteNode := traceNode("write_sqlite", CreateSQLiteSinkNode(opts.SQLiteOutputPath, opts.SQLiteTable))
+		connect(flow, lastNode, flyt.DefaultAction, writeSQLiteNode)
+	}
 
 	return flow
 }
+
+// batchAggregateNode returns the batch flow's aggregate node for opts. It
+// is also run on its own to summarize a cancelled batch, since the flow
+// stops once its context is cancelled.
+func batchAggregateNode(opts BatchFlowOptions) flyt.Node {
+	switch {
+	case opts.JSON:
+		return traceNode("aggregate", CreateAggregateResultsJSONNode())
+	case opts.Table:
+		return traceNode("aggregate", CreateAggregateTableNode())
+	default:
+		return traceNode("aggregate", CreateAggregateResultsNode())
+	}
+}
+
+// isSQLitePath reports whether path names a SQLite database by extension
+func isSQLitePath(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".db", ".sqlite", ".sqlite3":
+		return true
+	}
+	return false
+}
+
+// flowEdge is one action transition between two named nodes
+type flowEdge struct {
+	from   string
+	action flyt.Action
+	to     string
+}
+
+// flowGraph records a flow's structure, which flyt keeps private
+type flowGraph struct {
+	start string
+	edges []flowEdge
+
+	// nodes are the flow's traced nodes, for InstrumentFlow
+	nodes []*tracedNode
+}
+
+// addNode records node in the graph if it is traced and not yet known
+func (g *flowGraph) addNode(node flyt.Node) {
+	traced, ok := node.(*tracedNode)
+	if !ok {
+		return
+	}
+	for _, known := range g.nodes {
+		if known == traced {
+			return
+		}
+	}
+	g.nodes = append(g.nodes, traced)
+}
+
+// flowGraphs holds the structure of every flow built with newFlow, keyed
+// by the flow's address so the map doesn't keep flows alive. A finalizer
+// drops a flow's entry once the flow is unreachable; the graph must not
+// refer back to its flow, or the flow would never be collected.
+var (
+	flowGraphsMu sync.Mutex
+	flowGraphs   = map[uintptr]*flowGraph{}
+)
+
+// flowKey is f's key in flowGraphs
+func flowKey(f *flyt.Flow) uintptr {
+	return uintptr(unsafe.Pointer(f))
+}
+
+// newFlow creates a flow like flyt.NewFlow and registers it so
+// DescribeFlow can report its structure
+func newFlow(start flyt.Node) *flyt.Flow {
+	flow := flyt.NewFlow(start)
+
+	graph := &flowGraph{start: nodeName(start)}
+	graph.addNode(start)
+
+	flowGraphsMu.Lock()
+	flowGraphs[flowKey(flow)] = graph
+	flowGraphsMu.Unlock()
+	runtime.SetFinalizer(flow, forgetFlowGraph)
+
+	return flow
+}
+
+// forgetFlowGraph removes a collected flow's graph from flowGraphs
+func forgetFlowGraph(f *flyt.Flow) {
+	flowGraphsMu.Lock()
+	delete(flowGraphs, flowKey(f))
+	flowGraphsMu.Unlock()
+}
+
+// connect links two nodes like flow.Connect and records the edge. As with
+// Connect, a second edge for the same node and action replaces the first.
+func connect(flow *flyt.Flow, from flyt.Node, action flyt.Action, to flyt.Node) {
+	flow.Connect(from, action, to)
+
+	flowGraphsMu.Lock()
+	defer flowGraphsMu.Unlock()
+	graph, ok := flowGraphs[flowKey(flow)]
+	if !ok {
+		return
+	}
+	graph.addNode(from)
+	graph.addNode(to)
+
+	edge := flowEdge{from: nodeName(from), action: action, to: nodeName(to)}
+	for i, existing := range graph.edges {
+		if existing.from == edge.from && existing.action == edge.action {
+			graph.edges[i] = edge
+			return
+		}
+	}
+	graph.edges = append(graph.edges, edge)
+}
+
+// nodeName returns the name a node was traced with, or its type otherwise.
+// traceNode doubles as the naming registry, so flows should trace every node.
+func nodeName(node flyt.Node) string {
+	if traced, ok := node.(*tracedNode); ok {
+		return traced.name
+	}
+	return fmt.Sprintf("%T", node)
+}
+
+// NodeInfo describes a node run to Hooks callbacks
+type NodeInfo struct {
+	// Name is the name the node was traced with
+	Name string
+
+	// PrepResult is the node's prep result, or nil if prep failed
+	PrepResult any
+
+	// Action is the action the node returned; empty if it failed
+	Action flyt.Action
+
+	// Duration is the time since the node's prep started (AfterNode only)
+	Duration time.Duration
+
+	// Err is the error the node failed with (AfterNode only). The flow
+	// still receives it; hooks only observe.
+	Err error
+}
+
+// Hooks are optional callbacks run around every node of a flow, for
+// cross-cutting concerns such as logging, metrics, or auditing
+type Hooks struct {
+	// BeforeNode runs after a node's prep succeeds, just before its exec
+	BeforeNode func(ctx context.Context, info NodeInfo)
+
+	// AfterNode runs once a node finishes, whether it succeeded or failed
+	// in prep, exec, or post
+	AfterNode func(ctx context.Context, info NodeInfo)
+}
+
+// InstrumentFlow adds hooks to every node of f. Hooks cannot change a
+// node's result or error, and a panicking hook is logged rather than
+// allowed to break the node. Only flows built with newFlow from traced
+// nodes can be instrumented; nested flows are not.
+func InstrumentFlow(f *flyt.Flow, hooks Hooks) error {
+	flowGraphsMu.Lock()
+	defer flowGraphsMu.Unlock()
+
+	graph, ok := flowGraphs[flowKey(f)]
+	if !ok {
+		return fmt.Errorf("flow structure unknown: flow was not built with newFlow")
+	}
+	for _, node := range graph.nodes {
+		node.hooks = append(node.hooks, hooks)
+	}
+	return nil
+}
+
+// DescribeFlow renders a flow as an adjacency list: the start node, then
+// each node followed by its "action → node" edges in connection order.
+// Only flows built with newFlow can be described.
+func DescribeFlow(f *flyt.Flow) string {
+	flowGraphsMu.Lock()
+	defer flowGraphsMu.Unlock()
+
+	graph, ok := flowGraphs[flowKey(f)]
+	if !ok {
+		return "(unknown flow structure)\n"
+	}
+
+	var order []string
+	edgesFrom := map[string][]flowEdge{}
+	for _, edge := range graph.edges {
+		if _, seen := edgesFrom[edge.from]; !seen {
+			order = append(order, edge.from)
+		}
+		edgesFrom[edge.from] = append(edgesFrom[edge.from], edge)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "start: %s\n", graph.start)
+	for _, from := range order {
+		fmt.Fprintf(&b, "%s\n", from)
+		for _, edge := range edgesFrom[from] {
+			fmt.Fprintf(&b, "  %s → %s\n", edge.action, edge.to)
+		}
+	}
+	return b.String()
+}
+
+// ExportDOT renders a flow as a Graphviz DOT digraph, with nodes labeled by
+// their traced names and edges by action. The start node is drawn bold.
+// Only flows built with newFlow can be exported.
+func ExportDOT(f *flyt.Flow) (string, error) {
+	flowGraphsMu.Lock()
+	defer flowGraphsMu.Unlock()
+
+	graph, ok := flowGraphs[flowKey(f)]
+	if !ok {
+		return "", fmt.Errorf("flow structure unknown: flow was not built with newFlow")
+	}
+
+	// List every node once, in the order it first appears
+	names := []string{graph.start}
+	seen := map[string]bool{graph.start: true}
+	for _, edge := range graph.edges {
+		for _, name := range []string{edge.from, edge.to} {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("digraph flow {\n")
+	b.WriteString("  node [shape=box];\n")
+	for _, name := range names {
+		if name == graph.start {
+			fmt.Fprintf(&b, "  %s [style=bold];\n", dotQuote(name))
+			continue
+		}
+		fmt.Fprintf(&b, "  %s;\n", dotQuote(name))
+	}
+	for _, edge := range graph.edges {
+		fmt.Fprintf(&b, "  %s -> %s [label=%s];\n", dotQuote(edge.from), dotQuote(edge.to), dotQuote(string(edge.action)))
+	}
+	b.WriteString("}\n")
+
+	return b.String(), nil
+}
+
+// dotQuote quotes s as a DOT ID
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
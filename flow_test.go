@@ -0,0 +1,40 @@
+package main
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestFlowGraphsForgetUnreachableFlows(t *testing.T) {
+	// Keep only the keys, which don't hold the flows alive
+	var keys []uintptr
+	for range 100 {
+		flow := CreateQAFlow()
+		if DescribeFlow(flow) == "" {
+			t.Fatal("DescribeFlow() is empty for a newFlow flow")
+		}
+		keys = append(keys, flowKey(flow))
+	}
+
+	// Finalizers run after a collection, on their own goroutine
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		runtime.GC()
+		remaining := 0
+		flowGraphsMu.Lock()
+		for _, key := range keys {
+			if _, ok := flowGraphs[key]; ok {
+				remaining++
+			}
+		}
+		flowGraphsMu.Unlock()
+		if remaining == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d of 100 unreachable flows are still registered", remaining)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
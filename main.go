@@ -3,58 +3,326 @@ package main
 import (
 	"bufio"
 	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"syscall"
+	"text/template"
+	"time"
 
 	"github.com/mark3labs/flyt"
+
+	"flyt-project-template/utils"
 )
 
 func main() {
 	// Define command line flags
 	var (
-		mode    = flag.String("mode", "qa", "Flow mode: qa, agent, or batch")
-		verbose = flag.Bool("v", false, "Enable verbose output")
+		mode         = flag.String("mode", "qa", "Flow mode: qa, repl, agent, batch, serve, or summarize")
+		verbose      = flag.Bool("v", false, "Enable verbose output (same as -log-level debug)")
+		logLevel     = flag.String("log-level", "info", "Output level: quiet (results only), info, or debug")
+		input        = flag.String("input", "", "Batch mode: file of items (.txt one per line, .json array, .csv, or pending rows of a .db/.sqlite database)")
+		csvColumn    = flag.Int("csv-column", 0, "Batch mode: zero-based CSV column to read items from")
+		csvHeader    = flag.Bool("csv-header", false, "Batch mode: CSV input has a header row (and write one on output)")
+		csvOut       = flag.String("csv-out", "", "Batch mode: also write item/result pairs to this CSV file")
+		sqliteOut    = flag.String("sqlite-out", "", "Batch mode: also write item/result rows to this SQLite database")
+		sqliteTable  = flag.String("sqlite-table", "results", "Batch mode: table for -sqlite-out and for .db/.sqlite -input files")
+		jsonOut      = flag.Bool("json", false, "Batch mode: print results as JSON")
+		tableOut     = flag.Bool("table", false, "Batch mode: print results as an aligned item/result table")
+		keepGoing    = flag.Bool("keep-going", false, "Batch mode: record failed items instead of aborting the batch")
+		concurrency  = flag.Int("concurrency", 0, "Batch and summarize modes: max items (or document chunks) processed at once (0 uses the default)")
+		outputPath   = flag.String("output", "", "Write the answer or batch results to this file instead of stdout")
+		model        = flag.String("model", "", "LLM model to use (default: provider default)")
+		temperature  = flag.Float64("temperature", utils.DefaultLLMConfig().Temperature, "LLM sampling temperature (0-2)")
+		maxTokens    = flag.Int("max-tokens", 0, "Cap the completion length of every LLM call (0 uses the model default)")
+		logFormat    = flag.String("log-format", "text", "Log format: text or json")
+		validate     = flag.Bool("validate", false, "QA mode: check answers with the LLM and retry inadequate ones")
+		timeout      = flag.Duration("timeout", 0, "Abort the flow (per request in serve mode) after this long, e.g. 30s (0 means no deadline)")
+		stream       = flag.Bool("stream", false, "QA mode: print the answer as it is generated")
+		dryRun       = flag.Bool("dry-run", false, "Print the selected flow's nodes and actions without running it")
+		exportDOT    = flag.String("export-dot", "", "Write the selected flow as a Graphviz DOT file and exit")
+		maxTurns     = flag.Int("history-turns", 10, "REPL mode: earlier turns sent with each question (0 keeps all)")
+		configPath   = flag.String("config", defaultConfigPath, "JSON or YAML config file; flags and env vars override it")
+		forceCache   = flag.Bool("force-cache", false, "With FLYT_LLM_CACHE=1, cache LLM responses even when temperature > 0")
+		addr         = flag.String("addr", ":8080", "Serve mode: HTTP listen address")
+		useTools     = flag.Bool("tools", false, "Agent mode: let the model call tools via function calling (OpenAI only)")
+		docPath      = flag.String("doc", "", "QA mode: answer from this text or markdown file")
+		docEmbed     = flag.Bool("doc-embeddings", false, "QA mode: pick -doc passages by embedding similarity (OpenAI) instead of keywords")
+		noColor      = flag.Bool("no-color", false, "Print answers as plain text instead of rendering markdown")
+		lang         = flag.String("lang", "", "QA and agent modes: translate the answer into this language, e.g. es")
+		cacheSize    = flag.Int("answer-cache", 0, "Serve mode: cache answers to this many distinct questions in memory (0 disables)")
+		moderate     = flag.Bool("moderate", false, "QA and serve modes: refuse questions flagged by moderation (OpenAI, or a keyword blocklist offline)")
+		questionFile = flag.String("question-file", "", "QA and agent modes: read the question from this file instead of stdin (a question argument takes precedence)")
+		decompose    = flag.Bool("decompose", false, "QA mode: split complex questions into sub-questions and answer them as a batch")
+		seed         = flag.Int("seed", 0, "Send this sampling seed so repeated runs give the same answer (best effort, OpenAI only)")
+		adaptive     = flag.Bool("adaptive-timeout", false, "Time out LLM calls based on the model's observed latency instead of always waiting 30s")
+		promptTmpl   = flag.String("prompt-template", "", "QA and agent modes: answer prompt as a Go text/template using {{.Question}} and {{.Context}}, inline or a file path")
+		fewShotPath  = flag.String("few-shot", "", "JSON file of example user/assistant messages sent before each question")
+		cite         = flag.Bool("cite", false, "Agent mode: cite search results in the answer as [n] and list their URLs")
+		minConf      = flag.Int("min-confidence", 0, "QA mode: regenerate answers the LLM rates below this confidence (0-100; 0 disables)")
+		repeat       = flag.Int("n", 1, "QA mode: ask the question this many times and summarize how the answers agree")
+		reportPath   = flag.String("report-file", "", "QA and agent modes: also write a markdown report of the question, sources, and answer to this file")
+		retryBudget  = flag.Int("retry-budget", 0, "Cap the transient-error retries of all LLM calls in a run together (0 is unlimited; not in serve mode)")
+		resumePath   = flag.String("resume", "", "QA, agent, REPL, and batch modes: restore the shared store from this snapshot file if it exists, and save it there after each node and when the run ends (the flow restarts from its first node)")
+		showCost     = flag.Bool("cost", false, "Print the run's LLM token usage and estimated dollar cost when it ends (not in serve mode)")
+		sinkPath     = flag.String("sink", "", "QA, agent, batch, and summarize modes: also save the answer, batch results, or summary to this file (JSON lines, or a .db/.sqlite table)")
+		docsDir      = flag.String("docs-dir", "", "Summarize mode: directory of .txt and .md files to summarize into one summary")
 	)
 	flag.Parse()
 
+	// Configure logging; logs go to stderr so stdout carries only results
+	if *verbose {
+		*logLevel = "debug"
+	}
+	level, err := utils.ParseLogLevel(*logLevel)
+	if err != nil {
+		log.Fatalf("Invalid -log-level: %v", err)
+	}
+	logger, err := utils.NewLogger(os.Stderr, *logFormat, level)
+	if err != nil {
+		log.Fatalf("Invalid -log-format: %v", err)
+	}
+	slog.SetDefault(logger)
+	debug := level <= slog.LevelDebug
+
+	// Load file settings; flags and env vars take precedence over them
+	appConfig, err := LoadConfig(*configPath)
+	if err != nil {
+		fatal("Invalid config file", "path", *configPath, "error", err)
+	}
+	searcher, err := applyConfig(appConfig, mode, model, temperature)
+	if err != nil {
+		fatal("Invalid provider settings", "config", *configPath, "error", err)
+	}
+
+	utils.LLMCacheForce = *forceCache
+
 	// Check for required environment variables
-	if os.Getenv("OPENAI_API_KEY") == "" {
-		log.Println("Warning: OPENAI_API_KEY not set. Some features may not work.")
+	if keyEnv := utils.ProviderAPIKeyEnv(); keyEnv != "" && os.Getenv(keyEnv) == "" {
+		slog.Warn("API key not set, some features may not work", "env", keyEnv)
+	}
+
+	if *cacheSize < 0 {
+		fatal("Invalid -answer-cache: must not be negative", "answer-cache", *cacheSize)
+	}
+
+	if *repeat < 1 {
+		fatal("Invalid -n: must be at least 1", "n", *repeat)
+	}
+	if *repeat > 1 && *mode == "qa" && (*stream || *lang != "") {
+		slog.Warn("-stream and -lang are ignored with -n")
+		*stream, *lang = false, ""
+	}
+	if *sinkPath != "" && ((*repeat > 1 && *mode == "qa") || *mode == "repl" || *mode == "serve") {
+		slog.Warn("-sink is ignored with -n and in repl and serve modes")
+		*sinkPath = ""
+	}
+	if *reportPath != "" && ((*repeat > 1 && *mode == "qa") || (*mode != "qa" && *mode != "agent")) {
+		slog.Warn("-report-file only applies to single qa and agent runs")
+		*reportPath = ""
+	}
+
+	if *retryBudget < 0 {
+		fatal("Invalid -retry-budget: must not be negative", "retry-budget", *retryBudget)
+	}
+	if *retryBudget > 0 && *mode == "serve" {
+		slog.Warn("-retry-budget is ignored in serve mode")
+		*retryBudget = 0
+	}
+
+	if *minConf < 0 || *minConf > 100 {
+		fatal("Invalid -min-confidence: must be between 0 and 100", "min-confidence", *minConf)
+	}
+
+	if *temperature < 0 || *temperature > 2 {
+		fatal("Invalid -temperature: must be between 0 and 2", "temperature", *temperature)
+	}
+
+	if *maxTokens < 0 {
+		fatal("Invalid -max-tokens: must not be negative", "max-tokens", *maxTokens)
+	}
+	utils.DefaultMaxTokens = *maxTokens
+
+	// Render markdown answers only for a terminal that wants color
+	formatAnswer := func(answer string) string { return answer }
+	if !*noColor && os.Getenv("NO_COLOR") == "" && isTerminal(os.Stdout) {
+		formatAnswer = utils.RenderMarkdown
 	}
 
 	// Create shared store
 	shared := flyt.NewSharedStore()
 
-	// Create context
-	ctx := context.Background()
+	// Pick up the state a previous run saved with -resume
+	if *resumePath != "" {
+		if *mode == "serve" || *repeat > 1 {
+			slog.Warn("-resume is ignored in serve mode and with -n")
+			*resumePath = ""
+		} else if restored, err := LoadSnapshot(*resumePath); err == nil {
+			shared.Merge(restored.GetAll())
+			slog.Info("Resuming from snapshot", "path", *resumePath)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			fatal("Invalid -resume", "error", err)
+		}
+	}
+
+	// Share LLM settings with every node that calls the model
+	llmConfig := utils.DefaultLLMConfig()
+	llmConfig.Model = *model
+	llmConfig.Temperature = *temperature
+	llmConfig.AdaptiveTimeout = *adaptive
+	if *fewShotPath != "" {
+		examples, err := utils.LoadFewShotFromJSON(*fewShotPath)
+		if err != nil {
+			fatal("Invalid -few-shot", "error", err)
+		}
+		llmConfig.FewShot = examples
+	}
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "seed" {
+			llmConfig.Seed = seed
+		}
+	})
+	shared.Set("llm_config", llmConfig)
+
+	// A custom answer prompt replaces DefaultPromptTemplate
+	if *promptTmpl != "" {
+		tmpl, err := loadPromptTemplate(*promptTmpl)
+		if err != nil {
+			fatal("Invalid -prompt-template", "error", err)
+		}
+		shared.Set("prompt_template", tmpl)
+	}
+
+	// A question file or argument replaces the stdin prompt in QA and
+	// agent modes; the argument wins when both are given
+	if *questionFile != "" {
+		data, err := os.ReadFile(*questionFile)
+		if err != nil {
+			fatal("Failed to read question file", "path", *questionFile, "error", err)
+		}
+		shared.Set("question", trimQuestion(string(data)))
+	}
+	if flag.NArg() > 0 && (*mode == "qa" || *mode == "agent") {
+		shared.Set("question", trimQuestion(flag.Arg(0)))
+	}
+
+	// Create context, bounded by -timeout when set; the deadline reaches
+	// every LLM and search HTTP call through ctx. Serve mode applies the
+	// timeout to each request instead.
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	watchSignals(cancel)
+	if *timeout > 0 && *mode != "serve" {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	// Every LLM call in the run draws its retries from one budget
+	var budget *utils.RetryBudget
+	if *retryBudget > 0 {
+		budget = utils.NewRetryBudget(*retryBudget)
+		ctx = utils.WithRetryBudget(ctx, budget)
+	}
+
+	// Add up token usage for the -cost report
+	if *showCost && *mode != "serve" {
+		ctx = utils.WithUsageTracker(ctx, utils.NewUsageTracker())
+	}
+
+	// Export traces when OTEL_ENABLED is set; otherwise spans are no-ops
+	shutdownTracing, err := utils.InitTracing(ctx)
+	if err != nil {
+		fatal("Failed to start tracing", "error", err)
+	}
+	defer shutdownTracing(context.Background())
 
 	// Select and run the appropriate flow
 	var flow *flyt.Flow
-	var err error
+	var batchOpts BatchFlowOptions
 
 	switch *mode {
 	case "qa":
-		fmt.Println("🤖 Starting Q&A Flow...")
+		// Only one QA variant runs; the first flag set in this list wins
+		variant, ignored := qaVariant([]qaFlag{
+			{"-doc", *docPath != ""},
+			{"-moderate", *moderate},
+			{"-decompose", *decompose},
+			{"-min-confidence", *minConf > 0},
+			{"-validate", *validate},
+			{"-stream", *stream},
+		})
+		if len(ignored) > 0 {
+			slog.Warn(ignoredFlagsMessage(ignored, variant))
+		}
+
+		switch variant {
+		case "-doc":
+			var docOpts []DocumentOption
+			if *docEmbed {
+				docOpts = append(docOpts, WithDocumentEmbeddings())
+			}
+			flow = CreateDocumentQAFlow(*docPath, docOpts...)
+		case "-moderate":
+			flow = CreateModeratedQAFlow()
+		case "-decompose":
+			flow = CreateDecomposedQAFlow()
+		case "-min-confidence":
+			flow = CreateConfidentQAFlow(*minConf)
+		case "-validate":
+			flow = CreateValidatedQAFlow()
+		case "-stream":
+			if *lang != "" {
+				slog.Warn(ignoredFlagsMessage([]string{"-stream"}, "-lang"))
+				flow = CreateQAFlow()
+			} else {
+				flow = CreateStreamingQAFlow()
+			}
+		default:
+			flow = CreateQAFlow()
+		}
+
+	case "repl":
+		flow = CreateREPLFlow(*maxTurns)
+
+	case "serve":
+		// Each request builds its own QA flow; this one is for -dry-run
 		flow = CreateQAFlow()
+		if *moderate {
+			flow = CreateModeratedQAFlow()
+		}
 
 	case "agent":
-		fmt.Println("🤖 Starting Agent Flow...")
-		flow = CreateAgentFlow()
+		// Cache searches so analyze/search loops don't repeat the same query
+		cachedSearcher := utils.NewCachedSearcher(searcher, 5*time.Minute)
+		switch {
+		case *useTools:
+			if *cite {
+				slog.Warn("-cite is ignored with -tools")
+			}
+			flow = CreateToolAgentFlow(cachedSearcher)
+		case *cite:
+			flow = CreateCitedAgentFlow(cachedSearcher)
+		default:
+			flow = CreateAgentFlow(cachedSearcher)
+		}
 		// For agent mode, we need to set an initial question
-		if flag.NArg() > 0 {
-			shared.Set("question", flag.Arg(0))
-		} else {
-			// Prompt for question if not provided
-			reader := bufio.NewReader(os.Stdin)
-			fmt.Print("Enter your question: ")
-			question, err := reader.ReadString('\n')
+		if _, ok := shared.Get("question"); !ok && !*dryRun && *exportDOT == "" {
+			// Read the question from stdin if not provided
+			question, err := readQuestion(os.Stdin)
 			if err != nil {
-				log.Fatalf("Failed to read input: %v", err)
+				fatal("Failed to read input", "error", err)
 			}
-			question = strings.TrimSpace(question)
 			if question == "" {
 				question = "What is the capital of France?"
 			}
@@ -62,42 +330,621 @@ func main() {
 		}
 
 	case "batch":
-		fmt.Println("🤖 Starting Batch Processing Flow...")
-		flow = CreateBatchFlow()
+		if *tableOut && *jsonOut {
+			slog.Warn("-table is ignored with -json")
+		}
+		batchOpts = BatchFlowOptions{
+			InputPath:        *input,
+			CSVColumn:        *csvColumn,
+			CSVHeader:        *csvHeader,
+			CSVOutputPath:    *csvOut,
+			SQLiteOutputPath: *sqliteOut,
+			SQLiteTable:      *sqliteTable,
+			Concurrency:      *concurrency,
+			CollectErrors:    *keepGoing,
+			JSON:             *jsonOut,
+			Table:            *tableOut,
+		}
+		flow = CreateBatchFlow(batchOpts)
+
+	case "summarize":
+		if *docsDir == "" && !*dryRun && *exportDOT == "" {
+			fatal("Summarize mode needs -docs-dir")
+		}
+		var summaryOpts []MapReduceOption
+		if *concurrency > 0 {
+			summaryOpts = append(summaryOpts, WithMapReduceConcurrency(*concurrency))
+		}
+		flow = CreateSummarizeDocumentsFlow(*docsDir, summaryOpts...)
 
 	default:
-		log.Fatalf("Unknown mode: %s. Use 'qa', 'agent', or 'batch'", *mode)
+		fatal("Unknown mode, use 'qa', 'repl', 'agent', 'batch', 'serve', or 'summarize'", "mode", *mode)
 	}
 
-	// Enable verbose logging if requested
-	if *verbose {
-		fmt.Println("📊 Verbose mode enabled")
-		// In a real implementation, you might configure logging here
+	// Show the flow's structure instead of running it
+	if *exportDOT != "" {
+		dot, err := ExportDOT(flow)
+		if err != nil {
+			fatal("Failed to export flow", "error", err)
+		}
+		if err := writeOutput(*exportDOT, strings.TrimSuffix(dot, "\n")); err != nil {
+			fatal("Failed to write DOT file", "path", *exportDOT, "error", err)
+		}
+		slog.Info("Flow graph written", "path", *exportDOT)
+		return
+	}
+	if *dryRun {
+		fmt.Print(DescribeFlow(flow))
+		return
+	}
+
+	// Serve mode answers HTTP requests until interrupted
+	if *mode == "serve" {
+		qaFlow := CreateQAFlow
+		switch {
+		case *moderate:
+			if *cacheSize > 0 {
+				slog.Warn("-answer-cache is ignored with -moderate")
+			}
+			qaFlow = CreateModeratedQAFlow
+		case *cacheSize > 0:
+			answerCache := utils.NewLRUAnswerCache(*cacheSize)
+			qaFlow = func() *flyt.Flow { return CreateCachedQAFlow(answerCache) }
+		}
+		if err := runServer(ctx, newServer(*addr, llmConfig, *timeout, qaFlow)); err != nil {
+			fatal("Server failed", "addr", *addr, "error", err)
+		}
+		return
+	}
+
+	// Time each node for the debug summary
+	var timings nodeTimings
+	if debug {
+		if err := InstrumentFlow(flow, timings.hooks()); err != nil {
+			fatal("Failed to instrument flow", "error", err)
+		}
+	}
+
+	// Save the state as each node finishes, not only when the run ends
+	if *resumePath != "" {
+		if err := InstrumentFlow(flow, snapshotHooks(FileSnapshotStore{Path: *resumePath}, shared)); err != nil {
+			fatal("Failed to instrument flow", "error", err)
+		}
+	}
+
+	// The REPL runs its flow once per question until the user quits
+	if *mode == "repl" {
+		err := runREPL(ctx, flow, shared, os.Stdin, formatAnswer)
+		logRetryBudget(budget)
+		saveResumeSnapshot(shared, *resumePath)
+		printCostReport(ctx, shared)
+		if errors.Is(context.Cause(ctx), errInterrupted) {
+			os.Exit(exitCancelled)
+		}
+		if err != nil {
+			fatal("REPL failed", "error", err)
+		}
+		return
+	}
+
+	// Repeated QA runs print every answer and how often each came up
+	if *repeat > 1 && *mode == "qa" {
+		answers, err := runRepeated(ctx, flow, shared, *repeat)
+		logRetryBudget(budget)
+		if errors.Is(context.Cause(ctx), errInterrupted) {
+			os.Exit(exitCancelled)
+		}
+		if err != nil {
+			printFailureReport(os.Stderr, shared)
+			fatal("Flow failed", "mode", *mode, "error", err)
+		}
+		output := formatRepeated(answers)
+		if *outputPath != "" {
+			if err := writeOutput(*outputPath, output); err != nil {
+				fatal("Failed to write output", "path", *outputPath, "error", err)
+			}
+			slog.Info("Results written", "path", *outputPath)
+		} else {
+			fmt.Println(formatAnswer(output))
+		}
+		printCostReport(ctx, shared)
+		return
+	}
+
+	// Translate the answer as a final step after the flow
+	var translateNode flyt.Node
+	if *lang != "" && (*mode == "qa" || *mode == "agent") {
+		translateNode = traceNode("translate", CreateTranslateNode(*lang))
+	}
+
+	// Build the markdown report from the final, possibly translated, answer
+	var reportNode flyt.Node
+	if *reportPath != "" {
+		reportNode = traceNode("report", CreateMarkdownReportNode())
+	}
+
+	// Save the flow's output through a sink once it has run
+	var sinkNode flyt.Node
+	if *sinkPath != "" {
+		sink, err := newSink(*sinkPath)
+		if err != nil {
+			fatal("Invalid -sink", "path", *sinkPath, "error", err)
+		}
+		key := "answer"
+		switch *mode {
+		case "batch":
+			key = "final_results"
+		case "summarize":
+			key = "summary"
+		}
+		sinkNode = traceNode("sink", CreateSinkNode(sink, key))
 	}
 
 	// Run the flow
-	fmt.Println("🚀 Running flow...")
-	err = flow.Run(ctx, shared)
+	slog.Info("Running flow", "mode", *mode)
+	runCtx, span := utils.StartSpan(ctx, "flow."+*mode)
+	err = flow.Run(runCtx, shared)
+	if err == nil && translateNode != nil {
+		// An untranslated answer beats none
+		if _, err := flyt.Run(runCtx, translateNode, shared); err != nil {
+			slog.Warn("Translation failed, showing the original answer", "lang", *lang, "error", err)
+		}
+	}
+	if err == nil && reportNode != nil {
+		_, err = flyt.Run(runCtx, reportNode, shared)
+	}
+	if err == nil && sinkNode != nil {
+		_, err = flyt.Run(runCtx, sinkNode, shared)
+	}
+	// Flush even after a failure so values saved before it are kept
+	if flushErr := FlushFlowSinks(shared); flushErr != nil {
+		err = errors.Join(err, fmt.Errorf("failed to flush sinks: %w", flushErr))
+	}
+	utils.EndSpan(span, err)
+	utils.RecordFlowRun(*mode, err)
+	logRetryBudget(budget)
+	saveResumeSnapshot(shared, *resumePath)
+	// A cancelled batch keeps its finished items; summarize them since the
+	// flow stopped before its aggregate node
+	partialShown := false
+	if cancelled, _ := shared.Get("cancelled"); err != nil && cancelled == true && *mode == "batch" {
+		partialShown = printCancelledBatch(context.WithoutCancel(runCtx), shared, batchOpts, *outputPath)
+	}
+	// Check for a signal first: a fallback may have let the flow finish anyway
+	if errors.Is(context.Cause(ctx), errInterrupted) {
+		if !partialShown {
+			printPartialResults(shared)
+		}
+		slog.Error("Flow cancelled", "mode", *mode, "error", err)
+		os.Exit(exitCancelled)
+	}
 	if err != nil {
-		log.Fatalf("❌ Flow failed: %v", err)
+		printFailureReport(os.Stderr, shared)
+		if errors.Is(err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			fatal("Flow exceeded timeout", "mode", *mode, "timeout", *timeout, "error", err)
+		}
+		fatal("Flow failed", "mode", *mode, "error", err)
 	}
 
 	// Display results based on mode
+	var heading, output string
 	switch *mode {
 	case "qa", "agent":
+		// A streamed answer is already on stdout
+		if streamed, _ := shared.Get("answer_streamed"); streamed == true && *outputPath == "" {
+			break
+		}
 		if answer, ok := shared.Get("answer"); ok {
-			fmt.Println("\n✅ Answer:")
-			fmt.Println(answer)
+			heading = "\n✅ Answer:"
+			output = fmt.Sprint(answer)
 		}
 
 	case "batch":
 		if results, ok := shared.Get("final_results"); ok {
-			fmt.Println("\n✅ Batch Processing Complete:")
-			fmt.Println(results)
+			heading = "\n✅ Batch Processing Complete:"
+			output = fmt.Sprint(results)
+			if *jsonOut {
+				data, err := json.MarshalIndent(results, "", "  ")
+				if err != nil {
+					fatal("Failed to encode results", "error", err)
+				}
+				heading = ""
+				output = string(data)
+			}
+		}
+
+	case "summarize":
+		if summary, ok := shared.Get("summary"); ok {
+			heading = "\n✅ Summary:"
+			output = fmt.Sprint(summary)
 		}
 	}
 
-	fmt.Println("\n🎉 Flow completed successfully!")
+	if *outputPath != "" {
+		if err := writeOutput(*outputPath, output); err != nil {
+			fatal("Failed to write output", "path", *outputPath, "error", err)
+		}
+		slog.Info("Results written", "path", *outputPath)
+	} else if output != "" {
+		if heading != "" {
+			printStatus(heading + "\n")
+		}
+		if *mode != "batch" {
+			output = formatAnswer(output)
+		}
+		fmt.Println(output)
+	}
+	if report, ok := shared.Get("report"); ok && *reportPath != "" {
+		if err := writeOutput(*reportPath, strings.TrimRight(fmt.Sprint(report), "\n")); err != nil {
+			fatal("Failed to write report", "path", *reportPath, "error", err)
+		}
+		slog.Info("Report written", "path", *reportPath)
+	}
+	if score, ok := shared.Get("answer_confidence"); ok && *mode == "qa" {
+		reason, _ := shared.Get("confidence_reason")
+		printStatus(fmt.Sprintf("\nConfidence: %v/100 %v\n", score, reason))
+	}
+
+	printCostReport(ctx, shared)
+
+	// Summarize where the time went when debugging
+	if debug {
+		timings.log()
+	}
+
+	slog.Info("Flow completed", "mode", *mode)
+}
+
+// loadPromptTemplate parses -prompt-template, reading it from a file when
+// the value names one and using it as the template text otherwise
+func loadPromptTemplate(value string) (*template.Template, error) {
+	text := value
+	if info, err := os.Stat(value); err == nil && info.Mode().IsRegular() {
+		data, err := os.ReadFile(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", value, err)
+		}
+		text = string(data)
+	}
+	return ParsePromptTemplate(text)
+}
+
+// sinkTable is the table -sink writes to in a SQLite database
+const sinkTable = "flow_outputs"
+
+// newSink returns the sink for -sink: a SQLite table for .db, .sqlite, and
+// .sqlite3 paths, otherwise a JSON lines file
+func newSink(path string) (utils.ResultSink, error) {
+	if isSQLitePath(path) {
+		return utils.NewSQLiteSink(path, sinkTable)
+	}
+	return utils.NewFileSink(path), nil
+}
+
+// qaFlag is a QA mode flag that picks the flow variant
+type qaFlag struct {
+	name string
+	set  bool
+}
+
+// qaVariant returns the name of the first set flag, which picks the QA
+// flow, and the names of the other set flags, which that flow ignores.
+// The name is empty if no flag is set.
+func qaVariant(flags []qaFlag) (string, []string) {
+	var set []string
+	for _, f := range flags {
+		if f.set {
+			set = append(set, f.name)
+		}
+	}
+	if len(set) == 0 {
+		return "", nil
+	}
+	return set[0], set[1:]
+}
+
+// ignoredFlagsMessage describes flags being ignored because of used, e.g.
+// "-decompose and -validate are ignored with -moderate"
+func ignoredFlagsMessage(ignored []string, used string) string {
+	if len(ignored) == 1 {
+		return ignored[0] + " is ignored with " + used
+	}
+	last := len(ignored) - 1
+	return strings.Join(ignored[:last], ", ") + " and " + ignored[last] + " are ignored with " + used
+}
+
+// runRepeated runs flow n times for the same question and returns the
+// answers. Each run gets a copy of shared, so one run's answer never
+// reaches the next as history; the question asked in the first run is
+// reused for the rest.
+func runRepeated(ctx context.Context, flow *flyt.Flow, shared *flyt.SharedStore, n int) ([]string, error) {
+	answers := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		runShared := flyt.NewSharedStore()
+		runShared.Merge(shared.GetAll())
+
+		runCtx, span := utils.StartSpan(ctx, "flow.qa")
+		err := flow.Run(runCtx, runShared)
+		utils.EndSpan(span, err)
+		utils.RecordFlowRun("qa", err)
+		if err != nil {
+			shared.Merge(runShared.GetAll())
+			return answers, fmt.Errorf("run %d of %d: %w", i+1, n, err)
+		}
+
+		if i == 0 {
+			question, _ := runShared.Get("question")
+			shared.Set("question", question)
+		}
+		answer, _ := runShared.Get("answer")
+		answers = append(answers, fmt.Sprint(answer))
+		slog.Debug("Repeated run finished", "run", i+1, "of", n)
+	}
+	return answers, nil
+}
+
+// formatRepeated lists each answer from runRepeated, then the most common
+// one when any answer came up more than once
+func formatRepeated(answers []string) string {
+	var b strings.Builder
+	for i, answer := range answers {
+		fmt.Fprintf(&b, "## Answer %d of %d\n\n%s\n\n", i+1, len(answers), answer)
+	}
+
+	tally := utils.TallyAnswers(answers)
+	fmt.Fprintf(&b, "## Summary\n\n%d distinct answer(s) in %d runs.", len(tally), len(answers))
+	if top := tally[0]; top.Count > 1 {
+		label := "Most common"
+		if top.Count*2 > len(answers) {
+			label = "Majority"
+		}
+		fmt.Fprintf(&b, " %s answer (%d of %d):\n\n%s", label, top.Count, len(answers), top.Answer)
+	}
+	return b.String()
+}
+
+// printFailureReport writes which node a failed flow stopped in, and how
+// it got there, from the NodeError under "last_error"
+func printFailureReport(w io.Writer, shared *flyt.SharedStore) {
+	value, ok := shared.Get("last_error")
+	if !ok {
+		return
+	}
+	nodeErr, ok := value.(NodeError)
+	if !ok {
+		return
+	}
+
+	fmt.Fprintln(w, "\n❌ Flow failed:")
+	fmt.Fprintf(w, "  node:    %s (%s)\n", nodeErr.Node, nodeErr.Phase)
+	if nodeErr.From != "" {
+		fmt.Fprintf(w, "  reached: %s --%s--> %s\n", nodeErr.From, nodeErr.Action, nodeErr.Node)
+	} else {
+		fmt.Fprintln(w, "  reached: start of flow")
+	}
+	fmt.Fprintf(w, "  error:   %s\n", nodeErr.Err)
+}
+
+// nodeTimings accumulates run time per node name. Nodes that run several
+// times (e.g. in the agent loop) accumulate.
+type nodeTimings struct {
+	mu        sync.Mutex
+	durations map[string]time.Duration
+}
+
+// hooks returns flow hooks that record each node's run time
+func (t *nodeTimings) hooks() Hooks {
+	return Hooks{
+		AfterNode: func(ctx context.Context, info NodeInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			if t.durations == nil {
+				t.durations = map[string]time.Duration{}
+			}
+			t.durations[info.Name] += info.Duration
+		},
+	}
+}
+
+// log emits a debug record per node with its total run time, slowest first
+func (t *nodeTimings) log() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	names := make([]string, 0, len(t.durations))
+	for name := range t.durations {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return t.durations[names[i]] > t.durations[names[j]]
+	})
+
+	for _, name := range names {
+		slog.Debug("node timing", "node", name, "duration", t.durations[name])
+	}
+}
+
+// errInterrupted is the context cause when SIGINT or SIGTERM stops a run
+var errInterrupted = errors.New("interrupted by signal")
+
+// exitCancelled is the exit code after a signal, as shells report for SIGINT
+const exitCancelled = 130
+
+// watchSignals cancels the flow's context with errInterrupted on the first
+// SIGINT or SIGTERM, letting in-flight nodes abort through ctx. A second
+// signal exits immediately.
+func watchSignals(cancel context.CancelCauseFunc) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		sig := <-signals
+		slog.Warn("Cancelling, send the signal again to exit immediately", "signal", sig)
+		cancel(errInterrupted)
+
+		<-signals
+		os.Exit(exitCancelled)
+	}()
+}
+
+// maxPartialValueLen truncates long values in the cancellation summary
+const maxPartialValueLen = 200
+
+// printPartialResults prints everything in the shared store, so a cancelled
+// run still shows what it had produced
+func printPartialResults(shared *flyt.SharedStore) {
+	values := shared.GetAll()
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fmt.Println("\n⚠️ Cancelled, partial results:")
+	for _, key := range keys {
+		value := []rune(fmt.Sprint(values[key]))
+		if len(value) > maxPartialValueLen {
+			value = append(value[:maxPartialValueLen], '…')
+		}
+		fmt.Printf("  %s: %s\n", key, string(value))
+	}
+}
+
+// logRetryBudget reports how much of the run's retry budget was used,
+// if it had one
+func logRetryBudget(budget *utils.RetryBudget) {
+	if budget == nil {
+		return
+	}
+	slog.Info("LLM retry budget", "used", budget.Used(), "remaining", budget.Remaining())
+}
+
+// printCostReport prints the run's estimated LLM cost for -cost, which
+// attached a usage tracker to ctx
+func printCostReport(ctx context.Context, shared *flyt.SharedStore) {
+	if utils.UsageTrackerFromContext(ctx) == nil {
+		return
+	}
+	if _, err := flyt.Run(context.WithoutCancel(ctx), CreateCostReportNode(), shared); err != nil {
+		slog.Warn("Failed to estimate LLM cost", "error", err)
+	}
+}
+
+// saveResumeSnapshot saves shared for -resume, if set. Failing to save
+// is only a warning, since the run itself already finished.
+func saveResumeSnapshot(shared *flyt.SharedStore, path string) {
+	if path == "" {
+		return
+	}
+	if err := SaveSnapshot(shared, path); err != nil {
+		slog.Warn("Failed to save snapshot", "path", path, "error", err)
+		return
+	}
+	slog.Debug("Snapshot saved", "path", path)
+}
+
+// printCancelledBatch aggregates a cancelled batch's finished items as
+// opts would have and writes them to outputPath, or stdout when it's
+// empty. It reports whether they were shown.
+func printCancelledBatch(ctx context.Context, shared *flyt.SharedStore, opts BatchFlowOptions, outputPath string) bool {
+	if _, err := flyt.Run(ctx, batchAggregateNode(opts), shared); err != nil {
+		slog.Warn("Failed to aggregate partial batch results", "error", err)
+		return false
+	}
+
+	results, _ := shared.Get("final_results")
+	output := fmt.Sprint(results)
+	if opts.JSON {
+		data, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			slog.Warn("Failed to encode partial batch results", "error", err)
+			return false
+		}
+		output = string(data)
+	}
+
+	if outputPath != "" {
+		if err := writeOutput(outputPath, output); err != nil {
+			slog.Warn("Failed to write partial batch results", "path", outputPath, "error", err)
+			return false
+		}
+		slog.Info("Partial results written", "path", outputPath)
+		return true
+	}
+	printStatus("\n⚠️ Batch cancelled, partial results:\n")
+	fmt.Println(output)
+	return true
+}
+
+// runREPL reads questions from in and answers each with flow, reusing
+// shared so the conversation history carries over between turns. Answers
+// are printed through format. It returns on EOF or a /quit command.
+func runREPL(ctx context.Context, flow *flyt.Flow, shared *flyt.SharedStore, in io.Reader, format func(string) string) error {
+	printStatus("Interactive mode. Type /quit or press Ctrl-D to exit.\n")
+
+	scanner := bufio.NewScanner(in)
+	for turn := 1; ; {
+		printStatus(fmt.Sprintf("\n[%d] > ", turn))
+		if !scanner.Scan() {
+			printStatus("\n")
+			return scanner.Err()
+		}
+
+		question := strings.TrimSpace(scanner.Text())
+		switch question {
+		case "":
+			continue
+		case "/quit":
+			return nil
+		}
+
+		shared.Set("question", question)
+		turnCtx, span := utils.StartSpan(ctx, "flow.repl")
+		err := flow.Run(turnCtx, shared)
+		utils.EndSpan(span, err)
+		if err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			slog.Error("Turn failed", "turn", turn, "error", err)
+			continue
+		}
+
+		answer, _ := shared.Get("answer")
+		fmt.Println(format(fmt.Sprint(answer)))
+		turn++
+	}
+}
+
+// isTerminal reports whether f is an interactive terminal rather than a
+// pipe or file
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// printStatus writes progress text such as headings and prompts to stdout,
+// unless -log-level quiet asked for results only
+func printStatus(text string) {
+	if slog.Default().Enabled(context.Background(), slog.LevelInfo) {
+		fmt.Print(text)
+	}
+}
+
+// fatal logs an error through the configured logger and exits non-zero
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// writeOutput writes flow results to path, creating parent directories as needed
+func writeOutput(path, output string) error {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(path, []byte(output+"\n"), 0o644)
 }
 
 // Example of how to run the application:
@@ -105,11 +952,130 @@ func main() {
 // Basic Q&A mode:
 //   go run .
 //
+// Q&A mode with answer validation:
+//   go run . -validate
+//
+// Q&A mode with the answer streamed as it is generated:
+//   go run . -stream
+//
+// Q&A mode with the question as an argument, piped in, or read from a file:
+//   go run . "What is Go?"
+//   echo "What is Go?" | go run .
+//   go run . -question-file question.txt
+//
+// Q&A mode splitting complex questions into sub-questions:
+//   go run . -decompose
+//
+// Q&A mode regenerating answers the model isn't confident in:
+//   go run . -min-confidence 70
+//
+// Q&A mode asking the same question five times to compare answers:
+//   go run . -n 5 -temperature 1
+//
+// Q&A mode answering from a local file:
+//   go run . -doc README.md
+//
+// Q&A mode refusing questions flagged by moderation:
+//   go run . -moderate
+//
+// Q&A mode with the answer translated into Spanish:
+//   go run . -lang es
+//
+// Interactive mode, keeping the last 5 turns as context:
+//   go run . -mode repl -history-turns 5
+//
+// Serving the QA flow over HTTP:
+//   go run . -mode serve -addr :8080 -answer-cache 1000
+//   curl -d '{"question": "What is the capital of France?"}' localhost:8080/ask
+//   curl -N -d '{"items": ["a", "b"]}' localhost:8080/batch
+//
 // Agent mode with a question:
 //   go run . -mode agent "What is the capital of France?"
 //
+// Agent mode with numbered citations and a list of the sources used:
+//   go run . -mode agent -cite "Who won the 2022 World Cup?"
+//
+// Agent mode using function calling for search and arithmetic:
+//   go run . -mode agent -tools "What is 15% of the population of France?"
+//
 // Batch processing mode:
 //   go run . -mode batch
 //
-// With verbose output:
+// Batch processing items from a file:
+//   go run . -mode batch -input items.txt
+//
+// Batch processing a CSV column and writing results as CSV:
+//   go run . -mode batch -input prompts.csv -csv-column 1 -csv-header -csv-out results.csv
+//
+// Batch processing the pending rows of a SQLite table and saving results
+// back to them:
+//   go run . -mode batch -input jobs.db -sqlite-out jobs.db -sqlite-table jobs
+//
+// Saving each answer as a JSON line (or to the flow_outputs table of a
+// .db file):
+//   go run . -sink answers.jsonl "What is the capital of France?"
+//
+// Writing a markdown report with the sources the agent found:
+//   go run . -mode agent -report-file report.md "Who invented the telephone?"
+//
+// Writing results to a file:
+//   go run . -mode batch -json -output out/results.json
+//
+// Printing batch results as an aligned table:
+//   go run . -mode batch -table -input items.txt
+//
+// Summarizing a folder of text and markdown files into one summary:
+//   go run . -mode summarize -docs-dir notes/
+//
+// Customizing the answer prompt, inline or from a file:
+//   go run . -prompt-template 'Answer in one sentence: {{.Question}}' "Why is the sky blue?"
+//   go run . -prompt-template prompts/answer.tmpl "Why is the sky blue?"
+//
+// Showing the model example answers first, from a JSON array of
+// {"role": "user"|"assistant", "content": "..."} messages:
+//   go run . -few-shot examples.json "What is the capital of Peru?"
+//
+// Choosing the model and temperature:
+//   go run . -model gpt-4o -temperature 0.2
+//
+// Estimating what a run cost (prices are in utils.ModelPrices):
+//   go run . -mode agent -cost "What is new in Go 1.23?"
+//
+// Keeping an agent's state across restarts (the snapshot is saved after each
+// node and when the run ends, even after a failure or Ctrl-C, and restored on
+// the next run, which starts the flow again from its first node):
+//   go run . -mode agent -resume state.json "Compare Go and Rust"
+//
+// Capping the length (and cost) of every completion:
+//   go run . -max-tokens 300
+//
+// Reproducible answers (best effort; -v logs the system fingerprint, and
+// replies only repeat while it stays the same):
+//   go run . -seed 42 -temperature 0
+//
+// Failing fast on a degraded LLM endpoint over many calls:
+//   go run . -adaptive-timeout -n 20 "What is the capital of France?"
+//
+// Inspecting a flow's nodes and actions without running it:
+//   go run . -dry-run -mode agent
+//
+// Rendering a flow diagram with Graphviz:
+//   go run . -mode agent -export-dot agent.dot && dot -Tpng agent.dot -o agent.png
+//
+// With settings from a config file (flyt.config.json is read by default):
+//   go run . -config settings.yaml
+//
+// Caching LLM responses on disk while iterating on prompts:
+//   FLYT_LLM_CACHE=1 go run . -temperature 0
+//
+// With a deadline on the whole run:
+//   go run . -timeout 30s -mode agent "What is the capital of France?"
+//
+// With verbose (debug) output:
 //   go run . -v -mode qa
+//
+// Printing only the answer, for scripts:
+//   echo "What is Go?" | go run . -log-level quiet
+//
+// With JSON logs for log pipelines:
+//   go run . -log-format json -mode batch
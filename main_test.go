@@ -0,0 +1,55 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestQAVariantReportsEveryIgnoredFlag(t *testing.T) {
+	tests := []struct {
+		name        string
+		flags       []qaFlag
+		wantVariant string
+		wantIgnored []string
+		wantMessage string
+	}{
+		{
+			name:        "no flags",
+			flags:       []qaFlag{{"-doc", false}, {"-stream", false}},
+			wantVariant: "",
+		},
+		{
+			name:        "one flag",
+			flags:       []qaFlag{{"-doc", false}, {"-validate", true}},
+			wantVariant: "-validate",
+		},
+		{
+			name:        "moderate over decompose and min-confidence",
+			flags:       []qaFlag{{"-doc", false}, {"-moderate", true}, {"-decompose", true}, {"-min-confidence", true}},
+			wantVariant: "-moderate",
+			wantIgnored: []string{"-decompose", "-min-confidence"},
+			wantMessage: "-decompose and -min-confidence are ignored with -moderate",
+		},
+		{
+			name:        "doc over everything",
+			flags:       []qaFlag{{"-doc", true}, {"-moderate", true}, {"-validate", true}, {"-stream", true}},
+			wantVariant: "-doc",
+			wantIgnored: []string{"-moderate", "-validate", "-stream"},
+			wantMessage: "-moderate, -validate and -stream are ignored with -doc",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			variant, ignored := qaVariant(tt.flags)
+			if variant != tt.wantVariant || !slices.Equal(ignored, tt.wantIgnored) {
+				t.Errorf("qaVariant() = %q, %v, want %q, %v", variant, ignored, tt.wantVariant, tt.wantIgnored)
+			}
+			if len(ignored) > 0 {
+				if got := ignoredFlagsMessage(ignored, variant); got != tt.wantMessage {
+					t.Errorf("ignoredFlagsMessage() = %q, want %q", got, tt.wantMessage)
+				}
+			}
+		})
+	}
+}
@@ -2,26 +2,62 @@ package main
 
 import (
 	"bufio"
+	"cmp"
 	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"maps"
 	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"text/template"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/mark3labs/flyt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"flyt-project-template/utils"
 )
 
-// CreateGetQuestionNode creates a node that gets a question from user input
+// llmConfigFrom returns the *utils.LLMConfig stored under "llm_config",
+// or the default configuration when none was set
+func llmConfigFrom(shared *flyt.SharedStore) *utils.LLMConfig {
+	if value, ok := shared.Get("llm_config"); ok {
+		if config, ok := value.(*utils.LLMConfig); ok {
+			return config
+		}
+	}
+	return utils.DefaultLLMConfig()
+}
+
+// CreateGetQuestionNode creates a node that gets a question from user input.
+// A question already in the shared store (e.g. from an HTTP request) is
+// used as is, without prompting.
 func CreateGetQuestionNode() flyt.Node {
 	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			question, _ := shared.Get("question")
+			return question, nil
+		}),
 		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
-			// Get question from user input
-			reader := bufio.NewReader(os.Stdin)
-			fmt.Print("Enter your question: ")
-			userQuestion, err := reader.ReadString('\n')
-			if err != nil {
-				return nil, err
+			if question, ok := prepResult.(string); ok && question != "" {
+				return question, nil
 			}
-			return strings.TrimSpace(userQuestion), nil
+
+			// Get question from user input
+			return readQuestion(os.Stdin)
 		}),
 		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
 			// Store the user's question
@@ -31,7 +67,34 @@ func CreateGetQuestionNode() flyt.Node {
 	)
 }
 
-// CreateAnswerNode creates a node that generates an answer using LLM
+// readQuestion reads a question from in: all of it when in is a pipe or
+// file, or one line after a prompt when it is a terminal
+func readQuestion(in *os.File) (string, error) {
+	if !isTerminal(in) {
+		data, err := io.ReadAll(in)
+		if err != nil {
+			return "", fmt.Errorf("failed to read question from stdin: %w", err)
+		}
+		return trimQuestion(string(data)), nil
+	}
+
+	printStatus("Enter your question: ")
+	line, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return trimQuestion(line), nil
+}
+
+// trimQuestion strips the surrounding whitespace and trailing newlines
+// every question input path may leave behind
+func trimQuestion(question string) string {
+	return strings.TrimSpace(question)
+}
+
+// CreateAnswerNode creates a node that generates an answer using LLM.
+// Previous turns stored under "history" are sent along so follow-up
+// questions keep their conversational context.
 func CreateAnswerNode() flyt.Node {
 	return flyt.NewNode(
 		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
@@ -44,196 +107,3852 @@ func CreateAnswerNode() flyt.Node {
 			// Get any additional context
 			context, _ := shared.Get("context")
 
+			// Get previous conversation turns, if any
+			history, _ := shared.Get("history")
+
 			return map[string]any{
 				"question": question,
 				"context":  context,
+				"history":  history,
+				"config":   llmConfigFrom(shared),
+				"template": promptTemplateFrom(shared),
 			}, nil
 		}),
 		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
 			data := prepResult.(map[string]any)
-			question := data["question"].(string)
-
-			// Get API key from environment
-			apiKey := os.Getenv("OPENAI_API_KEY")
-			if apiKey == "" {
-				return nil, fmt.Errorf("OPENAI_API_KEY not set")
+			prompt, err := renderPrompt(data["template"].(*template.Template), data["question"].(string), data["context"])
+			if err != nil {
+				return nil, err
 			}
 
-			// Call LLM to get the answer
-			prompt := fmt.Sprintf("Answer this question: %s", question)
-			if data["context"] != nil {
-				prompt = fmt.Sprintf("Context: %s\n\nAnswer this question: %s", data["context"], question)
+			// Build the conversation: system prompt, prior turns, then this question
+			messages := []utils.Message{
+				{Role: "system", Content: utils.DefaultSystemPrompt},
+			}
+			if history, ok := data["history"].([]utils.Message); ok {
+				messages = append(messages, history...)
 			}
+			messages = append(messages, utils.Message{Role: "user", Content: prompt})
 
-			// TODO: Implement CallLLM function in utils/llm.go
-			// For now, return a placeholder
-			_ = prompt // Will be used when CallLLM is implemented
-			return fmt.Sprintf("This is a placeholder answer for: %s", question), nil
+			config := data["config"].(*utils.LLMConfig)
+			return utils.CallLLMConversation(ctx, messages, config)
 		}),
 		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
 			// Store the answer in shared store
 			shared.Set("answer", execResult)
+
+			// Record this turn so later questions can refer back to it
+			data := prepResult.(map[string]any)
+			history, _ := data["history"].([]utils.Message)
+			history = append(history,
+				utils.Message{Role: "user", Content: data["question"].(string)},
+				utils.Message{Role: "assistant", Content: execResult.(string)},
+			)
+			shared.Set("history", history)
+
 			return flyt.DefaultAction, nil
 		}),
 	)
 }
 
-// CreateAnalyzeNode creates a node that analyzes input and decides next action
-func CreateAnalyzeNode() flyt.Node {
+// forgetAnswer removes the turn CreateAnswerNode recorded for answer from
+// "history", so a regenerated answer isn't asked with the rejected one as a
+// prior turn and history keeps one question/answer pair per question
+func forgetAnswer(shared *flyt.SharedStore, answer any) {
+	value, _ := shared.Get("history")
+	history, _ := value.([]utils.Message)
+	n := len(history)
+	if n < 2 || history[n-2].Role != "user" || history[n-1].Role != "assistant" || history[n-1].Content != answer {
+		return
+	}
+	shared.Set("history", slices.Clone(history[:n-2]))
+}
+
+// DefaultPromptTemplate is the answer prompt used unless one is stored
+// under "prompt_template", e.g. by -prompt-template
+const DefaultPromptTemplate = `{{if .Context}}Context: {{.Context}}
+
+{{end}}Answer this question: {{.Question}}`
+
+// PromptData is what an answer prompt template can reference
+type PromptData struct {
+	Question string
+	Context  string
+}
+
+var defaultPromptTemplate = template.Must(ParsePromptTemplate(DefaultPromptTemplate))
+
+// ParsePromptTemplate parses a text/template for answer prompts. The
+// template is test-rendered so that references to fields PromptData
+// doesn't have fail here rather than partway through a flow.
+func ParsePromptTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("prompt").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid prompt template: %w", err)
+	}
+	if err := tmpl.Execute(io.Discard, PromptData{Question: "question", Context: "context"}); err != nil {
+		return nil, fmt.Errorf("invalid prompt template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// promptTemplateFrom returns the *template.Template stored under
+// "prompt_template", or the default answer prompt when none was set
+func promptTemplateFrom(shared *flyt.SharedStore) *template.Template {
+	if value, ok := shared.Get("prompt_template"); ok {
+		if tmpl, ok := value.(*template.Template); ok {
+			return tmpl
+		}
+	}
+	return defaultPromptTemplate
+}
+
+// renderPrompt fills tmpl with the question and any context
+func renderPrompt(tmpl *template.Template, question string, context any) (string, error) {
+	data := PromptData{Question: question}
+	if context != nil {
+		data.Context = fmt.Sprint(context)
+	}
+
+	var prompt strings.Builder
+	if err := tmpl.Execute(&prompt, data); err != nil {
+		return "", fmt.Errorf("failed to render prompt template: %w", err)
+	}
+	return prompt.String(), nil
+}
+
+// answerPrompt builds the default user prompt for an answer, including
+// any context
+func answerPrompt(question string, context any) string {
+	// The default template is known to render
+	prompt, _ := renderPrompt(defaultPromptTemplate, question, context)
+	return prompt
+}
+
+// answerCacheNode serves answers from a cache, see CreateAnswerNodeWithCache
+type answerCacheNode struct {
+	flyt.Node
+	cache utils.AnswerCache
+}
+
+// answerCachePrep carries the wrapped node's prep result and the cache
+// lookup from Prep to Exec and Post
+type answerCachePrep struct {
+	inner    any
+	question string // empty when the cache is bypassed
+	answer   string
+	hit      bool
+}
+
+// CreateAnswerNodeWithCache creates an answer node that looks "question" up
+// in cache first: a hit stores the cached answer without calling the LLM,
+// and a miss caches the generated answer. Questions asked with earlier
+// "history" bypass the cache, since their answers depend on the
+// conversation. Other "context" is not part of the key, so share a cache
+// only between flows that supply the same context for a question.
+func CreateAnswerNodeWithCache(cache utils.AnswerCache) flyt.Node {
+	return &answerCacheNode{Node: CreateAnswerNode(), cache: cache}
+}
+
+// Prep implements flyt.Node
+func (n *answerCacheNode) Prep(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+	inner, err := n.Node.Prep(ctx, shared)
+	if err != nil {
+		return nil, err
+	}
+
+	prep := answerCachePrep{inner: inner}
+	history, _ := shared.Get("history")
+	if turns, _ := history.([]utils.Message); len(turns) == 0 {
+		question, _ := shared.Get("question")
+		prep.question, _ = question.(string)
+	}
+	if prep.question != "" {
+		prep.answer, prep.hit = n.cache.Get(prep.question)
+	}
+	return prep, nil
+}
+
+// Exec implements flyt.Node, skipping the wrapped exec on a cache hit
+func (n *answerCacheNode) Exec(ctx context.Context, prepResult any) (any, error) {
+	prep := prepResult.(answerCachePrep)
+	if prep.hit {
+		slog.DebugContext(ctx, "answer cache hit", "question", prep.question)
+		return prep.answer, nil
+	}
+	return n.Node.Exec(ctx, prep.inner)
+}
+
+// Post implements flyt.Node
+func (n *answerCacheNode) Post(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+	prep := prepResult.(answerCachePrep)
+	if answer, ok := execResult.(string); ok && !prep.hit && prep.question != "" {
+		n.cache.Set(prep.question, answer)
+	}
+	return n.Node.Post(ctx, shared, prep.inner, execResult)
+}
+
+// GetMaxRetries preserves the wrapped node's retry settings
+func (n *answerCacheNode) GetMaxRetries() int {
+	if retryable, ok := n.Node.(flyt.RetryableNode); ok {
+		return retryable.GetMaxRetries()
+	}
+	return 1
+}
+
+// GetWait preserves the wrapped node's retry settings
+func (n *answerCacheNode) GetWait() time.Duration {
+	if retryable, ok := n.Node.(flyt.RetryableNode); ok {
+		return retryable.GetWait()
+	}
+	return 0
+}
+
+// ExecFallback forwards to the wrapped node's fallback
+func (n *answerCacheNode) ExecFallback(prepResult any, err error) (any, error) {
+	if fallback, ok := n.Node.(flyt.FallbackNode); ok {
+		return fallback.ExecFallback(prepResult.(answerCachePrep).inner, err)
+	}
+	return nil, err
+}
+
+// CreateStreamingAnswerNode creates a node that streams the answer to stdout
+// as it is generated, under the same "✅ Answer:" heading main prints for
+// buffered answers, and stores the full text in "answer". It sets
+// "answer_streamed" so the caller knows not to print the answer again.
+// Streaming sends only the current prompt, not earlier "history" turns.
+func CreateStreamingAnswerNode() flyt.Node {
 	return flyt.NewNode(
 		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
 			question, ok := shared.Get("question")
 			if !ok {
 				return nil, fmt.Errorf("no question found in shared store")
 			}
-			searchResults, _ := shared.Get("search_results")
+			context, _ := shared.Get("context")
+			history, _ := shared.Get("history")
 
 			return map[string]any{
-				"question":       question,
-				"search_results": searchResults,
+				"question": question,
+				"context":  context,
+				"history":  history,
+				"config":   llmConfigFrom(shared),
+				"template": promptTemplateFrom(shared),
 			}, nil
-		}), flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
 			data := prepResult.(map[string]any)
+			prompt, err := renderPrompt(data["template"].(*template.Template), data["question"].(string), data["context"])
+			if err != nil {
+				return nil, err
+			}
+			config := data["config"].(*utils.LLMConfig)
 
-			// Simple logic to decide next action
-			// In a real implementation, this could use an LLM to make decisions
-			if data["search_results"] == nil {
-				// No search results yet, might need to search
-				return "search", nil
+			var answer strings.Builder
+			err = utils.CallLLMStreamingWithConfig(ctx, prompt, config, func(chunk string) error {
+				if answer.Len() == 0 {
+					printStatus("\n✅ Answer:\n")
+				}
+				answer.WriteString(chunk)
+				_, err := fmt.Print(chunk)
+				return err
+			})
+			// End the streamed line, even after a partial answer
+			if answer.Len() > 0 {
+				fmt.Println()
+			}
+			if err != nil {
+				return nil, err
 			}
 
-			// We have search results, process them
-			return "process", nil
+			return answer.String(), nil
 		}),
 		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
-			action := execResult.(string)
-			return flyt.Action(action), nil
+			shared.Set("answer", execResult)
+			shared.Set("answer_streamed", true)
+
+			data := prepResult.(map[string]any)
+			history, _ := data["history"].([]utils.Message)
+			history = append(history,
+				utils.Message{Role: "user", Content: data["question"].(string)},
+				utils.Message{Role: "assistant", Content: execResult.(string)},
+			)
+			shared.Set("history", history)
+
+			return flyt.DefaultAction, nil
 		}),
 	)
 }
 
-// CreateSearchNode creates a node that performs web search
-func CreateSearchNode() flyt.Node {
+// KeyTokenCallback is the shared store key for an optional TokenCallback
+// that CreateBufferedStreamingAnswerNode calls with each streamed chunk
+const KeyTokenCallback = "on_token"
+
+// TokenCallback receives each chunk of a streamed answer. It runs on the
+// goroutine reading the stream; returning an error stops the stream.
+type TokenCallback func(chunk string) error
+
+// StreamBuffer collects a streamed answer. Unlike a bare strings.Builder
+// it can be read by other goroutines, such as a web handler, while the
+// stream is still writing to it.
+type StreamBuffer struct {
+	mu   sync.Mutex
+	text strings.Builder
+	done bool
+	err  error
+}
+
+// WriteString appends a chunk
+func (b *StreamBuffer) WriteString(s string) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.text.WriteString(s)
+}
+
+// String returns the text streamed so far
+func (b *StreamBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.text.String()
+}
+
+// Done reports whether the stream has ended, and the error that ended it
+// early if any
+func (b *StreamBuffer) Done() (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.done, b.err
+}
+
+// reset clears the buffer before a stream (re)starts
+func (b *StreamBuffer) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.text.Reset()
+	b.done, b.err = false, nil
+}
+
+// finish marks the stream as ended
+func (b *StreamBuffer) finish(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.done, b.err = true, err
+}
+
+// safeTokenCallback serializes calls to callback and turns a panic into an
+// error, so a misbehaving callback stops the stream instead of the process
+func safeTokenCallback(callback TokenCallback) TokenCallback {
+	var mu sync.Mutex
+	return func(chunk string) (err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("token callback panicked: %v", r)
+			}
+		}()
+		return callback(chunk)
+	}
+}
+
+// CreateBufferedStreamingAnswerNode creates a node that streams the answer
+// into a *StreamBuffer under bufferKey instead of stdout, so other
+// goroutines can show it as it arrives. A buffer already stored under
+// bufferKey is reused. Each chunk is also passed to the TokenCallback
+// under KeyTokenCallback, if set; its error stops the stream and fails the
+// node. The full text is stored in "answer" once the stream completes.
+func CreateBufferedStreamingAnswerNode(bufferKey string) flyt.Node {
 	return flyt.NewNode(
 		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
 			question, ok := shared.Get("question")
 			if !ok {
 				return nil, fmt.Errorf("no question found in shared store")
 			}
-			return question, nil
+			context, _ := shared.Get("context")
+
+			var callback TokenCallback
+			if value, ok := shared.Get(KeyTokenCallback); ok {
+				switch fn := value.(type) {
+				case TokenCallback:
+					callback = fn
+				case func(string) error:
+					callback = fn
+				default:
+					return nil, fmt.Errorf("%s must be a TokenCallback, got %T", KeyTokenCallback, value)
+				}
+			}
+
+			// Publish the buffer before streaming so readers can find it
+			value, _ := shared.Get(bufferKey)
+			buffer, ok := value.(*StreamBuffer)
+			if !ok {
+				buffer = &StreamBuffer{}
+				shared.Set(bufferKey, buffer)
+			}
+
+			return map[string]any{
+				"question": question,
+				"context":  context,
+				"config":   llmConfigFrom(shared),
+				"template": promptTemplateFrom(shared),
+				"buffer":   buffer,
+				"callback": callback,
+			}, nil
 		}),
 		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
-			if prepResult == nil {
-				return nil, fmt.Errorf("no question to search for")
+			data := prepResult.(map[string]any)
+			prompt, err := renderPrompt(data["template"].(*template.Template), data["question"].(string), data["context"])
+			if err != nil {
+				return nil, err
 			}
-			question := prepResult.(string)
+			config := data["config"].(*utils.LLMConfig)
+			buffer := data["buffer"].(*StreamBuffer)
 
-			// TODO: Implement actual web search
-			// For now, return mock results
-			results := fmt.Sprintf("Mock search results for: %s", question)
+			onChunk := func(chunk string) error {
+				_, err := buffer.WriteString(chunk)
+				return err
+			}
+			if callback, _ := data["callback"].(TokenCallback); callback != nil {
+				callback = safeTokenCallback(callback)
+				onChunk = func(chunk string) error {
+					buffer.WriteString(chunk)
+					return callback(chunk)
+				}
+			}
+
+			// A retry starts the answer over
+			buffer.reset()
+			err = utils.CallLLMStreamingWithConfig(ctx, prompt, config, onChunk)
+			buffer.finish(err)
+			if err != nil {
+				return nil, err
+			}
 
-			return results, nil
+			return buffer.String(), nil
 		}),
 		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
-			shared.Set("search_results", execResult)
+			shared.Set("answer", execResult)
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
 
-			// Go back to analyze to decide what to do with results
-			return "analyze", nil
+// CreateTrimHistoryNode creates a node that keeps only the last maxTurns
+// question/answer pairs in "history", so long conversations don't grow the
+// prompt without bound. A maxTurns of zero or less keeps everything.
+func CreateTrimHistoryNode(maxTurns int) flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			value, _ := shared.Get("history")
+			history, _ := value.([]utils.Message)
+			if maxTurns > 0 && len(history) > maxTurns*2 {
+				// Each turn is a user message followed by an assistant reply
+				trimmed := make([]utils.Message, maxTurns*2)
+				copy(trimmed, history[len(history)-maxTurns*2:])
+				shared.Set("history", trimmed)
+			}
+			return flyt.DefaultAction, nil
 		}),
 	)
 }
 
-// CreateProcessNode creates a node that processes information
-func CreateProcessNode() flyt.Node {
+// AnalyzeDecision is the structured reply the analyze step asks the LLM for
+type AnalyzeDecision struct {
+	Action string `json:"action"`
+	Reason string `json:"reason"`
+}
+
+// analyzeDecisionSchema is the JSON Schema an AnalyzeDecision reply must match
+const analyzeDecisionSchema = `{
+  "type": "object",
+  "required": ["action", "reason"],
+  "properties": {
+    "action": {"enum": ["search", "process", "answer"]},
+    "reason": {"type": "string"}
+  }
+}`
+
+// analyzeActions are the only actions the analyze node may route to
+var analyzeActions = map[string]bool{
+	"search":  true,
+	"process": true,
+	"answer":  true,
+}
+
+// CreateAnalyzeNode creates a node that analyzes input and decides next action
+func CreateAnalyzeNode() flyt.Node {
 	return flyt.NewNode(
 		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
-			question, _ := shared.Get("question")
+			question, ok := shared.Get("question")
+			if !ok {
+				return nil, fmt.Errorf("no question found in shared store")
+			}
 			searchResults, _ := shared.Get("search_results")
 
 			return map[string]any{
 				"question":       question,
 				"search_results": searchResults,
+				"config":         llmConfigFrom(shared),
 			}, nil
-		}),
-		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+		}), flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
 			data := prepResult.(map[string]any)
 
-			// Process the search results
-			// In a real implementation, this could extract key information,
-			// summarize, or transform the data
-			_ = data // Will be used when processing is implemented
-			processed := fmt.Sprintf("Processed information from search results")
+			// Without an LLM, fall back to a simple heuristic
+			if !utils.HasLLMCredentials() {
+				if data["search_results"] == nil {
+					// No search results yet, might need to search
+					return AnalyzeDecision{Action: "search", Reason: "no search results yet"}, nil
+				}
 
-			return processed, nil
-		}), flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
-			shared.Set("context", execResult)
-			return flyt.DefaultAction, nil
+				// We have search results, process them
+				return AnalyzeDecision{Action: "process", Reason: "search results available"}, nil
+			}
+
+			resultsText := "None yet."
+			if results, ok := data["search_results"].([]utils.SearchResult); ok {
+				resultsText = utils.FormatSearchResults(results)
+			}
+
+			prompt := fmt.Sprintf(`You are deciding the next step for a research agent.
+
+Question: %s
+
+Search results so far:
+%s
+
+Choose exactly one action:
+- "search": more information is needed from the web
+- "process": the search results contain what is needed and should be processed
+- "answer": the question can be answered directly without searching
+
+Reply as JSON: {"action": "search|process|answer", "reason": "short explanation"}`, data["question"], resultsText)
+
+			var decision AnalyzeDecision
+			config := data["config"].(*utils.LLMConfig)
+			if err := utils.CallLLMJSONSchema(ctx, prompt, analyzeDecisionSchema, &decision, config); err != nil {
+				return nil, err
+			}
+
+			decision.Action = strings.ToLower(strings.TrimSpace(decision.Action))
+			if !analyzeActions[decision.Action] {
+				return nil, fmt.Errorf("LLM chose unknown action %q (allowed: search, process, answer)", decision.Action)
+			}
+
+			return decision, nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			decision := execResult.(AnalyzeDecision)
+			shared.Set("decision", decision.Action)
+			return flyt.Action(decision.Action), nil
 		}),
 	)
 }
 
-// CreateLoadItemsNode creates a node that loads items for batch processing
-func CreateLoadItemsNode() flyt.Node {
+// CreateResetIterationsNode creates a node that zeroes "iteration_count",
+// "clarification_rounds", and "reformulation_count" so each agent run
+// starts with a fresh loop budget
+func CreateResetIterationsNode() flyt.Node {
 	return flyt.NewNode(
-		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
-			// Load items from a source (file, API, database, etc.)
-			// For demo, create some sample items
-			items := []string{
-				"Item 1",
-				"Item 2",
-				"Item 3",
-				"Item 4",
-				"Item 5",
-			}
-
-			return items, nil
-		}),
 		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
-			shared.Set(flyt.KeyItems, execResult)
+			shared.Set("iteration_count", 0)
+			shared.Set("clarification_rounds", 0)
+			shared.Set("reformulation_count", 0)
 			return flyt.DefaultAction, nil
 		}),
 	)
 }
 
-// CreateBatchProcessNode creates a node that processes items in batch
-func CreateBatchProcessNode() flyt.Node {
-	processFunc := func(ctx context.Context, item any) (any, error) {
-		// Process each item
-		itemStr := item.(string)
-		return fmt.Sprintf("Processed: %s", itemStr), nil
-	}
+// ActionClarify is the action the clarify node takes after adding the
+// user's clarification to the question, to judge it again
+const ActionClarify flyt.Action = "clarify"
 
-	// Use Flyt's built-in batch node
-	return flyt.NewBatchNode(processFunc, true) // true for concurrent processing
-}
+// maxClarifyRounds caps how many clarifying questions one run may ask
+const maxClarifyRounds = 2
 
-// CreateAggregateResultsNode creates a node that aggregates batch results
-func CreateAggregateResultsNode() flyt.Node {
+// CreateClarifyNode creates a node that asks the LLM whether "question" is
+// clear enough to answer. If not, it prints the model's clarifying
+// question, reads a reply from stdin, appends it to "question", and takes
+// ActionClarify so the question is judged again. It proceeds unchanged
+// once the question is clear, after maxClarifyRounds, on an empty reply,
+// or straight away when stdin isn't a terminal.
+func CreateClarifyNode() flyt.Node {
 	return flyt.NewNode(
 		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
-			results, ok := shared.Get(flyt.KeyResults)
+			question, ok := shared.Get("question")
 			if !ok {
-				return nil, fmt.Errorf("no results found")
+				return nil, fmt.Errorf("no question found in shared store")
 			}
-			return results, nil
+			rounds, _ := shared.Get("clarification_rounds")
+			count, _ := rounds.(int)
+
+			return map[string]any{
+				"question": question,
+				"rounds":   count,
+				"config":   llmConfigFrom(shared),
+			}, nil
 		}),
 		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
-			results := prepResult.([]any)
+			data := prepResult.(map[string]any)
+			if data["rounds"].(int) >= maxClarifyRounds || !isTerminal(os.Stdin) {
+				return "", nil
+			}
 
-			// Aggregate results
-			var aggregated strings.Builder
-			aggregated.WriteString("Aggregated Results:\n")
+			prompt := fmt.Sprintf(`Decide whether this question can be answered well as asked, or is too vague or ambiguous.
 
-			for i, result := range results {
-				aggregated.WriteString(fmt.Sprintf("%d. %v\n", i+1, result))
+Question: %s
+
+Respond with a JSON object: {"clear": true} if it can be answered, otherwise {"clear": false, "clarifying_question": "one short question to ask the user"}.`, data["question"])
+
+			var judgment struct {
+				Clear              bool   `json:"clear"`
+				ClarifyingQuestion string `json:"clarifying_question"`
+			}
+			if err := utils.CallLLMJSON(ctx, prompt, &judgment, data["config"].(*utils.LLMConfig)); err != nil {
+				return nil, err
+			}
+			if judgment.Clear || judgment.ClarifyingQuestion == "" {
+				return "", nil
 			}
 
-			return aggregated.String(), nil
+			printStatus(fmt.Sprintf("\n❓ %s\n> ", judgment.ClarifyingQuestion))
+			reply, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			if err != nil && err != io.EOF {
+				return nil, err
+			}
+			return strings.TrimSpace(reply), nil
 		}),
 		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
-			shared.Set("final_results", execResult)
-			fmt.Println(execResult)
+			reply := execResult.(string)
+			if reply == "" {
+				return flyt.DefaultAction, nil
+			}
+
+			data := prepResult.(map[string]any)
+			shared.Set("question", fmt.Sprintf("%s\n\nClarification: %s", data["question"], reply))
+			shared.Set("clarification_rounds", data["rounds"].(int)+1)
+			return ActionClarify, nil
+		}),
+	)
+}
+
+// CreateLoopGuardNode creates a node that counts analyze passes in
+// "iteration_count" and routes to "answer" once maxIterations is exceeded,
+// so an agent that keeps choosing "search" can't loop forever
+func CreateLoopGuardNode(maxIterations int) flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			count, _ := shared.Get("iteration_count")
+			n, _ := count.(int)
+			return n, nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			count := prepResult.(int) + 1
+			shared.Set("iteration_count", count)
+
+			if count > maxIterations {
+				return "answer", nil
+			}
 			return flyt.DefaultAction, nil
 		}),
 	)
 }
+
+// CreateSearchNode creates a node that performs web search using the given searcher
+func CreateSearchNode(searcher utils.Searcher) flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			question, ok := shared.Get("question")
+			if !ok {
+				return nil, fmt.Errorf("no question found in shared store")
+			}
+			return question, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			if prepResult == nil {
+				return nil, fmt.Errorf("no question to search for")
+			}
+			question := prepResult.(string)
+
+			return searcher.Search(ctx, question)
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			shared.Set("search_results", execResult)
+
+			// Go back to analyze to decide what to do with results
+			return "analyze", nil
+		}),
+	)
+}
+
+// ActionReformulate is the action the reformulate node takes to search
+// again with a rewritten query
+const ActionReformulate flyt.Action = "reformulate"
+
+// maxReformulations caps how many times one run may rewrite its query
+const maxReformulations = 2
+
+// CreateReformulateNode creates a node that checks the latest
+// "search_results". When there are none, or none shares a meaningful term
+// with the question, it asks the LLM to rewrite the query, stores it under
+// "question" (keeping the user's question under "original_question"), and
+// takes ActionReformulate to search again. Otherwise, or after
+// maxReformulations rewrites per run, it restores the original question
+// and takes "analyze". Rewrites are counted in "reformulation_count".
+func CreateReformulateNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			question, ok := shared.Get("question")
+			if !ok {
+				return nil, fmt.Errorf("no question found in shared store")
+			}
+			results, _ := shared.Get("search_results")
+			count, _ := shared.Get("reformulation_count")
+			attempts, _ := count.(int)
+
+			original := question
+			if attempts > 0 {
+				if value, ok := shared.Get("original_question"); ok {
+					original = value
+				}
+			}
+
+			return map[string]any{
+				"query":          question,
+				"original":       original,
+				"search_results": results,
+				"attempts":       attempts,
+				"config":         llmConfigFrom(shared),
+			}, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data := prepResult.(map[string]any)
+			query := data["query"].(string)
+			results, _ := data["search_results"].([]utils.SearchResult)
+
+			if searchResultsRelevant(query, results) ||
+				data["attempts"].(int) >= maxReformulations || !utils.HasLLMCredentials() {
+				return "", nil
+			}
+
+			prompt := fmt.Sprintf(`A web search for the query below found nothing useful. Rewrite it as a better search query for the same question: use different or more specific keywords, drop filler words, and keep names and key terms.
+
+Question: %s
+Failed query: %s
+
+Reply with only the new query.`, data["original"], query)
+
+			config := data["config"].(*utils.LLMConfig).WithoutFewShot()
+			rewritten, err := utils.CallLLMConversation(ctx, utils.PromptMessages(prompt), config)
+			if err != nil {
+				// Carry on with the results we have rather than fail the run
+				slog.WarnContext(ctx, "query reformulation failed", "error", err)
+				return "", nil
+			}
+			rewritten = strings.Trim(strings.TrimSpace(rewritten), `"'`)
+			if rewritten == "" || strings.EqualFold(rewritten, query) {
+				return "", nil
+			}
+
+			slog.DebugContext(ctx, "reformulated search query", "from", query, "to", rewritten)
+			return rewritten, nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			data := prepResult.(map[string]any)
+			attempts := data["attempts"].(int)
+			rewritten := execResult.(string)
+
+			if rewritten == "" {
+				// Answer the user's question, not the last search query
+				if attempts > 0 {
+					shared.Set("question", data["original"])
+				}
+				return "analyze", nil
+			}
+
+			if attempts == 0 {
+				shared.Set("original_question", data["original"])
+			}
+			shared.Set("question", rewritten)
+			shared.Set("reformulation_count", attempts+1)
+			return ActionReformulate, nil
+		}),
+	)
+}
+
+// searchResultsRelevant reports whether any result's title, snippet, or
+// description contains a non-stop-word term of query
+func searchResultsRelevant(query string, results []utils.SearchResult) bool {
+	terms := map[string]bool{}
+	for _, token := range utils.TokenizeText(query) {
+		if utf8.RuneCountInString(token) >= 2 && !utils.IsStopWord(token) {
+			terms[token] = true
+		}
+	}
+	// A query of only stop words can't be judged; trust the results
+	if len(terms) == 0 {
+		return len(results) > 0
+	}
+
+	for _, result := range results {
+		for _, token := range utils.TokenizeText(result.Title + " " + result.Snippet + " " + result.Description) {
+			if terms[token] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CreateMultiSearchNode creates a search node that queries every searcher
+// concurrently and stores their merged results (see utils.MergeResults),
+// ranked by relevance to the question with utils.RankResults.
+// A failing searcher is logged and skipped; the node fails only when all of
+// them do. It routes like CreateSearchNode, so it can replace it in a flow.
+func CreateMultiSearchNode(searchers ...utils.Searcher) flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			question, ok := shared.Get("question")
+			if !ok {
+				return nil, fmt.Errorf("no question found in shared store")
+			}
+			return question, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			if prepResult == nil {
+				return nil, fmt.Errorf("no question to search for")
+			}
+			if len(searchers) == 0 {
+				return nil, fmt.Errorf("no searchers configured")
+			}
+			question := prepResult.(string)
+
+			lists := make([][]utils.SearchResult, len(searchers))
+			errs := make([]error, len(searchers))
+
+			var wg sync.WaitGroup
+			for i, searcher := range searchers {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					lists[i], errs[i] = searcher.Search(ctx, question)
+				}()
+			}
+			wg.Wait()
+
+			failed := 0
+			for i, err := range errs {
+				if err != nil {
+					failed++
+					slog.WarnContext(ctx, "searcher failed", "searcher", fmt.Sprintf("%T", searchers[i]), "error", err)
+				}
+			}
+			if failed == len(searchers) {
+				return nil, fmt.Errorf("all %d searchers failed: %w", failed, errors.Join(errs...))
+			}
+
+			return utils.RankResults(question, utils.MergeResults(lists...)), nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			shared.Set("search_results", execResult)
+
+			// Go back to analyze to decide what to do with results
+			return "analyze", nil
+		}),
+	)
+}
+
+// CreateProcessNode creates a node that processes information
+func CreateProcessNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			question, _ := shared.Get("question")
+			searchResults, _ := shared.Get("search_results")
+
+			return map[string]any{
+				"question":       question,
+				"search_results": searchResults,
+			}, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data := prepResult.(map[string]any)
+
+			// Turn the search results into context for the answer node
+			// In a real implementation, this could extract key information,
+			// summarize, or transform the data
+			results, _ := data["search_results"].([]utils.SearchResult)
+			processed := utils.FormatSearchResults(results)
+
+			return processed, nil
+		}), flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			shared.Set("context", execResult)
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+var (
+	// citationRe matches [n] and [n, m] citations with any space before them
+	citationRe = regexp.MustCompile(`[ \t]*\[(\d+(?:\s*,\s*\d+)*)\]`)
+
+	// codeRe matches fenced code blocks and inline code, which may contain
+	// brackets that aren't citations
+	codeRe = regexp.MustCompile("(?s)```.*?```|`[^`\n]+`")
+)
+
+// CreateCitedAnswerNode creates a node that answers from the numbered
+// "search_results", asking the model to cite the results it uses as [n].
+// Citations to results that don't exist are removed, and a references
+// section listing each cited result's URL is appended. The answer goes
+// to "answer" and the cited results, in citation number order, to
+// "sources". Without search results it answers like CreateAnswerNode.
+func CreateCitedAnswerNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			question, ok := shared.Get("question")
+			if !ok {
+				return nil, fmt.Errorf("no question found in shared store")
+			}
+			results, _ := shared.Get("search_results")
+			context, _ := shared.Get("context")
+
+			return map[string]any{
+				"question":       question,
+				"search_results": results,
+				"context":        context,
+				"config":         llmConfigFrom(shared),
+			}, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data := prepResult.(map[string]any)
+			question := data["question"].(string)
+			results, _ := data["search_results"].([]utils.SearchResult)
+			config := data["config"].(*utils.LLMConfig)
+
+			if len(results) == 0 {
+				answer, err := utils.CallLLMConversation(ctx, utils.PromptMessages(answerPrompt(question, data["context"])), config)
+				if err != nil {
+					return nil, err
+				}
+				return citedAnswer{answer: answer, sources: []utils.SearchResult{}}, nil
+			}
+
+			var numbered strings.Builder
+			for i, result := range results {
+				numbered.WriteString(fmt.Sprintf("[%d] %s\nURL: %s\n%s\n\n", i+1, result.Title, result.URL, result.Snippet))
+			}
+			prompt := fmt.Sprintf(`Answer the question using the numbered search results below. After each statement that relies on a result, cite it by number in square brackets, like [1] or [2][3]. Only cite results you actually used, and don't add a list of references.
+
+Search results:
+%s
+Question: %s`, numbered.String(), question)
+
+			answer, err := utils.CallLLMConversation(ctx, utils.PromptMessages(prompt), config)
+			if err != nil {
+				return nil, err
+			}
+
+			answer, sources := citeSources(answer, results)
+			return citedAnswer{answer: answer, sources: sources}, nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			cited := execResult.(citedAnswer)
+			shared.Set("answer", cited.answer)
+			shared.Set("sources", cited.sources)
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// citedAnswer is the result of CreateCitedAnswerNode's exec
+type citedAnswer struct {
+	answer  string
+	sources []utils.SearchResult
+}
+
+// citeSources drops citations of results that don't exist from answer,
+// outside of code, and appends a references section for the rest. It
+// returns the new answer and the cited results in number order.
+func citeSources(answer string, results []utils.SearchResult) (string, []utils.SearchResult) {
+	cited := map[int]bool{}
+	rewrite := func(text string) string {
+		return citationRe.ReplaceAllStringFunc(text, func(match string) string {
+			inner := citationRe.FindStringSubmatch(match)[1]
+			var kept []string
+			for _, field := range strings.Split(inner, ",") {
+				n, err := strconv.Atoi(strings.TrimSpace(field))
+				if err != nil || n < 1 || n > len(results) {
+					continue
+				}
+				cited[n] = true
+				kept = append(kept, strconv.Itoa(n))
+			}
+			if len(kept) == 0 {
+				return ""
+			}
+			space := match[:strings.IndexByte(match, '[')]
+			return space + "[" + strings.Join(kept, ", ") + "]"
+		})
+	}
+
+	// Rewrite only the text between code spans and blocks
+	var b strings.Builder
+	last := 0
+	for _, loc := range codeRe.FindAllStringIndex(answer, -1) {
+		b.WriteString(rewrite(answer[last:loc[0]]))
+		b.WriteString(answer[loc[0]:loc[1]])
+		last = loc[1]
+	}
+	b.WriteString(rewrite(answer[last:]))
+	answer = strings.TrimRight(b.String(), " \t\n")
+
+	sources := []utils.SearchResult{}
+	if len(cited) == 0 {
+		return answer, sources
+	}
+
+	var refs strings.Builder
+	refs.WriteString("\n\nReferences:")
+	for n := 1; n <= len(results); n++ {
+		if !cited[n] {
+			continue
+		}
+		result := results[n-1]
+		sources = append(sources, result)
+		refs.WriteString(fmt.Sprintf("\n[%d] %s: %s", n, result.Title, result.URL))
+	}
+
+	return answer + refs.String(), sources
+}
+
+// Token sizes for CreateSummarizeSearchNode
+const (
+	defaultSearchSummaryTokens = 600
+	searchSummaryChunkTokens   = 1500
+)
+
+// SummarizeSearchOption configures CreateSummarizeSearchNode
+type SummarizeSearchOption func(*summarizeSearchOptions)
+
+type summarizeSearchOptions struct {
+	maxTokens int
+}
+
+// WithSummaryTokenBudget caps the summary stored as "context" at about
+// maxTokens tokens, measured with utils.CountTokens
+func WithSummaryTokenBudget(maxTokens int) SummarizeSearchOption {
+	return func(o *summarizeSearchOptions) {
+		o.maxTokens = maxTokens
+	}
+}
+
+// CreateSummarizeSearchNode creates a node that condenses "search_results"
+// into a summary stored as "context", so the answer prompt doesn't carry
+// every raw snippet. Results that already fit the token budget (600 by
+// default) are used as is. Otherwise groups of results are summarized
+// map-reduce style with mapReduceSummarize, under the run's context and
+// "llm_config".
+func CreateSummarizeSearchNode(opts ...SummarizeSearchOption) flyt.Node {
+	o := summarizeSearchOptions{maxTokens: defaultSearchSummaryTokens}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			searchResults, _ := shared.Get("search_results")
+			results, _ := searchResults.([]utils.SearchResult)
+			return map[string]any{
+				"results": results,
+				"config":  llmConfigFrom(shared),
+			}, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data := prepResult.(map[string]any)
+			results := data["results"].([]utils.SearchResult)
+			formatted := utils.FormatSearchResults(results)
+			if utils.CountTokens(formatted) <= o.maxTokens {
+				return formatted, nil
+			}
+
+			// Summarize groups of results that fit one prompt
+			groups := groupSearchResults(results, searchSummaryChunkTokens)
+			config := data["config"].(*utils.LLMConfig).WithoutFewShot()
+			summary, err := mapReduceSummarize(ctx, groups, o.maxTokens, config, flyt.DefaultBatchConfig().MaxConcurrency)
+			if err != nil {
+				return nil, err
+			}
+
+			slog.DebugContext(ctx, "summarized search results",
+				"results", len(results), "groups", len(groups), "tokens", utils.CountTokens(summary))
+			return summary, nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			shared.Set("context", execResult)
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// groupSearchResults formats results into texts of about maxTokens tokens
+// each, keeping every result whole
+func groupSearchResults(results []utils.SearchResult, maxTokens int) []string {
+	var groups []string
+	var current strings.Builder
+	for _, result := range results {
+		entry := fmt.Sprintf("%s (%s)\n%s\n\n", result.Title, result.URL, result.Snippet)
+		if current.Len() > 0 && utils.CountTokens(current.String()+entry) > maxTokens {
+			groups = append(groups, current.String())
+			current.Reset()
+		}
+		current.WriteString(entry)
+	}
+	if current.Len() > 0 {
+		groups = append(groups, current.String())
+	}
+	return groups
+}
+
+// maxPageContextChars caps how much fetched page text is added to the context
+const maxPageContextChars = 8000
+
+// CreateFetchPageNode creates a node that downloads the top search result's
+// page and appends its text to "context", since snippets alone are often
+// too short to answer from
+func CreateFetchPageNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			searchResults, _ := shared.Get("search_results")
+			results, _ := searchResults.([]utils.SearchResult)
+			if len(results) == 0 || results[0].URL == "" {
+				return nil, nil
+			}
+			return results[0].URL, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			if prepResult == nil {
+				return "", nil
+			}
+			pageURL := prepResult.(string)
+
+			ctx, cancel := context.WithTimeout(ctx, 20*time.Second)
+			defer cancel()
+
+			text, err := utils.FetchPageText(ctx, pageURL)
+			if err != nil {
+				return nil, err
+			}
+			if len(text) > maxPageContextChars {
+				// Drop any rune split by the byte cut
+				text = strings.ToValidUTF8(text[:maxPageContextChars], "")
+			}
+			return text, nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			text, _ := execResult.(string)
+			if text == "" {
+				return flyt.DefaultAction, nil
+			}
+
+			existing, _ := shared.Get("context")
+			pageContext := fmt.Sprintf("Content of %s:\n%s", prepResult, text)
+			if existingText, _ := existing.(string); existingText != "" {
+				pageContext = existingText + "\n\n" + pageContext
+			}
+			shared.Set("context", pageContext)
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// ToolFunc runs a tool with the JSON arguments object the model chose
+type ToolFunc func(ctx context.Context, arguments json.RawMessage) (string, error)
+
+// Tool is a function the tool agent can offer to the model
+type Tool struct {
+	Description string
+
+	// Parameters is the JSON Schema of the arguments object
+	Parameters map[string]any
+
+	Run ToolFunc
+}
+
+// SearchTool lets the model run web searches with searcher
+func SearchTool(searcher utils.Searcher) Tool {
+	return Tool{
+		Description: "Search the web. Returns titles, URLs, and snippets of the top results.",
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"query": map[string]any{"type": "string", "description": "The search query"},
+			},
+			"required": []string{"query"},
+		},
+		Run: func(ctx context.Context, arguments json.RawMessage) (string, error) {
+			var args struct {
+				Query string `json:"query"`
+			}
+			if err := json.Unmarshal(arguments, &args); err != nil {
+				return "", fmt.Errorf("invalid arguments: %w", err)
+			}
+			results, err := searcher.Search(ctx, args.Query)
+			if err != nil {
+				return "", err
+			}
+			return utils.FormatSearchResults(results), nil
+		},
+	}
+}
+
+// CalculatorTool lets the model evaluate arithmetic exactly
+func CalculatorTool() Tool {
+	return Tool{
+		Description: "Evaluate an arithmetic expression using + - * / and parentheses.",
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"expression": map[string]any{"type": "string", "description": "For example (2 + 3) * 4"},
+			},
+			"required": []string{"expression"},
+		},
+		Run: func(ctx context.Context, arguments json.RawMessage) (string, error) {
+			var args struct {
+				Expression string `json:"expression"`
+			}
+			if err := json.Unmarshal(arguments, &args); err != nil {
+				return "", fmt.Errorf("invalid arguments: %w", err)
+			}
+			result, err := utils.EvaluateExpression(args.Expression)
+			if err != nil {
+				return "", err
+			}
+			return strconv.FormatFloat(result, 'g', -1, 64), nil
+		},
+	}
+}
+
+// maxToolRounds caps how many rounds of tool calls the tool agent runs
+// before it makes the model answer with what it has
+const maxToolRounds = 5
+
+// CreateToolAgentNode creates a node that answers "question" using the
+// model's function calling: each requested tool is run and its result (or
+// error) is sent back, until the model gives a final answer. After
+// maxToolRounds the model is told to answer without tools.
+func CreateToolAgentNode(tools map[string]Tool) flyt.Node {
+	names := make([]string, 0, len(tools))
+	for name := range tools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	defs := make([]utils.ToolDef, len(names))
+	for i, name := range names {
+		defs[i] = utils.ToolDef{Name: name, Description: tools[name].Description, Parameters: tools[name].Parameters}
+	}
+
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			question, ok := shared.Get("question")
+			if !ok {
+				return nil, fmt.Errorf("no question found in shared store")
+			}
+			history, _ := shared.Get("history")
+
+			return map[string]any{
+				"question": question,
+				"history":  history,
+				"config":   llmConfigFrom(shared),
+			}, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data := prepResult.(map[string]any)
+
+			messages := []utils.Message{
+				{Role: "system", Content: utils.DefaultSystemPrompt + " Use the available tools when they help you answer accurately."},
+			}
+			if history, ok := data["history"].([]utils.Message); ok {
+				messages = append(messages, history...)
+			}
+			messages = append(messages, utils.Message{Role: "user", Content: data["question"].(string)})
+
+			config := *data["config"].(*utils.LLMConfig)
+			for round := 0; ; round++ {
+				if round == maxToolRounds {
+					config.ToolChoice = "none"
+				}
+
+				response, err := utils.CallLLMWithTools(ctx, messages, defs, &config)
+				if err != nil {
+					return nil, err
+				}
+				if len(response.ToolCalls) == 0 {
+					return response.Content, nil
+				}
+				if round >= maxToolRounds {
+					return nil, fmt.Errorf("model kept calling tools after %d rounds", maxToolRounds)
+				}
+
+				messages = append(messages, response.Message())
+				for _, call := range response.ToolCalls {
+					slog.DebugContext(ctx, "tool call", "tool", call.Function.Name, "arguments", call.Function.Arguments)
+
+					// Report failures to the model so it can recover
+					var output string
+					tool, ok := tools[call.Function.Name]
+					if !ok {
+						output = fmt.Sprintf("error: unknown tool %q", call.Function.Name)
+					} else if result, err := tool.Run(ctx, json.RawMessage(call.Function.Arguments)); err != nil {
+						output = "error: " + err.Error()
+					} else {
+						output = result
+					}
+
+					messages = append(messages, utils.Message{Role: "tool", ToolCallID: call.ID, Content: output})
+				}
+			}
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			shared.Set("answer", execResult)
+
+			data := prepResult.(map[string]any)
+			history, _ := data["history"].([]utils.Message)
+			history = append(history,
+				utils.Message{Role: "user", Content: data["question"].(string)},
+				utils.Message{Role: "assistant", Content: execResult.(string)},
+			)
+			shared.Set("history", history)
+
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// CreateLoadItemsNode creates a node that loads items for batch processing
+func CreateLoadItemsNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			// Load items from a source (file, API, database, etc.)
+			// For demo, create some sample items
+			items := []string{
+				"Item 1",
+				"Item 2",
+				"Item 3",
+				"Item 4",
+				"Item 5",
+			}
+
+			return items, nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			shared.Set(flyt.KeyItems, execResult)
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// CreateItemsNode creates a node that stores the given items for batch
+// processing, e.g. items received in an HTTP request
+func CreateItemsNode(items []string) flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			shared.Set(flyt.KeyItems, items)
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// CreateLoadItemsFromFileNode creates a node that loads batch items from a file.
+// .json files must contain an array of strings; any other extension is read
+// as plain text with one item per line, skipping blank lines.
+func CreateLoadItemsFromFileNode(path string) flyt.Node {
+	return flyt.NewNode(
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read items from %s: %w", path, err)
+			}
+
+			if strings.EqualFold(filepath.Ext(path), ".json") {
+				var items []string
+				if err := json.Unmarshal(data, &items); err != nil {
+					return nil, fmt.Errorf("failed to parse %s as a JSON array of strings: %w", path, err)
+				}
+				return items, nil
+			}
+
+			var items []string
+			for _, line := range strings.Split(string(data), "\n") {
+				if line = strings.TrimSpace(line); line != "" {
+					items = append(items, line)
+				}
+			}
+
+			return items, nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			shared.Set(flyt.KeyItems, execResult)
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// DocumentChunk is one piece of a document loaded by CreateLoadDocumentNode
+type DocumentChunk struct {
+	Text string
+
+	// Embedding is nil unless the document was loaded WithDocumentEmbeddings
+	Embedding []float32
+}
+
+// Document chunking and context budget for document Q&A
+const (
+	documentChunkWords       = 200
+	documentChunkOverlap     = 40
+	maxDocumentContextTokens = 3000
+)
+
+// DocumentOption configures CreateLoadDocumentNode
+type DocumentOption func(*documentOptions)
+
+type documentOptions struct {
+	embed bool
+}
+
+// WithDocumentEmbeddings embeds each chunk when the document is loaded, so
+// CreateDocumentQANode can pick chunks by similarity instead of keywords
+func WithDocumentEmbeddings() DocumentOption {
+	return func(o *documentOptions) {
+		o.embed = true
+	}
+}
+
+// CreateLoadDocumentNode creates a node that reads a text or markdown file,
+// splits it into overlapping chunks, and stores them under
+// "document_chunks" as []DocumentChunk
+func CreateLoadDocumentNode(path string, opts ...DocumentOption) flyt.Node {
+	var o documentOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return flyt.NewNode(
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read document %s: %w", path, err)
+			}
+			if !utf8.Valid(data) {
+				return nil, fmt.Errorf("document %s is not a UTF-8 text file", path)
+			}
+
+			texts, err := utils.ChunkTextWithOverlap(string(data), documentChunkWords, documentChunkOverlap)
+			if err != nil {
+				return nil, err
+			}
+			if len(texts) == 0 {
+				return nil, fmt.Errorf("document %s is empty", path)
+			}
+
+			chunks := make([]DocumentChunk, len(texts))
+			for i, text := range texts {
+				chunks[i].Text = text
+			}
+
+			if o.embed {
+				// Reloading the same document reuses cached chunk vectors
+				embeddings, err := utils.GetEmbeddingsCached(ctx, texts, utils.EmbeddingModel)
+				if err != nil {
+					return nil, fmt.Errorf("failed to embed document %s: %w", path, err)
+				}
+				for i := range chunks {
+					chunks[i].Embedding = embeddings[i]
+				}
+			}
+
+			return chunks, nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			shared.Set("document_chunks", execResult)
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// CreateDocumentQANode creates a node that picks the "document_chunks"
+// most relevant to "question" and stores them under "context" for the
+// answer node. Chunks are ranked by embedding similarity when they have
+// embeddings, otherwise by keyword overlap, and the best ones are taken
+// until about maxDocumentContextTokens tokens, so documents larger than the
+// context window still fit. Selected chunks keep their document order.
+func CreateDocumentQANode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			question, ok := shared.Get("question")
+			if !ok {
+				return nil, fmt.Errorf("no question found in shared store")
+			}
+			chunks, ok := shared.Get("document_chunks")
+			if !ok {
+				return nil, fmt.Errorf("no document_chunks found in shared store")
+			}
+
+			return map[string]any{
+				"question": question,
+				"chunks":   chunks,
+			}, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data := prepResult.(map[string]any)
+			question := data["question"].(string)
+			chunks := data["chunks"].([]DocumentChunk)
+
+			var order []int
+			if len(chunks) > 0 && chunks[0].Embedding != nil {
+				queryEmbedding, err := utils.GetEmbedding(ctx, question)
+				if err != nil {
+					return nil, fmt.Errorf("failed to embed question: %w", err)
+				}
+				embeddings := make([][]float32, len(chunks))
+				for i, chunk := range chunks {
+					embeddings[i] = chunk.Embedding
+				}
+				order = utils.RankEmbeddings(queryEmbedding, embeddings)
+			} else {
+				texts := make([]string, len(chunks))
+				for i, chunk := range chunks {
+					texts[i] = chunk.Text
+				}
+				order = utils.RankTexts(question, texts)
+			}
+
+			// Take the best chunks that fit the budget, always at least one
+			var selected []int
+			tokens := 0
+			for _, index := range order {
+				cost := utils.CountTokens(chunks[index].Text)
+				if len(selected) > 0 && tokens+cost > maxDocumentContextTokens {
+					break
+				}
+				selected = append(selected, index)
+				tokens += cost
+			}
+			sort.Ints(selected)
+
+			excerpts := make([]string, len(selected))
+			for i, index := range selected {
+				excerpts[i] = chunks[index].Text
+			}
+
+			slog.DebugContext(ctx, "selected document chunks", "chunks", len(selected), "of", len(chunks), "tokens", tokens)
+			return strings.Join(excerpts, "\n\n...\n\n"), nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			shared.Set("context", execResult)
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// documentExtensions are the file types CreateLoadDocumentsNode reads
+var documentExtensions = []string{".txt", ".md", ".markdown"}
+
+// CreateLoadDocumentsNode creates a node that reads every text or markdown
+// file directly inside dir, in name order, and stores their contents under
+// "documents" as []string and their paths under "document_paths". Empty
+// files are skipped.
+func CreateLoadDocumentsNode(dir string) flyt.Node {
+	return flyt.NewNode(
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read document folder %s: %w", dir, err)
+			}
+
+			var paths, documents []string
+			for _, entry := range entries {
+				if entry.IsDir() || !slices.Contains(documentExtensions, strings.ToLower(filepath.Ext(entry.Name()))) {
+					continue
+				}
+				path := filepath.Join(dir, entry.Name())
+				data, err := os.ReadFile(path)
+				if err != nil {
+					return nil, fmt.Errorf("failed to read document %s: %w", path, err)
+				}
+				if !utf8.Valid(data) {
+					return nil, fmt.Errorf("document %s is not a UTF-8 text file", path)
+				}
+				if strings.TrimSpace(string(data)) == "" {
+					slog.WarnContext(ctx, "Skipping empty document", "path", path)
+					continue
+				}
+				paths = append(paths, path)
+				documents = append(documents, string(data))
+			}
+			if len(documents) == 0 {
+				return nil, fmt.Errorf("no text or markdown documents found in %s", dir)
+			}
+
+			return map[string][]string{
+				"paths":     paths,
+				"documents": documents,
+			}, nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			loaded := execResult.(map[string][]string)
+			shared.Set("documents", loaded["documents"])
+			shared.Set("document_paths", loaded["paths"])
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// Map-reduce summarization chunking and budgets
+const (
+	mapReduceChunkWords    = 600
+	mapReduceChunkOverlap  = 50
+	mapReduceSummaryTokens = 800
+	mapReduceGroupTokens   = 2000
+	mapReduceMinWords      = 50
+)
+
+// MapReduceOption configures CreateMapReduceSummaryNode
+type MapReduceOption func(*mapReduceOptions)
+
+type mapReduceOptions struct {
+	maxTokens   int
+	concurrency int
+}
+
+// WithMapReduceTokenBudget stops reducing once the summaries together fit
+// in about maxTokens tokens, measured with utils.CountTokens
+func WithMapReduceTokenBudget(maxTokens int) MapReduceOption {
+	return func(o *mapReduceOptions) {
+		o.maxTokens = maxTokens
+	}
+}
+
+// WithMapReduceConcurrency caps how many chunks or summary groups are
+// summarized at once
+func WithMapReduceConcurrency(maxConcurrent int) MapReduceOption {
+	return func(o *mapReduceOptions) {
+		o.concurrency = maxConcurrent
+	}
+}
+
+// CreateMapReduceSummaryNode creates a node that summarizes "documents"
+// ([]string) into one summary stored as "summary". A single document that
+// already fits the token budget (800 by default) is passed straight
+// through. Otherwise every document is chunked and the chunks are
+// summarized as a batch (map) under the same concurrency controls as
+// CreateBatchProcessNodeWithConcurrency. Groups of summaries are then
+// summarized together (reduce), round after round, until one summary
+// remains or they all fit the budget.
+func CreateMapReduceSummaryNode(opts ...MapReduceOption) flyt.Node {
+	o := mapReduceOptions{
+		maxTokens:   mapReduceSummaryTokens,
+		concurrency: flyt.DefaultBatchConfig().MaxConcurrency,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			value, ok := shared.Get("documents")
+			if !ok {
+				return nil, fmt.Errorf("no documents found in shared store")
+			}
+
+			var documents []string
+			for _, document := range flyt.ToSlice(value) {
+				if text := strings.TrimSpace(fmt.Sprint(document)); text != "" {
+					documents = append(documents, text)
+				}
+			}
+
+			return map[string]any{
+				"documents": documents,
+				"config":    llmConfigFrom(shared),
+			}, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data := prepResult.(map[string]any)
+			documents := data["documents"].([]string)
+			config := data["config"].(*utils.LLMConfig).WithoutFewShot()
+
+			if len(documents) == 0 {
+				return nil, fmt.Errorf("no documents to summarize")
+			}
+			if len(documents) == 1 && utils.CountTokens(documents[0]) <= o.maxTokens {
+				return documents[0], nil
+			}
+
+			var chunks []string
+			for _, document := range documents {
+				texts, err := utils.ChunkTextWithOverlap(document, mapReduceChunkWords, mapReduceChunkOverlap)
+				if err != nil {
+					return nil, err
+				}
+				chunks = append(chunks, texts...)
+			}
+
+			slog.DebugContext(ctx, "summarizing document chunks", "documents", len(documents), "chunks", len(chunks))
+			return mapReduceSummarize(ctx, chunks, o.maxTokens, config, o.concurrency)
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			shared.Set("summary", execResult)
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// mapReduceSummarize summarizes texts into one summary of about maxTokens
+// tokens, running at most maxConcurrent LLM calls at once. Each text is
+// summarized (map), then groups of summaries are summarized until they fit
+// (reduce).
+func mapReduceSummarize(ctx context.Context, texts []string, maxTokens int, config *utils.LLMConfig, maxConcurrent int) (string, error) {
+	// Map: summarize every text
+	summaries, err := summarizeTexts(ctx, texts, mapReduceWords(maxTokens, len(texts)), config, maxConcurrent)
+	if err != nil {
+		return "", err
+	}
+
+	// Reduce: summarize groups of summaries until they fit. Every group
+	// holds at least two, so each round has fewer summaries.
+	for round := 1; len(summaries) > 1 && utils.CountTokens(strings.Join(summaries, "\n\n")) > maxTokens; round++ {
+		groups := groupSummaries(summaries, mapReduceGroupTokens)
+		summaries, err = summarizeTexts(ctx, groups, mapReduceWords(maxTokens, len(groups)), config, maxConcurrent)
+		if err != nil {
+			return "", err
+		}
+		slog.DebugContext(ctx, "reduced summaries", "round", round, "summaries", len(summaries))
+	}
+
+	// The model may overshoot, so enforce the budget
+	return utils.FitToTokenBudget(strings.Join(summaries, "\n\n"), maxTokens), nil
+}
+
+// mapReduceWords is how many words each of n summaries may use so that
+// together they roughly fit maxTokens tokens
+func mapReduceWords(maxTokens, n int) int {
+	return max(maxTokens*3/4/n, mapReduceMinWords)
+}
+
+// summaryItem is one text summarized by summarizeTexts
+type summaryItem struct {
+	text     string
+	maxWords int
+	config   *utils.LLMConfig
+}
+
+// summarizeTexts summarizes each text in at most maxWords words with a
+// batch node running up to maxConcurrent at once, returning the summaries
+// in order
+func summarizeTexts(ctx context.Context, texts []string, maxWords int, config *utils.LLMConfig, maxConcurrent int) ([]string, error) {
+	items := make([]summaryItem, len(texts))
+	for i, text := range texts {
+		items[i] = summaryItem{text: text, maxWords: maxWords, config: config}
+	}
+
+	batch := flyt.NewSharedStore()
+	batch.Set(flyt.KeyItems, items)
+	if _, err := flyt.Run(ctx, CreateBatchProcessNodeWithConcurrency(summarizeItem, maxConcurrent, false), batch); err != nil {
+		return nil, err
+	}
+	// A cancelled batch stores its partial results without an error
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	results, _ := batch.Get(flyt.KeyResults)
+	summaries := make([]string, 0, len(texts))
+	for _, result := range flyt.ToSlice(results) {
+		summaries = append(summaries, fmt.Sprint(result))
+	}
+	return summaries, nil
+}
+
+// summarizeItem is the per-item work done by summarizeTexts. Texts that
+// are already short enough are kept as they are.
+func summarizeItem(ctx context.Context, item any) (any, error) {
+	summary := item.(summaryItem)
+	if utils.CountWords(summary.text) <= summary.maxWords {
+		return summary.text, nil
+	}
+	if !utils.HasLLMCredentials() {
+		return utils.SummarizeTextWithMaxLength(summary.text, summary.maxWords)
+	}
+
+	prompt := fmt.Sprintf("Summarize the following text in at most %d words, keeping the key facts.\n\n%s", summary.maxWords, summary.text)
+	reply, err := utils.CallLLMConversation(ctx, utils.PromptMessages(prompt), summary.config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to summarize text: %w", err)
+	}
+	return strings.TrimSpace(reply), nil
+}
+
+// groupSummaries joins summaries into texts of about maxTokens tokens
+// each, in order. A group always takes at least two summaries, so there
+// are fewer groups than summaries.
+func groupSummaries(summaries []string, maxTokens int) []string {
+	var groups []string
+	for start := 0; start < len(summaries); {
+		end := min(start+2, len(summaries))
+		tokens := utils.CountTokens(strings.Join(summaries[start:end], "\n\n"))
+		for end < len(summaries) {
+			cost := utils.CountTokens(summaries[end])
+			if tokens+cost > maxTokens {
+				break
+			}
+			tokens += cost
+			end++
+		}
+		groups = append(groups, strings.Join(summaries[start:end], "\n\n"))
+		start = end
+	}
+	return groups
+}
+
+// CSVOption configures the CSV batch nodes
+type CSVOption func(*csvOptions)
+
+type csvOptions struct {
+	header bool
+}
+
+// WithCSVHeader treats the first input row as a header and skips it, and
+// writes a header row on output
+func WithCSVHeader() CSVOption {
+	return func(o *csvOptions) {
+		o.header = true
+	}
+}
+
+func applyCSVOptions(opts []CSVOption) csvOptions {
+	var o csvOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// CreateLoadItemsFromCSVNode creates a node that loads the given zero-based
+// column of each CSV row as a batch item
+func CreateLoadItemsFromCSVNode(path string, column int, opts ...CSVOption) flyt.Node {
+	options := applyCSVOptions(opts)
+
+	return flyt.NewNode(
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			file, err := os.Open(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read items from %s: %w", path, err)
+			}
+			defer file.Close()
+
+			reader := csv.NewReader(file)
+			reader.FieldsPerRecord = -1 // Rows may have differing field counts
+
+			var items []string
+			for row := 0; ; row++ {
+				record, err := reader.Read()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					// csv.ParseError already reports the line number
+					return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+				}
+
+				if row == 0 && options.header {
+					continue
+				}
+
+				line, _ := reader.FieldPos(0)
+				if column < 0 || column >= len(record) {
+					return nil, fmt.Errorf("%s line %d: column %d out of range (row has %d fields)", path, line, column, len(record))
+				}
+				items = append(items, record[column])
+			}
+
+			return items, nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			shared.Set(flyt.KeyItems, execResult)
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// CreateWriteResultsToCSVNode creates a node that writes each batch item
+// alongside its processed result to a CSV file
+func CreateWriteResultsToCSVNode(path string, opts ...CSVOption) flyt.Node {
+	options := applyCSVOptions(opts)
+
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			items, ok := shared.Get(flyt.KeyItems)
+			if !ok {
+				return nil, fmt.Errorf("no items found")
+			}
+			results, ok := shared.Get(flyt.KeyResults)
+			if !ok {
+				return nil, fmt.Errorf("no results found")
+			}
+			return [2][]any{flyt.ToSlice(items), flyt.ToSlice(results)}, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data := prepResult.([2][]any)
+			items, results := data[0], data[1]
+
+			file, err := os.Create(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create %s: %w", path, err)
+			}
+			defer file.Close()
+
+			// csv.Writer quotes fields containing commas, quotes, or newlines
+			writer := csv.NewWriter(file)
+			if options.header {
+				if err := writer.Write([]string{"item", "result"}); err != nil {
+					return nil, fmt.Errorf("failed to write %s: %w", path, err)
+				}
+			}
+
+			for i, item := range items {
+				var result any
+				if i < len(results) {
+					result = results[i]
+				}
+				if err := writer.Write([]string{fmt.Sprint(item), fmt.Sprint(result)}); err != nil {
+					return nil, fmt.Errorf("failed to write %s: %w", path, err)
+				}
+			}
+
+			writer.Flush()
+			if err := writer.Error(); err != nil {
+				return nil, fmt.Errorf("failed to write %s: %w", path, err)
+			}
+
+			return path, nil
+		}),
+	)
+}
+
+// sqliteSource records which SQLite rows the batch items were loaded from,
+// so CreateSQLiteSinkNode can mark those rows processed
+type sqliteSource struct {
+	path  string
+	table string
+	ids   []int64
+}
+
+// CreateLoadItemsFromSQLiteNode creates a node that loads the pending rows
+// of table in the SQLite database at dbPath as batch items, creating the
+// table if absent
+func CreateLoadItemsFromSQLiteNode(dbPath, table string) flyt.Node {
+	return flyt.NewNode(
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			store, err := utils.OpenResultStore(ctx, dbPath, table)
+			if err != nil {
+				return nil, err
+			}
+			defer store.Close()
+
+			return store.Pending(ctx)
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			records := execResult.([]utils.ResultRecord)
+			items := make([]string, len(records))
+			ids := make([]int64, len(records))
+			for i, record := range records {
+				items[i] = record.Item
+				ids[i] = record.ID
+			}
+
+			shared.Set(flyt.KeyItems, items)
+			shared.Set("sqlite_source", sqliteSource{path: dbPath, table: table, ids: ids})
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// CreateSQLiteSinkNode creates a node that writes each batch item with its
+// result or error and a timestamp to table in the SQLite database at
+// dbPath, creating the table if absent. Items loaded from the same table
+// by CreateLoadItemsFromSQLiteNode update their rows; others are inserted.
+// All rows are written in one transaction.
+func CreateSQLiteSinkNode(dbPath, table string) flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			data, err := batchOutcome(shared)
+			if err != nil {
+				return nil, err
+			}
+			if source, ok := shared.Get("sqlite_source"); ok {
+				data["source"] = source
+			}
+			return data, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data := prepResult.(map[string]any)
+			items := data["items"].([]any)
+			results := data["results"].([]any)
+			errs, _ := data["errors"].([]error)
+
+			// Only reuse row IDs that belong to this table
+			var ids []int64
+			if source, ok := data["source"].(sqliteSource); ok &&
+				source.path == dbPath && source.table == table && len(source.ids) == len(items) {
+				ids = source.ids
+			}
+
+			processedAt := time.Now()
+			records := make([]utils.ResultRecord, len(items))
+			for i, item := range items {
+				records[i] = utils.ResultRecord{Item: fmt.Sprint(item), ProcessedAt: processedAt}
+				if ids != nil {
+					records[i].ID = ids[i]
+				}
+				if i < len(errs) && errs[i] != nil {
+					records[i].Error = errs[i].Error()
+				} else if i < len(results) {
+					records[i].Result = fmt.Sprint(results[i])
+				}
+			}
+
+			store, err := utils.OpenResultStore(ctx, dbPath, table)
+			if err != nil {
+				return nil, err
+			}
+			defer store.Close()
+
+			if err := store.Save(ctx, records); err != nil {
+				return nil, err
+			}
+			return len(records), nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			shared.Set("sqlite_rows_written", execResult)
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// CreateSinkNode creates a node that writes the shared store value under
+// key to sink, e.g. to keep "answer" or "final_results" in a file or
+// database. Sinks may buffer, so the node registers sink under
+// "result_sinks" for FlushFlowSinks to flush when the flow ends.
+func CreateSinkNode(sink utils.ResultSink, key string) flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			value, ok := shared.Get(key)
+			if !ok {
+				return nil, fmt.Errorf("no %s to write", key)
+			}
+			return value, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			return nil, sink.Write(ctx, key, prepResult)
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			sinks, _ := shared.Get("result_sinks")
+			registered, _ := sinks.([]utils.ResultSink)
+			if !slices.Contains(registered, sink) {
+				shared.Set("result_sinks", append(registered, sink))
+			}
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// FlushFlowSinks flushes the sinks that CreateSinkNode nodes wrote to
+// during a run. Call it once the flow has ended, whether or not it
+// failed, so values written before a failure are kept.
+func FlushFlowSinks(shared *flyt.SharedStore) error {
+	sinks, _ := shared.Get("result_sinks")
+	registered, _ := sinks.([]utils.ResultSink)
+	return utils.FlushSinks(registered...)
+}
+
+// processBatchItem is the per-item work done by the batch process nodes
+func processBatchItem(ctx context.Context, item any) (any, error) {
+	// Process each item
+	itemStr := item.(string)
+	return fmt.Sprintf("Processed: %s", itemStr), nil
+}
+
+// notifyingProcessFunc wraps processFunc to call onDone as each item
+// finishes, so callers can report progress before the batch completes.
+// onDone may be called from several goroutines at once.
+func notifyingProcessFunc(processFunc flyt.BatchProcessFunc, onDone func(ctx context.Context, item, result any, err error)) flyt.BatchProcessFunc {
+	return func(ctx context.Context, item any) (any, error) {
+		result, err := processFunc(ctx, item)
+		onDone(ctx, item, result, err)
+		return result, err
+	}
+}
+
+// CreateBatchProcessNode creates a node that processes items in batch
+func CreateBatchProcessNode() flyt.Node {
+	// Use Flyt's built-in batch node
+	return cancellableBatch(processBatchItem, func(process flyt.BatchProcessFunc) flyt.Node {
+		return flyt.NewBatchNode(process, true) // true for concurrent processing
+	})
+}
+
+// CreateBatchProcessNodeWithConcurrency creates a batch node that runs at
+// most maxConcurrent processFuncs at once. Results keep input order under
+// flyt.KeyResults, and a maxConcurrent of 1 processes items sequentially.
+// The batch size limit is lifted so large input files can be processed.
+//
+// With collectErrors set, a failing item no longer aborts the batch: its
+// error is stored at the same index in "batch_errors" (nil for successes)
+// and the node only fails if every item errored.
+//
+// Like CreateBatchProcessNode, the node keeps finished results when the
+// run is cancelled (see cancellableBatch).
+func CreateBatchProcessNodeWithConcurrency(processFunc flyt.BatchProcessFunc, maxConcurrent int, collectErrors bool) flyt.Node {
+	if maxConcurrent < 1 {
+		maxConcurrent = 1
+	}
+
+	config := flyt.DefaultBatchConfig()
+	config.MaxConcurrency = maxConcurrent
+	config.MaxBatchSize = 0 // No limit
+
+	return cancellableBatch(processFunc, func(process flyt.BatchProcessFunc) flyt.Node {
+		if !collectErrors {
+			return flyt.NewBatchNodeWithConfig(process, maxConcurrent > 1, config)
+		}
+
+		// Turn item errors into values so the batch node keeps going
+		tolerant := func(ctx context.Context, item any) (any, error) {
+			result, err := process(ctx, item)
+			if err != nil {
+				return batchItemError{err: err}, nil
+			}
+			return result, nil
+		}
+
+		return &errorCollectingBatchNode{
+			Node: flyt.NewBatchNodeWithConfig(tolerant, maxConcurrent > 1, config),
+		}
+	})
+}
+
+// errBatchCancelled is the "batch_errors" entry for items a cancelled
+// batch never finished
+var errBatchCancelled = errors.New("not processed: batch cancelled")
+
+// cancellableBatch builds a batch node with build, around a processFunc
+// that records each finished item. If the run's context is cancelled
+// before the batch completes, the node succeeds with the finished results
+// instead of failing: unfinished items get nil results and errBatchCancelled in
+// "batch_errors", and "cancelled" is set to true.
+func cancellableBatch(processFunc flyt.BatchProcessFunc, build func(flyt.BatchProcessFunc) flyt.Node) flyt.Node {
+	record := func(ctx context.Context, value any) (any, error) {
+		item := value.(batchProgressItem)
+		result, err := processFunc(ctx, item.item)
+		// An item cut short by the cancellation didn't finish
+		if err == nil || ctx.Err() == nil {
+			item.progress.record(item.index, result, err)
+		}
+		return result, err
+	}
+	return &cancellableBatchNode{Node: build(record)}
+}
+
+// cancellableBatchNode hands each item to the wrapped batch node along
+// with its index and the run's batchProgress
+type cancellableBatchNode struct {
+	flyt.Node
+}
+
+// batchProgressItem is an item as the wrapped batch node sees it
+type batchProgressItem struct {
+	index    int
+	item     any
+	progress *batchProgress
+}
+
+// batchProgress collects a run's finished items. Results are only copied
+// out once, by partial, so recording an item stays cheap for large batches
+type batchProgress struct {
+	mu      sync.Mutex
+	results []any
+	errs    []error
+	done    []bool
+}
+
+// record stores item i's outcome
+func (p *batchProgress) record(i int, result any, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.done[i] = true
+	if err != nil {
+		p.errs[i] = err
+	} else {
+		p.results[i] = result
+	}
+}
+
+// partial returns the finished results and per-item errors, marking
+// unfinished items with errBatchCancelled
+func (p *batchProgress) partial() partialBatch {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	errs := slices.Clone(p.errs)
+	for i, done := range p.done {
+		if !done {
+			errs[i] = errBatchCancelled
+		}
+	}
+	return partialBatch{results: slices.Clone(p.results), errs: errs}
+}
+
+// partialBatch is a cancelled batch's exec result
+type partialBatch struct {
+	results []any
+	errs    []error
+}
+
+// Exec implements flyt.Node, returning a partialBatch when the context is
+// cancelled mid-batch
+func (n *cancellableBatchNode) Exec(ctx context.Context, prepResult any) (any, error) {
+	items := flyt.ToSlice(prepResult)
+
+	progress := &batchProgress{
+		results: make([]any, len(items)),
+		errs:    make([]error, len(items)),
+		done:    make([]bool, len(items)),
+	}
+	wrapped := make([]any, len(items))
+	for i, item := range items {
+		wrapped[i] = batchProgressItem{index: i, item: item, progress: progress}
+	}
+
+	execResult, err := n.Node.Exec(ctx, wrapped)
+	if err != nil && ctx.Err() != nil {
+		partial := progress.partial()
+		slog.WarnContext(ctx, "batch cancelled, keeping finished results",
+			"finished", len(items)-countErrors(partial.errs, errBatchCancelled), "items", len(items))
+		return partial, nil
+	}
+	return execResult, err
+}
+
+// Post implements flyt.Node
+func (n *cancellableBatchNode) Post(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+	if partial, ok := execResult.(partialBatch); ok {
+		shared.Set(flyt.KeyResults, partial.results)
+		shared.Set("batch_errors", partial.errs)
+		shared.Set("cancelled", true)
+		return flyt.DefaultAction, nil
+	}
+	return n.Node.Post(ctx, shared, prepResult, execResult)
+}
+
+// countErrors counts the entries of errs that are target
+func countErrors(errs []error, target error) int {
+	count := 0
+	for _, err := range errs {
+		if errors.Is(err, target) {
+			count++
+		}
+	}
+	return count
+}
+
+// batchItemError marks a result slot whose item failed
+type batchItemError struct {
+	err error
+}
+
+// errorCollectingBatchNode splits a tolerant batch node's results into
+// flyt.KeyResults and a parallel "batch_errors" slice
+type errorCollectingBatchNode struct {
+	flyt.Node
+}
+
+// Exec implements flyt.Node, failing only when every item errored
+func (n *errorCollectingBatchNode) Exec(ctx context.Context, prepResult any) (any, error) {
+	execResult, err := n.Node.Exec(ctx, prepResult)
+	if err != nil {
+		return nil, err
+	}
+
+	results := execResult.([]any)
+	var firstErr error
+	failed := 0
+	for _, result := range results {
+		if itemErr, ok := result.(batchItemError); ok {
+			failed++
+			if firstErr == nil {
+				firstErr = itemErr.err
+			}
+		}
+	}
+
+	if len(results) > 0 && failed == len(results) {
+		return nil, fmt.Errorf("all %d batch items failed, first: %w", failed, firstErr)
+	}
+
+	return results, nil
+}
+
+// Post implements flyt.Node
+func (n *errorCollectingBatchNode) Post(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+	raw := execResult.([]any)
+	results := make([]any, len(raw))
+	errs := make([]error, len(raw))
+
+	for i, result := range raw {
+		if itemErr, ok := result.(batchItemError); ok {
+			errs[i] = itemErr.err
+			continue
+		}
+		results[i] = result
+	}
+
+	shared.Set(flyt.KeyResults, results)
+	shared.Set("batch_errors", errs)
+	return flyt.DefaultAction, nil
+}
+
+// batchOutcome gathers items, results, and per-item errors for aggregation.
+// Items and errors are optional; errors are only present in tolerant or
+// cancelled batches.
+func batchOutcome(shared *flyt.SharedStore) (map[string]any, error) {
+	results, ok := shared.Get(flyt.KeyResults)
+	if !ok {
+		return nil, fmt.Errorf("no results found")
+	}
+	items, _ := shared.Get(flyt.KeyItems)
+	errs, _ := shared.Get("batch_errors")
+
+	return map[string]any{
+		"items":   flyt.ToSlice(items),
+		"results": flyt.ToSlice(results),
+		"errors":  errs,
+	}, nil
+}
+
+// cancelledNote describes how much of a cancelled batch was left
+// unprocessed, or returns "" when every item was processed
+func cancelledNote(errs []error, total int) string {
+	skipped := countErrors(errs, errBatchCancelled)
+	if skipped == 0 {
+		return ""
+	}
+	return fmt.Sprintf("Cancelled: %d of %d items were not processed\n", skipped, total)
+}
+
+// CreateAggregateResultsNode creates a node that aggregates batch results.
+// Items a cancelled batch never processed are counted, not listed.
+func CreateAggregateResultsNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			return batchOutcome(shared)
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data := prepResult.(map[string]any)
+			items := data["items"].([]any)
+			results := data["results"].([]any)
+			errs, _ := data["errors"].([]error)
+
+			// Aggregate results
+			var aggregated strings.Builder
+			aggregated.WriteString("Aggregated Results:\n")
+
+			var failures []string
+			for i, result := range results {
+				// Unprocessed items of a cancelled batch are only counted
+				if i < len(errs) && errors.Is(errs[i], errBatchCancelled) {
+					continue
+				}
+				if i < len(errs) && errs[i] != nil {
+					var item any = i + 1
+					if i < len(items) {
+						item = items[i]
+					}
+					failures = append(failures, fmt.Sprintf("%d. %v: %v\n", i+1, item, errs[i]))
+					continue
+				}
+				aggregated.WriteString(fmt.Sprintf("%d. %v\n", i+1, result))
+			}
+
+			// Report which items failed and why
+			if len(failures) > 0 {
+				aggregated.WriteString(fmt.Sprintf("\nFailed Items (%d):\n", len(failures)))
+				for _, failure := range failures {
+					aggregated.WriteString(failure)
+				}
+			}
+
+			if note := cancelledNote(errs, len(results)); note != "" {
+				aggregated.WriteString("\n" + note)
+			}
+
+			return aggregated.String(), nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			shared.Set("final_results", execResult)
+			printStatus(fmt.Sprintln(execResult))
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// CreateAggregateTableNode creates a node that aggregates batch results
+// into a plain-text table with item and result columns, wrapping long
+// cells at utils.TableMaxCellWidth. Failed items show their error in the
+// result column; items a cancelled batch never processed are left out and
+// counted below the table.
+func CreateAggregateTableNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			return batchOutcome(shared)
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data := prepResult.(map[string]any)
+			items := data["items"].([]any)
+			results := data["results"].([]any)
+			errs, _ := data["errors"].([]error)
+
+			rows := make([][]string, 0, len(results))
+			for i, result := range results {
+				if i < len(errs) && errors.Is(errs[i], errBatchCancelled) {
+					continue
+				}
+				var item any = i + 1
+				if i < len(items) {
+					item = items[i]
+				}
+				cell := fmt.Sprint(result)
+				if i < len(errs) && errs[i] != nil {
+					cell = "error: " + errs[i].Error()
+				}
+				rows = append(rows, []string{fmt.Sprint(item), cell})
+			}
+
+			table := utils.FormatTable([]string{"item", "result"}, rows)
+			if note := cancelledNote(errs, len(results)); note != "" {
+				table += note
+			}
+			return table, nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			shared.Set("final_results", execResult)
+			printStatus(fmt.Sprintln(execResult))
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// BatchItemResult pairs a batch input item with its result or error
+type BatchItemResult struct {
+	Item   any    `json:"item"`
+	Result any    `json:"result,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// CreateAggregateResultsJSONNode creates a node that aggregates batch results
+// into a []BatchItemResult for machine-readable output. Items a cancelled
+// batch never processed carry a "not processed: batch cancelled" error.
+func CreateAggregateResultsJSONNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			return batchOutcome(shared)
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data := prepResult.(map[string]any)
+			items := data["items"].([]any)
+			results := data["results"].([]any)
+			errs, _ := data["errors"].([]error)
+
+			aggregated := make([]BatchItemResult, len(items))
+			for i, item := range items {
+				aggregated[i].Item = item
+				if i < len(errs) && errs[i] != nil {
+					aggregated[i].Error = errs[i].Error()
+				} else if i < len(results) {
+					aggregated[i].Result = results[i]
+				}
+			}
+
+			return aggregated, nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			shared.Set("final_results", execResult)
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// ActionTooLarge is the conventional action for routing over-budget prompts
+const ActionTooLarge flyt.Action = "too_large"
+
+// CreateTokenGuardNode creates a node that checks the prompt stored under
+// "prompt" against a token budget and records the estimate under
+// "prompt_tokens". When over budget it routes to overBudgetAction, or if
+// overBudgetAction is empty it truncates the prompt to fit and continues.
+func CreateTokenGuardNode(maxTokens int, overBudgetAction flyt.Action) flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			prompt, ok := shared.Get("prompt")
+			if !ok {
+				return nil, fmt.Errorf("no prompt found in shared store")
+			}
+			return prompt, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			prompt := prepResult.(string)
+			tokens := utils.CountTokens(prompt)
+
+			result := map[string]any{
+				"prompt":      prompt,
+				"tokens":      tokens,
+				"over_budget": tokens > maxTokens,
+			}
+
+			if tokens > maxTokens && overBudgetAction == "" {
+				truncated := utils.FitToTokenBudget(prompt, maxTokens)
+				result["prompt"] = truncated
+				result["tokens"] = utils.CountTokens(truncated)
+			}
+
+			return result, nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			result := execResult.(map[string]any)
+			shared.Set("prompt_tokens", result["tokens"])
+
+			if result["over_budget"].(bool) && overBudgetAction != "" {
+				return overBudgetAction, nil
+			}
+
+			shared.Set("prompt", result["prompt"])
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// AnswerValidation is the structured verdict from the answer validator
+type AnswerValidation struct {
+	Adequate bool   `json:"adequate"`
+	Reason   string `json:"reason"`
+}
+
+// CreateValidateAnswerNode creates a node that asks the LLM whether the
+// stored answer actually addresses the question. Inadequate answers route
+// to "retry" up to maxRetries times, tracked in "validation_retries", and
+// their turn is dropped from "history"; after that, or on a passing
+// answer, it continues with DefaultAction.
+func CreateValidateAnswerNode(maxRetries int) flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			question, ok := shared.Get("question")
+			if !ok {
+				return nil, fmt.Errorf("no question found in shared store")
+			}
+			answer, ok := shared.Get("answer")
+			if !ok {
+				return nil, fmt.Errorf("no answer found in shared store")
+			}
+
+			return map[string]any{
+				"question": question,
+				"answer":   answer,
+				"config":   llmConfigFrom(shared),
+			}, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data := prepResult.(map[string]any)
+
+			// Without an LLM there is nothing to judge with, so accept the answer
+			if !utils.HasLLMCredentials() {
+				return AnswerValidation{Adequate: true, Reason: "validation skipped: no LLM configured"}, nil
+			}
+
+			prompt := fmt.Sprintf(`Does the answer below directly and correctly address the question?
+
+Question: %s
+
+Answer: %s
+
+Reply as JSON: {"adequate": true|false, "reason": "short explanation"}`, data["question"], data["answer"])
+
+			var validation AnswerValidation
+			config := data["config"].(*utils.LLMConfig)
+			if err := utils.CallLLMJSON(ctx, prompt, &validation, config); err != nil {
+				return nil, err
+			}
+
+			return validation, nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			validation := execResult.(AnswerValidation)
+			shared.Set("validation_reason", validation.Reason)
+
+			retries, _ := shared.Get("validation_retries")
+			count, _ := retries.(int)
+
+			if !validation.Adequate && count < maxRetries {
+				shared.Set("validation_retries", count+1)
+				forgetAnswer(shared, prepResult.(map[string]any)["answer"])
+				return "retry", nil
+			}
+
+			// Done with this question, so reset for the next one
+			shared.Set("validation_retries", 0)
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// Defaults for CreateConfidenceNode
+const (
+	defaultConfidenceThreshold = 60
+	maxConfidenceRetries       = 2
+)
+
+// ConfidenceOption configures CreateConfidenceNode
+type ConfidenceOption func(*confidenceOptions)
+
+type confidenceOptions struct {
+	threshold int
+	action    flyt.Action
+}
+
+// WithConfidenceThreshold sets the score, 0-100, below which an answer
+// counts as low confidence
+func WithConfidenceThreshold(threshold int) ConfidenceOption {
+	return func(o *confidenceOptions) {
+		o.threshold = threshold
+	}
+}
+
+// WithLowConfidenceAction sets the action taken for a low-confidence
+// answer, e.g. "search" to gather more information instead of "retry"
+func WithLowConfidenceAction(action flyt.Action) ConfidenceOption {
+	return func(o *confidenceOptions) {
+		o.action = action
+	}
+}
+
+var (
+	confidenceScoreRe  = regexp.MustCompile(`(?i)(?:score|confidence)\D{0,20}?(\d{1,3}(?:\.\d+)?(?:\s*/\s*10\b)?)`)
+	confidenceNumberRe = regexp.MustCompile(`\d{1,3}(?:\.\d+)?(?:\s*/\s*10\b)?`)
+	confidenceReasonRe = regexp.MustCompile(`(?im)^\W*(?:reason|justification)\W*\s*(.+)$`)
+)
+
+// parseConfidence extracts a 0-100 score and a one-line justification from
+// a model reply, tolerating prose around them ("I'd say about 85%. ...")
+// and ratings out of 10. A labeled score wins over the first bare number
+// in range.
+func parseConfidence(reply string) (int, string, error) {
+	text := strings.TrimSpace(reply)
+
+	var candidates []string
+	if m := confidenceScoreRe.FindStringSubmatch(text); m != nil {
+		candidates = append(candidates, m[1])
+	}
+	candidates = append(candidates, confidenceNumberRe.FindAllString(text, -1)...)
+
+	score := -1
+	for _, candidate := range candidates {
+		// Rescale ratings given out of 10
+		scale := 1.0
+		if number, _, found := strings.Cut(candidate, "/"); found {
+			candidate, scale = strings.TrimSpace(number), 10
+		}
+		value, err := strconv.ParseFloat(candidate, 64)
+		value *= scale
+		if err == nil && value >= 0 && value <= 100 {
+			score = int(value + 0.5)
+			break
+		}
+	}
+	if score < 0 {
+		return 0, "", fmt.Errorf("no confidence score in reply %q", reply)
+	}
+
+	reason := ""
+	if m := confidenceReasonRe.FindStringSubmatch(text); m != nil {
+		reason = strings.TrimSpace(m[1])
+	} else {
+		// Otherwise use the first line with words besides the score label
+		for _, line := range strings.Split(text, "\n") {
+			rest := confidenceScoreRe.ReplaceAllString(line, "")
+			if strings.ContainsFunc(rest, unicode.IsLetter) {
+				reason = strings.TrimSpace(line)
+				break
+			}
+		}
+	}
+
+	return score, reason, nil
+}
+
+// CreateConfidenceNode creates a node that asks the LLM to rate how
+// confident it is, 0-100, that "answer" correctly answers "question". The
+// score is stored under "answer_confidence" and the justification under
+// "confidence_reason". A score below the threshold (60 by default) takes
+// the low-confidence action ("retry" by default) up to twice per question,
+// tracked in "confidence_retries", and drops the answer's turn from
+// "history"; otherwise it continues with DefaultAction. Without LLM credentials the answer is passed through.
+func CreateConfidenceNode(opts ...ConfidenceOption) flyt.Node {
+	o := confidenceOptions{threshold: defaultConfidenceThreshold, action: "retry"}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			question, ok := shared.Get("question")
+			if !ok {
+				return nil, fmt.Errorf("no question found in shared store")
+			}
+			answer, ok := shared.Get("answer")
+			if !ok {
+				return nil, fmt.Errorf("no answer found in shared store")
+			}
+
+			return map[string]any{
+				"question": question,
+				"answer":   answer,
+				"config":   llmConfigFrom(shared),
+			}, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data := prepResult.(map[string]any)
+
+			if !utils.HasLLMCredentials() {
+				return nil, nil
+			}
+
+			prompt := fmt.Sprintf(`Rate how confident you are that the answer below is correct and complete for the question.
+
+Question: %s
+
+Answer: %s
+
+Reply in exactly this format:
+Score: <integer from 0 to 100>
+Reason: <one-line justification>`, data["question"], data["answer"])
+
+			messages := []utils.Message{
+				{Role: "system", Content: "You are a careful reviewer who rates answers honestly."},
+				{Role: "user", Content: prompt},
+			}
+			// Answer examples would only confuse the reviewer
+			config := data["config"].(*utils.LLMConfig).WithoutFewShot()
+			reply, err := utils.CallLLMConversation(ctx, messages, config)
+			if err != nil {
+				return nil, err
+			}
+
+			score, reason, err := parseConfidence(reply)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]any{"score": score, "reason": reason}, nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			rating, ok := execResult.(map[string]any)
+			if !ok {
+				slog.DebugContext(ctx, "confidence check skipped: no LLM configured")
+				return flyt.DefaultAction, nil
+			}
+
+			score := rating["score"].(int)
+			shared.Set("answer_confidence", score)
+			shared.Set("confidence_reason", rating["reason"])
+			slog.DebugContext(ctx, "answer confidence", "score", score, "reason", rating["reason"])
+
+			retries, _ := shared.Get("confidence_retries")
+			count, _ := retries.(int)
+
+			if score < o.threshold && count < maxConfidenceRetries {
+				shared.Set("confidence_retries", count+1)
+				forgetAnswer(shared, prepResult.(map[string]any)["answer"])
+				return o.action, nil
+			}
+
+			// Done with this question, so reset for the next one
+			shared.Set("confidence_retries", 0)
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// ActionBatch routes a decomposed question to its sub-question batch
+const ActionBatch flyt.Action = "batch"
+
+// maxSubQuestions caps how many sub-questions the decompose node keeps
+const maxSubQuestions = 5
+
+// CreateDecomposeNode creates a node that asks the LLM to split "question"
+// into an ordered list of simpler sub-questions. Two or more are stored
+// under flyt.KeyItems (and "sub_questions") and route to ActionBatch; when
+// the model returns a single sub-question, or no LLM is configured, the
+// question is left as is and the node continues with DefaultAction.
+func CreateDecomposeNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			question, ok := shared.Get("question")
+			if !ok {
+				return nil, fmt.Errorf("no question found in shared store")
+			}
+
+			return map[string]any{
+				"question": question,
+				"config":   llmConfigFrom(shared),
+			}, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data := prepResult.(map[string]any)
+			question := data["question"].(string)
+
+			if !utils.HasLLMCredentials() {
+				return []string{question}, nil
+			}
+
+			prompt := fmt.Sprintf(`Break the question below into the smallest ordered list of self-contained sub-questions whose answers together answer it (at most %d). If it is already simple, return just the question itself.
+
+Question: %s
+
+Respond with a JSON object: {"sub_questions": ["...", "..."]}`, maxSubQuestions, question)
+
+			var decomposition struct {
+				SubQuestions []string `json:"sub_questions"`
+			}
+			if err := utils.CallLLMJSON(ctx, prompt, &decomposition, data["config"].(*utils.LLMConfig)); err != nil {
+				return nil, err
+			}
+
+			var subQuestions []string
+			for _, sub := range decomposition.SubQuestions {
+				if sub = strings.TrimSpace(sub); sub != "" {
+					subQuestions = append(subQuestions, sub)
+				}
+			}
+			if len(subQuestions) > maxSubQuestions {
+				subQuestions = subQuestions[:maxSubQuestions]
+			}
+			return subQuestions, nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			subQuestions := execResult.([]string)
+			if len(subQuestions) < 2 {
+				slog.DebugContext(ctx, "question not decomposed")
+				return flyt.DefaultAction, nil
+			}
+
+			slog.DebugContext(ctx, "question decomposed", "sub_questions", len(subQuestions))
+			shared.Set("sub_questions", subQuestions)
+			shared.Set(flyt.KeyItems, subQuestions)
+			return ActionBatch, nil
+		}),
+	)
+}
+
+// subQuestion is one batch item answered by CreateAnswerSubQuestionsNode
+type subQuestion struct {
+	question string
+	config   *utils.LLMConfig
+}
+
+// subQuestionsNode pairs each batch item with the shared LLM settings,
+// which a batch process function can't read from the store itself
+type subQuestionsNode struct {
+	flyt.Node
+}
+
+// CreateAnswerSubQuestionsNode creates a batch node that answers each
+// sub-question under flyt.KeyItems independently and concurrently,
+// storing the answers in the same order under flyt.KeyResults
+func CreateAnswerSubQuestionsNode() flyt.Node {
+	return &subQuestionsNode{Node: flyt.NewBatchNode(answerSubQuestion, true)}
+}
+
+// Prep implements flyt.Node
+func (n *subQuestionsNode) Prep(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+	items, err := n.Node.Prep(ctx, shared)
+	if err != nil {
+		return nil, err
+	}
+
+	config := llmConfigFrom(shared)
+	var batch []subQuestion
+	for _, item := range flyt.ToSlice(items) {
+		batch = append(batch, subQuestion{question: fmt.Sprint(item), config: config})
+	}
+	return batch, nil
+}
+
+// answerSubQuestion is the per-item work done by CreateAnswerSubQuestionsNode
+func answerSubQuestion(ctx context.Context, item any) (any, error) {
+	sub := item.(subQuestion)
+	messages := []utils.Message{
+		{Role: "system", Content: utils.DefaultSystemPrompt + " Answer concisely."},
+		{Role: "user", Content: answerPrompt(sub.question, nil)},
+	}
+	return utils.CallLLMConversation(ctx, messages, sub.config)
+}
+
+// CreateSynthesizeNode creates a node that combines the sub-question
+// answers under flyt.KeyResults into a single answer to "question"
+func CreateSynthesizeNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			question, ok := shared.Get("question")
+			if !ok {
+				return nil, fmt.Errorf("no question found in shared store")
+			}
+			items, _ := shared.Get(flyt.KeyItems)
+			results, ok := shared.Get(flyt.KeyResults)
+			if !ok {
+				return nil, fmt.Errorf("no sub-question answers found in shared store")
+			}
+
+			return map[string]any{
+				"question": question,
+				"items":    flyt.ToSlice(items),
+				"results":  flyt.ToSlice(results),
+				"config":   llmConfigFrom(shared),
+			}, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data := prepResult.(map[string]any)
+			items := data["items"].([]any)
+			results := data["results"].([]any)
+
+			var findings strings.Builder
+			for i, result := range results {
+				var item any = fmt.Sprintf("Sub-question %d", i+1)
+				if i < len(items) {
+					item = items[i]
+				}
+				findings.WriteString(fmt.Sprintf("%d. %v\n%v\n\n", i+1, item, result))
+			}
+
+			prompt := fmt.Sprintf(`Answer the question below using the answers to its sub-questions. Write one coherent response rather than listing the sub-answers.
+
+Question: %s
+
+Sub-questions and answers:
+%s`, data["question"], strings.TrimSpace(findings.String()))
+
+			return utils.CallLLMConversation(ctx, utils.PromptMessages(prompt), data["config"].(*utils.LLMConfig))
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			shared.Set("answer", execResult)
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// CreateTranslateNode creates a node that translates "answer" into
+// targetLang, an ISO 639-1 code such as "es" or a language name. The
+// untranslated text is kept under "answer_original". The LLM call is
+// skipped when DetectLanguage says the answer is already in targetLang.
+func CreateTranslateNode(targetLang string) flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			answer, ok := shared.Get("answer")
+			if !ok {
+				return nil, fmt.Errorf("no answer found in shared store")
+			}
+			return map[string]any{
+				"answer": answer,
+				"config": llmConfigFrom(shared),
+			}, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data := prepResult.(map[string]any)
+			answer := data["answer"].(string)
+
+			source, _ := utils.DetectLanguage(answer)
+			if strings.EqualFold(source, targetLang) {
+				slog.DebugContext(ctx, "answer already in target language", "lang", source)
+				return answer, nil
+			}
+
+			prompt := fmt.Sprintf(`Translate the following text into %s. Keep markdown formatting, code, names, and URLs unchanged. Reply with only the translation.
+
+%s`, utils.LanguageName(targetLang), answer)
+
+			messages := []utils.Message{
+				{Role: "system", Content: "You are a professional translator."},
+				{Role: "user", Content: prompt},
+			}
+			config := data["config"].(*utils.LLMConfig).WithoutFewShot()
+			return utils.CallLLMConversation(ctx, messages, config)
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			data := prepResult.(map[string]any)
+			shared.Set("answer_original", data["answer"])
+			shared.Set("answer", execResult)
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// CreateConstrainLengthNode creates a node that keeps "answer" within
+// maxWords words, counted with utils.CountWords. A longer answer is sent
+// back to the LLM once to be shortened; if that fails or is still too
+// long, the answer is cut at a sentence boundary with utils.TruncateToWords.
+// The answer as generated is kept under "answer_unconstrained". A maxWords
+// of zero or less leaves answers as they are.
+func CreateConstrainLengthNode(maxWords int) flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			answer, ok := shared.Get("answer")
+			if !ok {
+				return nil, fmt.Errorf("no answer found in shared store")
+			}
+			return map[string]any{
+				"answer": fmt.Sprint(answer),
+				"config": llmConfigFrom(shared),
+			}, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data := prepResult.(map[string]any)
+			answer := data["answer"].(string)
+
+			words := utils.CountWords(answer)
+			if maxWords <= 0 || words <= maxWords {
+				return answer, nil
+			}
+
+			if utils.HasLLMCredentials() {
+				prompt := fmt.Sprintf(`Shorten the following answer to at most %d words, keeping the key facts and the same language. Reply with only the shortened answer.
+
+%s`, maxWords, answer)
+
+				config := data["config"].(*utils.LLMConfig).WithoutFewShot()
+				shortened, err := utils.CallLLMConversation(ctx, utils.PromptMessages(prompt), config)
+				switch {
+				case err != nil:
+					slog.Warn("Failed to shorten answer, truncating it", "error", err)
+				case utils.CountWords(shortened) == 0:
+					slog.Warn("LLM returned an empty shortened answer, truncating it")
+				case utils.CountWords(shortened) > maxWords:
+					slog.Debug("Shortened answer still too long, truncating it",
+						"words", utils.CountWords(shortened), "max_words", maxWords)
+				default:
+					return strings.TrimSpace(shortened), nil
+				}
+			}
+
+			return utils.TruncateToWords(answer, maxWords), nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			data := prepResult.(map[string]any)
+			shared.Set("answer_unconstrained", data["answer"])
+			shared.Set("answer", execResult)
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// DefaultAnswerMarker is the marker CreateExtractAnswerNode looks for when
+// given an empty one
+const DefaultAnswerMarker = "Final Answer:"
+
+// CreateExtractAnswerNode creates a node that replaces a verbose "answer",
+// such as reasoning followed by "Final Answer: ...", with the text after
+// marker, using utils.ExtractAfterMarker. An answer without the marker, or
+// with nothing after it, is kept whole. The full output is kept under
+// "answer_verbose".
+func CreateExtractAnswerNode(marker string) flyt.Node {
+	marker = cmp.Or(strings.TrimSpace(marker), DefaultAnswerMarker)
+
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			answer, ok := shared.Get("answer")
+			if !ok {
+				return nil, fmt.Errorf("no answer found in shared store")
+			}
+			return fmt.Sprint(answer), nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			answer := prepResult.(string)
+			extracted := utils.ExtractAfterMarker(answer, marker)
+			if extracted == "" {
+				slog.DebugContext(ctx, "nothing after answer marker, keeping whole answer", "marker", marker)
+				return strings.TrimSpace(answer), nil
+			}
+			return extracted, nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			shared.Set("answer_verbose", prepResult)
+			shared.Set("answer", execResult)
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// CreateMarkdownReportNode creates a node that assembles "question", the
+// sources or context the answer drew on, and "answer" into a markdown
+// document stored under "report". Sources come from "sources" (cited
+// answers) or "search_results"; without either, "context" is included
+// instead. The question, sources, and context are escaped so they can't
+// break the document's structure; the answer is kept as markdown with its
+// headings nested under the Answer section.
+func CreateMarkdownReportNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			answer, ok := shared.Get("answer")
+			if !ok {
+				return nil, fmt.Errorf("no answer found in shared store")
+			}
+			question, _ := shared.Get("question")
+			context, _ := shared.Get("context")
+
+			sources, ok := shared.Get("sources")
+			if !ok {
+				sources, _ = shared.Get("search_results")
+			}
+
+			return map[string]any{
+				"question": question,
+				"answer":   answer,
+				"context":  context,
+				"sources":  sources,
+			}, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data := prepResult.(map[string]any)
+			question, _ := data["question"].(string)
+			contextText, _ := data["context"].(string)
+			sources, _ := data["sources"].([]utils.SearchResult)
+
+			var b strings.Builder
+			b.WriteString("# Report\n")
+
+			if question = strings.TrimSpace(question); question != "" {
+				b.WriteString("\n## Question\n\n")
+				b.WriteString(utils.EscapeMarkdown(question) + "\n")
+			}
+
+			if len(sources) > 0 {
+				b.WriteString("\n## Sources\n\n")
+				for i, source := range sources {
+					fmt.Fprintf(&b, "%d. %s\n", i+1, utils.MarkdownLink(source.Title, source.URL))
+					snippet := strings.Join(strings.Fields(cmp.Or(source.Snippet, source.Description)), " ")
+					if snippet != "" {
+						b.WriteString("   " + utils.EscapeMarkdown(snippet) + "\n")
+					}
+				}
+			} else if contextText = strings.TrimSpace(contextText); contextText != "" {
+				b.WriteString("\n## Context\n\n")
+				b.WriteString(utils.EscapeMarkdown(contextText) + "\n")
+			}
+
+			b.WriteString("\n## Answer\n\n")
+			b.WriteString(utils.NestMarkdownHeadings(strings.TrimSpace(fmt.Sprint(data["answer"])), 2) + "\n")
+
+			return b.String(), nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			shared.Set("report", execResult)
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// entitiesSchema is the JSON Schema a utils.Entities reply must match
+const entitiesSchema = `{
+  "type": "object",
+  "required": ["names", "dates", "emails", "urls"],
+  "properties": {
+    "names": {"type": "array", "items": {"type": "string"}},
+    "dates": {"type": "array", "items": {"type": "string"}},
+    "emails": {"type": "array", "items": {"type": "string"}},
+    "urls": {"type": "array", "items": {"type": "string"}}
+  }
+}`
+
+// CreateExtractEntitiesNode creates a node that pulls names, dates, email
+// addresses, and URLs out of "text" (or "question" when there is no text)
+// and stores them under "entities" as utils.Entities. The LLM extracts
+// everything with a JSON prompt; the regex helpers always run too, and are
+// all that's used when no LLM credentials are set or the call fails.
+func CreateExtractEntitiesNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			text, ok := shared.Get("text")
+			if !ok {
+				text, ok = shared.Get("question")
+			}
+			if !ok {
+				return nil, fmt.Errorf("no text or question found in shared store")
+			}
+
+			return map[string]any{
+				"text":   text,
+				"config": llmConfigFrom(shared),
+			}, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data := prepResult.(map[string]any)
+			text := data["text"].(string)
+
+			entities := utils.ExtractEntitiesRegex(text)
+			if !utils.HasLLMCredentials() {
+				return entities, nil
+			}
+
+			prompt := fmt.Sprintf(`Extract entities from the text below. Respond with a JSON object of this shape:
+{"names": ["people's full names"], "dates": ["dates as written"], "emails": ["email addresses"], "urls": ["URLs"]}
+Use an empty list when there are none. Only include entities that appear in the text.
+
+Text:
+%s`, text)
+
+			var extracted utils.Entities
+			config := data["config"].(*utils.LLMConfig)
+			if err := utils.CallLLMJSONSchema(ctx, prompt, entitiesSchema, &extracted, config); err != nil {
+				slog.WarnContext(ctx, "LLM entity extraction failed, using regex only", "error", err)
+				return entities, nil
+			}
+
+			return utils.MergeEntities(entities, extracted), nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			shared.Set("entities", execResult)
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// CreateTextStatsNode creates a node that computes utils.TextStats for
+// "text", or for "answer" when there is no text, and stores the
+// utils.TextStatistics under "text_stats"
+func CreateTextStatsNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			text, ok := shared.Get("text")
+			if !ok {
+				text, ok = shared.Get("answer")
+			}
+			if !ok {
+				return nil, fmt.Errorf("no text or answer found in shared store")
+			}
+			return text, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			text, _ := prepResult.(string)
+			return utils.TextStats(text), nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			shared.Set("text_stats", execResult)
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// CreateCompareAnswersNode creates a node that diffs the answers stored
+// under keyA and keyB with utils.DiffText, e.g. to compare two models or
+// prompts, and stores the result under "answer_diff". The diff is headed
+// by "--- keyA" and "+++ keyB" lines unless there are no differences.
+func CreateCompareAnswersNode(keyA, keyB string) flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			answers := make([]string, 2)
+			for i, key := range []string{keyA, keyB} {
+				value, ok := shared.Get(key)
+				if !ok {
+					return nil, fmt.Errorf("no %s found in shared store", key)
+				}
+				answers[i] = fmt.Sprint(value)
+			}
+			return answers, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			answers := prepResult.([]string)
+			diff := utils.DiffText(answers[0], answers[1])
+			if diff == utils.NoDifferences {
+				return diff, nil
+			}
+			return fmt.Sprintf("--- %s\n+++ %s\n%s", keyA, keyB, diff), nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			shared.Set("answer_diff", execResult)
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// CreateCostReportNode creates a node that estimates the dollar cost of
+// the run's LLM calls, recorded by the utils.UsageTracker in its context,
+// with utils.EstimateCost. It prints a summary with a line per model,
+// flagging models with no price, and stores the per-model usage under
+// "llm_usage", the total under "estimated_cost", and the summary under
+// "cost_report".
+func CreateCostReportNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			tracker := utils.UsageTrackerFromContext(ctx)
+			if tracker == nil {
+				return nil, fmt.Errorf("no usage tracker in context, attach one with utils.WithUsageTracker")
+			}
+			return tracker, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			tracker := prepResult.(*utils.UsageTracker)
+			byModel := tracker.ByModel()
+
+			var total float64
+			var prompt, completion int
+			var lines []string
+			for _, model := range slices.Sorted(maps.Keys(byModel)) {
+				usage := byModel[model]
+				prompt += usage.PromptTokens
+				completion += usage.CompletionTokens
+
+				line := fmt.Sprintf("   %s: %d prompt + %d completion tokens, ", model, usage.PromptTokens, usage.CompletionTokens)
+				if _, ok := utils.LookupModelPrice(model); ok {
+					cost := utils.EstimateCost(model, usage)
+					total += cost
+					line += fmt.Sprintf("$%.4f", cost)
+				} else {
+					line += "no price, counted as $0"
+				}
+				lines = append(lines, line)
+			}
+
+			report := fmt.Sprintf("💰 Estimated LLM cost: $%.4f (%d calls, %d prompt + %d completion tokens)",
+				total, tracker.Calls(), prompt, completion)
+			if len(lines) > 0 {
+				report += "\n" + strings.Join(lines, "\n")
+			}
+
+			return map[string]any{
+				"usage":  byModel,
+				"cost":   total,
+				"report": report,
+			}, nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			result := execResult.(map[string]any)
+			shared.Set("llm_usage", result["usage"])
+			shared.Set("estimated_cost", result["cost"])
+			shared.Set("cost_report", result["report"])
+			printStatus("\n" + result["report"].(string) + "\n")
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// CreateSSMLNode creates a node that converts "answer" to SSML with
+// utils.TextToSSML for a voice assistant and stores it under "ssml"
+func CreateSSMLNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			answer, ok := shared.Get("answer")
+			if !ok {
+				return nil, fmt.Errorf("no answer found in shared store")
+			}
+			return fmt.Sprint(answer), nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			return utils.TextToSSML(prepResult.(string)), nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			shared.Set("ssml", execResult)
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// IntentDefault is the fallback intent CreateIntentRouterNode picks when no
+// other intent fits. It is flyt.DefaultAction, so the default route is the
+// router's ordinary next node.
+const IntentDefault = string(flyt.DefaultAction)
+
+// IntentRouterOption configures CreateIntentRouterNode
+type IntentRouterOption func(*intentRouterOptions)
+
+type intentRouterOptions struct {
+	keywords map[string][]string
+}
+
+// WithIntentKeywords classifies by keyword rules instead of the LLM: the
+// intent whose words or phrases appear most often in the question wins,
+// ties going to the alphabetically first intent. Rules for intents that
+// have no route are ignored.
+func WithIntentKeywords(rules map[string][]string) IntentRouterOption {
+	return func(o *intentRouterOptions) {
+		o.keywords = rules
+	}
+}
+
+// CreateIntentRouterNode creates a node that classifies "question" into
+// one of the intents named by routes, stores it under "intent", and takes
+// it as the action. Connect each route under its intent, as
+// CreateIntentRoutedFlow does. Without WithIntentKeywords the LLM picks
+// from the route names; a reply outside that set, an LLM error, or missing
+// credentials fall back to IntentDefault, as does a question no keyword
+// rule matches.
+func CreateIntentRouterNode(routes map[string]flyt.Node, opts ...IntentRouterOption) flyt.Node {
+	var o intentRouterOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	intents := make([]string, 0, len(routes))
+	for intent := range routes {
+		if intent != IntentDefault {
+			intents = append(intents, intent)
+		}
+	}
+	slices.Sort(intents)
+
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			question, ok := shared.Get("question")
+			if !ok {
+				return nil, fmt.Errorf("no question found in shared store")
+			}
+			return map[string]any{
+				"question": fmt.Sprint(question),
+				"config":   llmConfigFrom(shared),
+			}, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			data := prepResult.(map[string]any)
+			question := data["question"].(string)
+
+			if o.keywords != nil {
+				return keywordIntent(question, intents, o.keywords), nil
+			}
+			if len(intents) == 0 || !utils.HasLLMCredentials() {
+				return IntentDefault, nil
+			}
+
+			prompt := fmt.Sprintf(`Classify the user's question into exactly one of these intents: %s.
+If none of them fits, answer %s.
+
+Question: %s
+
+Reply with the intent name only.`, strings.Join(intents, ", "), IntentDefault, question)
+
+			config := *data["config"].(*utils.LLMConfig).WithoutFewShot()
+			config.Temperature = 0
+			reply, err := utils.CallLLMConversation(ctx, utils.PromptMessages(prompt), &config)
+			if err != nil {
+				slog.Warn("Intent classification failed, using the default route", "error", err)
+				return IntentDefault, nil
+			}
+
+			intent := strings.ToLower(strings.Trim(reply, " \t\r\n.\"'`"))
+			if !slices.Contains(intents, intent) {
+				if intent != IntentDefault {
+					slog.Debug("Ignoring unknown intent", "intent", intent)
+				}
+				return IntentDefault, nil
+			}
+			return intent, nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			intent := execResult.(string)
+			shared.Set("intent", intent)
+			slog.Debug("Routing question", "intent", intent)
+			return flyt.Action(intent), nil
+		}),
+	)
+}
+
+// keywordIntent scores each of intents by how many of its rules occur in
+// question as whole words, returning the best or IntentDefault when none
+// match. intents is sorted, so ties break the same way every time.
+func keywordIntent(question string, intents []string, rules map[string][]string) string {
+	text := " " + strings.Join(utils.TokenizeText(question), " ") + " "
+
+	best, bestScore := IntentDefault, 0
+	for _, intent := range intents {
+		score := 0
+		for _, keyword := range rules[intent] {
+			phrase := strings.Join(utils.TokenizeText(keyword), " ")
+			if phrase != "" && strings.Contains(text, " "+phrase+" ") {
+				score++
+			}
+		}
+		if score > bestScore {
+			best, bestScore = intent, score
+		}
+	}
+	return best
+}
+
+// ActionError is the action WithErrorAction routes to when exec fails
+const ActionError flyt.Action = "error"
+
+// fallbackAnswer is the canned reply used when no answer could be generated
+const fallbackAnswer = "Sorry, I couldn't answer that right now. Please try again later."
+
+// CreateFallbackAnswerNode creates a node that stores a canned answer, so a
+// flow can still return something after an upstream failure. The failure,
+// if recorded under "error", is logged.
+func CreateFallbackAnswerNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			failure, _ := shared.Get("error")
+			return failure, nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			if prepResult != nil {
+				slog.WarnContext(ctx, "using fallback answer", "error", prepResult)
+			}
+			shared.Set("answer", fallbackAnswer)
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// ActionBlocked is the action the moderation node takes for flagged input
+const ActionBlocked flyt.Action = "blocked"
+
+// refusalAnswer is the reply to questions blocked by moderation
+const refusalAnswer = "Sorry, I can't help with that request. If you think this is a mistake, please rephrase your question."
+
+// CreateModerationNode creates a node that checks "question" with
+// utils.Moderate before it is answered. Flagged questions store their
+// categories under "moderation_categories" and take ActionBlocked. If the
+// moderation API fails, the offline blocklist decides instead.
+func CreateModerationNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPrepFunc(func(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+			question, ok := shared.Get("question")
+			if !ok {
+				return nil, fmt.Errorf("no question found in shared store")
+			}
+			return question, nil
+		}),
+		flyt.WithExecFunc(func(ctx context.Context, prepResult any) (any, error) {
+			question := prepResult.(string)
+
+			result, err := utils.Moderate(ctx, question)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil, err
+				}
+				slog.WarnContext(ctx, "moderation API failed, using blocklist", "error", err)
+				result = utils.ModerateBlocklist(question)
+			}
+			return result, nil
+		}),
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			result := execResult.(utils.ModerationResult)
+			if !result.Flagged {
+				return flyt.DefaultAction, nil
+			}
+
+			slog.WarnContext(ctx, "question blocked by moderation", "categories", result.Categories, "source", result.Source)
+			shared.Set("moderation_categories", result.Categories)
+			return ActionBlocked, nil
+		}),
+	)
+}
+
+// CreateRefusalNode creates a node that stores a polite refusal as the
+// answer, for the moderation node's blocked path
+func CreateRefusalNode() flyt.Node {
+	return flyt.NewNode(
+		flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+			shared.Set("answer", refusalAnswer)
+			return flyt.DefaultAction, nil
+		}),
+	)
+}
+
+// errorActionNode wraps a node so exec failures become a routable action
+type errorActionNode struct {
+	flyt.Node
+	action flyt.Action
+}
+
+// execFailure carries an exec error from Exec to Post
+type execFailure struct {
+	err error
+}
+
+// WithErrorAction wraps node so that when its exec fails (after flyt's
+// retries and the node's own fallback), the error message is stored under "error" and the node returns
+// action instead of aborting the flow. Connect action to a recovery node:
+//
+//	answerNode := WithErrorAction(CreateAnswerNode(), ActionError)
+//	flow.Connect(answerNode, ActionError, CreateFallbackAnswerNode())
+//
+// Flyt has no built-in error action; its FallbackNode hook can only
+// substitute an exec result, not change the route.
+func WithErrorAction(node flyt.Node, action flyt.Action) flyt.Node {
+	return &errorActionNode{Node: node, action: action}
+}
+
+// Post implements flyt.Node
+func (n *errorActionNode) Post(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+	if failure, ok := execResult.(execFailure); ok {
+		shared.Set("error", failure.err.Error())
+		return n.action, nil
+	}
+	return n.Node.Post(ctx, shared, prepResult, execResult)
+}
+
+// GetMaxRetries preserves the wrapped node's retry settings
+func (n *errorActionNode) GetMaxRetries() int {
+	if retryable, ok := n.Node.(flyt.RetryableNode); ok {
+		return retryable.GetMaxRetries()
+	}
+	return 1
+}
+
+// GetWait preserves the wrapped node's retry settings
+func (n *errorActionNode) GetWait() time.Duration {
+	if retryable, ok := n.Node.(flyt.RetryableNode); ok {
+		return retryable.GetWait()
+	}
+	return 0
+}
+
+// ExecFallback runs the wrapped node's fallback and, if exec still failed,
+// hands the error to Post instead of aborting the flow
+func (n *errorActionNode) ExecFallback(prepResult any, err error) (any, error) {
+	var result any
+	if fallback, ok := n.Node.(flyt.FallbackNode); ok {
+		result, err = fallback.ExecFallback(prepResult, err)
+	}
+	if err != nil {
+		return execFailure{err: err}, nil
+	}
+	return result, nil
+}
+
+// retryNode wraps a node and retries its exec phase with exponential backoff
+type retryNode struct {
+	flyt.Node
+	attempts int
+	backoff  time.Duration
+}
+
+// WithRetry wraps node so a failing exec is retried up to attempts times in
+// total, waiting backoff, then 2*backoff, and so on between tries. Prep is
+// not re-run, and the last error is returned if every attempt fails.
+func WithRetry(node flyt.Node, attempts int, backoff time.Duration) flyt.Node {
+	if attempts < 1 {
+		attempts = 1
+	}
+	return &retryNode{Node: node, attempts: attempts, backoff: backoff}
+}
+
+// Exec implements flyt.Node
+func (n *retryNode) Exec(ctx context.Context, prepResult any) (any, error) {
+	var lastErr error
+	for attempt := 0; attempt < n.attempts; attempt++ {
+		if attempt > 0 {
+			wait := n.backoff << (attempt - 1)
+			slog.DebugContext(ctx, "retrying node exec", "attempt", attempt+1, "wait", wait, "error", lastErr)
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return nil, fmt.Errorf("retry cancelled after %d attempt(s): %w", attempt, ctx.Err())
+			}
+		}
+
+		result, err := n.Node.Exec(ctx, prepResult)
+		if err == nil {
+			return result, nil
+		}
+		lastErr = err
+	}
+
+	return nil, fmt.Errorf("failed after %d attempt(s): %w", n.attempts, lastErr)
+}
+
+// ExecFallback preserves the wrapped node's fallback behavior
+func (n *retryNode) ExecFallback(prepResult any, err error) (any, error) {
+	if fallback, ok := n.Node.(flyt.FallbackNode); ok {
+		return fallback.ExecFallback(prepResult, err)
+	}
+	return nil, err
+}
+
+// tracedNode wraps a node, emits debug log records at each lifecycle phase,
+// records an OpenTelemetry span per run when tracing is enabled, and runs
+// any Hooks added by InstrumentFlow. Per-run state lives in tracedPrep, so
+// a flow can run concurrently with several shared stores.
+type tracedNode struct {
+	flyt.Node
+	name  string
+	hooks []Hooks
+}
+
+// tracedPrep carries the node's span context and start time from Prep to
+// Exec and Post, since flyt hands every phase the flow's original ctx
+type tracedPrep struct {
+	ctx    context.Context
+	span   trace.Span
+	start  time.Time
+	shared *flyt.SharedStore
+	result any
+}
+
+// NodeError describes where a flow failed. Traced nodes store it under
+// "last_error" when they fail, since flyt's error doesn't say which node
+// it came from.
+type NodeError struct {
+	Node  string `json:"node"`
+	Phase string `json:"phase"` // "prep", "exec", or "post"
+
+	// From and Action are the node and action that led to Node; both are
+	// empty when Node was the first to run
+	From   string      `json:"from,omitempty"`
+	Action flyt.Action `json:"action,omitempty"`
+
+	Err string `json:"error"`
+}
+
+// flowStep is the last node to finish and the action it returned, kept
+// under "last_step" so a failing node can report how it was reached
+type flowStep struct {
+	Node   string
+	Action flyt.Action
+}
+
+// recordNodeError stores a NodeError for the failing node under "last_error"
+func recordNodeError(shared *flyt.SharedStore, name, phase string, err error) {
+	nodeErr := NodeError{Node: name, Phase: phase, Err: err.Error()}
+	if value, ok := shared.Get("last_step"); ok {
+		if step, ok := value.(flowStep); ok {
+			nodeErr.From, nodeErr.Action = step.Node, step.Action
+		}
+	}
+	shared.Set("last_error", nodeErr)
+}
+
+// traceNode names a node so its prep/exec/post phases show up in debug logs
+// and traces
+func traceNode(name string, node flyt.Node) flyt.Node {
+	return &tracedNode{Node: node, name: name}
+}
+
+// Prep implements flyt.Node
+func (n *tracedNode) Prep(ctx context.Context, shared *flyt.SharedStore) (any, error) {
+	start := time.Now()
+	ctx, span := utils.StartSpan(ctx, "node."+n.name)
+	slog.DebugContext(ctx, "node prep", "node", n.name)
+	result, err := n.Node.Prep(ctx, shared)
+	if err != nil {
+		slog.DebugContext(ctx, "node prep failed", "node", n.name, "error", err)
+		utils.EndSpan(span, err)
+		recordNodeError(shared, n.name, "prep", err)
+		n.afterNode(ctx, start, nil, "", err)
+		return nil, err
+	}
+	n.runHooks(ctx, func(h Hooks) {
+		if h.BeforeNode != nil {
+			h.BeforeNode(ctx, NodeInfo{Name: n.name, PrepResult: result})
+		}
+	})
+	return tracedPrep{ctx: ctx, span: span, start: start, shared: shared, result: result}, nil
+}
+
+// Exec implements flyt.Node, running the wrapped exec inside the node's span
+func (n *tracedNode) Exec(_ context.Context, prepResult any) (any, error) {
+	prep := prepResult.(tracedPrep)
+	ctx := prep.ctx
+	slog.DebugContext(ctx, "node exec", "node", n.name)
+	start := time.Now()
+	result, err := n.Node.Exec(ctx, prep.result)
+	if err != nil {
+		slog.DebugContext(ctx, "node exec failed", "node", n.name, "duration", time.Since(start), "error", err)
+	} else {
+		slog.DebugContext(ctx, "node exec done", "node", n.name, "duration", time.Since(start))
+	}
+	return result, err
+}
+
+// Post implements flyt.Node
+func (n *tracedNode) Post(_ context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+	prep := prepResult.(tracedPrep)
+	ctx := prep.ctx
+	action, err := n.Node.Post(ctx, shared, prep.result, execResult)
+	elapsed := time.Since(prep.start)
+	prep.span.SetAttributes(attribute.String("flyt.action", string(action)))
+	utils.EndSpan(prep.span, err)
+
+	if err != nil {
+		slog.DebugContext(ctx, "node post failed", "node", n.name, "duration", elapsed, "error", err)
+		recordNodeError(shared, n.name, "post", err)
+	} else {
+		slog.DebugContext(ctx, "node done", "node", n.name, "duration", elapsed, "action", action)
+		shared.Set("last_step", flowStep{Node: n.name, Action: action})
+	}
+	n.afterNode(ctx, prep.start, prep.result, action, err)
+	return action, err
+}
+
+// afterNode runs the AfterNode hooks for a finished run
+func (n *tracedNode) afterNode(ctx context.Context, start time.Time, prepResult any, action flyt.Action, err error) {
+	info := NodeInfo{Name: n.name, PrepResult: prepResult, Duration: time.Since(start), Err: err}
+	if err == nil {
+		info.Action = action
+	}
+	n.runHooks(ctx, func(h Hooks) {
+		if h.AfterNode != nil {
+			h.AfterNode(ctx, info)
+		}
+	})
+}
+
+// runHooks calls fn for each of the node's hooks, logging rather than
+// propagating a panic so a faulty hook can't break the node
+func (n *tracedNode) runHooks(ctx context.Context, fn func(Hooks)) {
+	for _, hooks := range n.hooks {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					slog.WarnContext(ctx, "node hook panicked", "node", n.name, "panic", r)
+				}
+			}()
+			fn(hooks)
+		}()
+	}
+}
+
+// GetMaxRetries preserves the wrapped node's retry settings
+func (n *tracedNode) GetMaxRetries() int {
+	if retryable, ok := n.Node.(flyt.RetryableNode); ok {
+		return retryable.GetMaxRetries()
+	}
+	return 1
+}
+
+// GetWait preserves the wrapped node's retry settings
+func (n *tracedNode) GetWait() time.Duration {
+	if retryable, ok := n.Node.(flyt.RetryableNode); ok {
+		return retryable.GetWait()
+	}
+	return 0
+}
+
+// ExecFallback preserves the wrapped node's fallback behavior. flyt skips
+// Post when exec fails for good, so the span ends here in that case.
+func (n *tracedNode) ExecFallback(prepResult any, err error) (any, error) {
+	prep := prepResult.(tracedPrep)
+	var result any
+	if fallback, ok := n.Node.(flyt.FallbackNode); ok {
+		result, err = fallback.ExecFallback(prep.result, err)
+	}
+	if err != nil {
+		utils.EndSpan(prep.span, err)
+		recordNodeError(prep.shared, n.name, "exec", err)
+		n.afterNode(prep.ctx, prep.start, prep.result, "", err)
+	}
+	return result, err
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/flyt"
+
+	"flyt-project-template/utils"
+)
+
+func TestBatchProcessNodeCancelledMidBatchKeepsFinishedResults(t *testing.T) {
+	const items, finished = 6, 3
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The first items finish; the next one cancels the run and blocks
+	// until the cancellation reaches it
+	process := func(ctx context.Context, item any) (any, error) {
+		n := item.(int)
+		if n < finished {
+			return n * 10, nil
+		}
+		cancel()
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	shared := flyt.NewSharedStore()
+	input := make([]int, items)
+	for i := range input {
+		input[i] = i
+	}
+	shared.Set(flyt.KeyItems, input)
+
+	if _, err := flyt.Run(ctx, CreateBatchProcessNodeWithConcurrency(process, 1, false), shared); err != nil {
+		t.Fatalf("Run() error = %v, want partial results instead", err)
+	}
+
+	if cancelled, _ := shared.Get("cancelled"); cancelled != true {
+		t.Errorf("cancelled = %v, want true", cancelled)
+	}
+
+	value, _ := shared.Get(flyt.KeyResults)
+	results := flyt.ToSlice(value)
+	if len(results) != items {
+		t.Fatalf("got %d results, want %d", len(results), items)
+	}
+	for i, result := range results {
+		var want any
+		if i < finished {
+			want = i * 10
+		}
+		if result != want {
+			t.Errorf("results[%d] = %v, want %v", i, result, want)
+		}
+	}
+
+	value, _ = shared.Get("batch_errors")
+	errs, ok := value.([]error)
+	if !ok || len(errs) != items {
+		t.Fatalf("batch_errors = %#v, want %d errors", value, items)
+	}
+	for i, err := range errs {
+		if i < finished && err != nil {
+			t.Errorf("batch_errors[%d] = %v, want nil", i, err)
+		}
+		if i >= finished && !errors.Is(err, errBatchCancelled) {
+			t.Errorf("batch_errors[%d] = %v, want errBatchCancelled", i, err)
+		}
+	}
+}
+
+func TestBatchProcessNodeWithConcurrencyLimitsInFlightItems(t *testing.T) {
+	const items = 20
+
+	for _, maxConcurrent := range []int{1, 3} {
+		t.Run(fmt.Sprintf("max_%d", maxConcurrent), func(t *testing.T) {
+			var inFlight, peak atomic.Int32
+			var mu sync.Mutex
+			var order []int
+
+			process := func(ctx context.Context, item any) (any, error) {
+				current := inFlight.Add(1)
+				defer inFlight.Add(-1)
+				for {
+					seen := peak.Load()
+					if current <= seen || peak.CompareAndSwap(seen, current) {
+						break
+					}
+				}
+
+				mu.Lock()
+				order = append(order, item.(int))
+				mu.Unlock()
+
+				time.Sleep(5 * time.Millisecond)
+				return item.(int) * 2, nil
+			}
+
+			shared := flyt.NewSharedStore()
+			input := make([]int, items)
+			for i := range input {
+				input[i] = i
+			}
+			shared.Set(flyt.KeyItems, input)
+
+			node := CreateBatchProcessNodeWithConcurrency(process, maxConcurrent, false)
+			if _, err := flyt.Run(context.Background(), node, shared); err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+
+			if got := int(peak.Load()); got > maxConcurrent {
+				t.Errorf("%d items ran at once, want at most %d", got, maxConcurrent)
+			}
+
+			value, _ := shared.Get(flyt.KeyResults)
+			results := flyt.ToSlice(value)
+			if len(results) != items {
+				t.Fatalf("got %d results, want %d", len(results), items)
+			}
+			for i, result := range results {
+				if result != i*2 {
+					t.Errorf("results[%d] = %v, want %d", i, result, i*2)
+				}
+			}
+
+			// One at a time means items run in input order
+			if maxConcurrent == 1 && !slices.Equal(order, input) {
+				t.Errorf("items ran in order %v, want %v", order, input)
+			}
+		})
+	}
+}
+
+func TestAnswerNodeWithMockProvider(t *testing.T) {
+	t.Setenv("FLYT_LLM_CACHE", "")
+	mock := &utils.MockProvider{Responses: map[string]string{"capital of France": "Paris"}}
+	t.Cleanup(utils.UseProvider(mock))
+
+	shared := flyt.NewSharedStore()
+	shared.Set("question", "What is the capital of France?")
+	shared.Set("context", "France is a country in Europe.")
+
+	if _, err := flyt.Run(context.Background(), CreateAnswerNode(), shared); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if answer, _ := shared.Get("answer"); answer != "Paris" {
+		t.Errorf("answer = %v, want Paris", answer)
+	}
+
+	calls := mock.Calls()
+	if len(calls) != 1 {
+		t.Fatalf("provider called %d times, want 1", len(calls))
+	}
+	prompt := calls[0][len(calls[0])-1].Content
+	if !strings.Contains(prompt, "France is a country in Europe.") {
+		t.Errorf("prompt %q doesn't include the context", prompt)
+	}
+
+	value, _ := shared.Get("history")
+	history, _ := value.([]utils.Message)
+	if len(history) != 2 || history[0].Role != "user" || history[1].Content != "Paris" {
+		t.Errorf("history = %+v, want the question and answer", history)
+	}
+}
+
+func TestAnalyzeNodeWithMockProvider(t *testing.T) {
+	t.Setenv("FLYT_LLM_CACHE", "")
+
+	tests := []struct {
+		reply      string
+		wantAction flyt.Action
+		wantErr    bool
+	}{
+		{reply: `{"action": "search", "reason": "needs current data"}`, wantAction: "search"},
+		{reply: `{"action": "answer", "reason": "common knowledge"}`, wantAction: "answer"},
+		{reply: `{"action": "guess", "reason": "not an action"}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.reply, func(t *testing.T) {
+			t.Cleanup(utils.UseProvider(&utils.MockProvider{Default: tt.reply}))
+
+			shared := flyt.NewSharedStore()
+			shared.Set("question", "What is the latest Go release?")
+
+			action, err := flyt.Run(context.Background(), CreateAnalyzeNode(), shared)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Run() = %q, want an error", action)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			if action != tt.wantAction {
+				t.Errorf("action = %q, want %q", action, tt.wantAction)
+			}
+			if decision, _ := shared.Get("decision"); decision != string(tt.wantAction) {
+				t.Errorf("decision = %v, want %q", decision, tt.wantAction)
+			}
+		})
+	}
+}
+
+func TestRetriedAnswersAreDroppedFromHistory(t *testing.T) {
+	t.Setenv("FLYT_LLM_CACHE", "")
+
+	tests := []struct {
+		name string
+		flow func() *flyt.Flow
+		// review replies to the checking node's prompt, accepting only
+		// Paris; ok is false for any other prompt
+		review func(prompt string) (reply string, ok bool)
+	}{
+		{
+			name: "validated",
+			flow: CreateValidatedQAFlow,
+			review: func(prompt string) (string, bool) {
+				if !strings.Contains(prompt, "directly and correctly address") {
+					return "", false
+				}
+				return fmt.Sprintf(`{"adequate": %t, "reason": "checked"}`, strings.Contains(prompt, "Answer: Paris")), true
+			},
+		},
+		{
+			name: "confident",
+			flow: func() *flyt.Flow { return CreateConfidentQAFlow(60) },
+			review: func(prompt string) (string, bool) {
+				if !strings.Contains(prompt, "Rate how confident") {
+					return "", false
+				}
+				if strings.Contains(prompt, "Answer: Paris") {
+					return "Score: 95\nReason: correct", true
+				}
+				return "Score: 10\nReason: wrong city", true
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var answerCalls [][]utils.Message
+			mock := &utils.MockProvider{Respond: func(messages []utils.Message, config *utils.LLMConfig) (string, error) {
+				if reply, ok := tt.review(messages[len(messages)-1].Content); ok {
+					return reply, nil
+				}
+				answerCalls = append(answerCalls, messages)
+				if len(answerCalls) == 1 {
+					return "Lyon", nil
+				}
+				return "Paris", nil
+			}}
+			t.Cleanup(utils.UseProvider(mock))
+
+			shared := flyt.NewSharedStore()
+			shared.Set("question", "What is the capital of France?")
+			if err := tt.flow().Run(context.Background(), shared); err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+
+			if answer, _ := shared.Get("answer"); answer != "Paris" {
+				t.Fatalf("answer = %v, want the regenerated Paris", answer)
+			}
+			if len(answerCalls) != 2 {
+				t.Fatalf("answered %d times, want 2", len(answerCalls))
+			}
+			for _, msg := range answerCalls[1] {
+				if strings.Contains(msg.Content, "Lyon") {
+					t.Errorf("retry was sent the rejected answer: %+v", answerCalls[1])
+				}
+			}
+
+			value, _ := shared.Get("history")
+			history, _ := value.([]utils.Message)
+			if len(history) != 2 || history[1].Content != "Paris" {
+				t.Errorf("history = %+v, want one question and the accepted answer", history)
+			}
+		})
+	}
+}
+
+func TestSummarizeSearchNodeUsesRunConfig(t *testing.T) {
+	t.Setenv("FLYT_LLM_CACHE", "")
+
+	var mu sync.Mutex
+	var models []string
+	t.Cleanup(utils.UseProvider(&utils.MockProvider{Respond: func(messages []utils.Message, config *utils.LLMConfig) (string, error) {
+		mu.Lock()
+		models = append(models, config.Model)
+		mu.Unlock()
+		return "Go is a compiled language.", nil
+	}}))
+
+	var results []utils.SearchResult
+	for i := range 40 {
+		results = append(results, utils.SearchResult{
+			Title:   fmt.Sprintf("Result %d", i),
+			URL:     fmt.Sprintf("https://example.com/%d", i),
+			Snippet: strings.Repeat("Go has goroutines and channels for concurrency. ", 20),
+		})
+	}
+
+	config := utils.DefaultLLMConfig()
+	config.Model = "summary-model"
+	shared := flyt.NewSharedStore()
+	shared.Set("search_results", results)
+	shared.Set("llm_config", config)
+
+	if _, err := flyt.Run(context.Background(), CreateSummarizeSearchNode(), shared); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if len(models) == 0 {
+		t.Fatal("no LLM calls, want the results summarized")
+	}
+	for _, model := range models {
+		if model != "summary-model" {
+			t.Errorf("summary call used model %q, want the run's summary-model", model)
+		}
+	}
+	if summary, _ := shared.Get("context"); !strings.Contains(fmt.Sprint(summary), "compiled language") {
+		t.Errorf("context = %v, want the LLM summary", summary)
+	}
+}
+
+func TestSummarizeSearchNodeStopsWhenCancelled(t *testing.T) {
+	t.Setenv("FLYT_LLM_CACHE", "")
+	t.Cleanup(utils.UseProvider(&utils.MockProvider{Default: "summary"}))
+
+	results := []utils.SearchResult{{Title: "Go", URL: "https://go.dev", Snippet: strings.Repeat("word ", 2000)}}
+	shared := flyt.NewSharedStore()
+	shared.Set("search_results", results)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := flyt.Run(ctx, CreateSummarizeSearchNode(), shared); err == nil {
+		t.Error("Run() with a cancelled context error = nil, want the cancellation")
+	}
+}
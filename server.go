@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/flyt"
+
+	"flyt-project-template/utils"
+)
+
+// maxRequestBytes caps the size of request bodies the server will decode
+const maxRequestBytes = 1 << 20
+
+// askRequest is the body of POST /ask
+type askRequest struct {
+	Question string `json:"question"`
+}
+
+// askResponse is the reply from POST /ask
+type askResponse struct {
+	Answer string `json:"answer,omitempty"`
+	Error  string `json:"error,omitempty"`
+
+	// Blocked lists the moderation categories a refused question hit
+	Blocked []string `json:"blocked,omitempty"`
+}
+
+// batchRequest is the body of POST /batch
+type batchRequest struct {
+	Items []string `json:"items"`
+}
+
+// batchSummary is the last line streamed by POST /batch
+type batchSummary struct {
+	Total     int    `json:"total"`
+	Succeeded int    `json:"succeeded"`
+	Failed    int    `json:"failed"`
+	Error     string `json:"error,omitempty"`
+}
+
+// newServer returns an HTTP server exposing the QA and batch flows, plus
+// Prometheus metrics on /metrics. Each request runs
+// against a fresh shared store seeded with llmConfig, bounded by
+// requestTimeout when it is non-zero. qaFlow builds the /ask flow; it and
+// the batch flow are built once and shared by every request, since flows
+// keep per-run state in the shared store.
+func newServer(addr string, llmConfig *utils.LLMConfig, requestTimeout time.Duration, qaFlow func() *flyt.Flow) *http.Server {
+	askFlow := qaFlow()
+	batchFlow := CreateBatchFlow(BatchFlowOptions{
+		ItemsFromStore: true,
+		CollectErrors:  true,
+		JSON:           true,
+		OnItemDone: func(ctx context.Context, item, result any, err error) {
+			if onItemDone, ok := ctx.Value(itemDoneKey{}).(func(item, result any, err error)); ok {
+				onItemDone(item, result, err)
+			}
+		},
+	})
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", utils.EnableMetrics())
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	mux.HandleFunc("/ask", func(w http.ResponseWriter, r *http.Request) {
+		handleAsk(w, r, llmConfig, requestTimeout, askFlow)
+	})
+	mux.HandleFunc("/batch", func(w http.ResponseWriter, r *http.Request) {
+		handleBatch(w, r, llmConfig, requestTimeout, batchFlow)
+	})
+
+	return &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+}
+
+// handleAsk answers {"question": ...} with {"answer": ...}. An LLM failure
+// is reported as 502 along with the fallback answer, and a request that
+// runs past its deadline as 504.
+func handleAsk(w http.ResponseWriter, r *http.Request, llmConfig *utils.LLMConfig, requestTimeout time.Duration, flow *flyt.Flow) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, askResponse{Error: "use POST"})
+		return
+	}
+
+	var req askRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes)).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, askResponse{Error: "invalid JSON body: " + err.Error()})
+		return
+	}
+	req.Question = strings.TrimSpace(req.Question)
+	if req.Question == "" {
+		writeJSON(w, http.StatusBadRequest, askResponse{Error: "question is required"})
+		return
+	}
+
+	ctx := r.Context()
+	if requestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, requestTimeout)
+		defer cancel()
+	}
+
+	shared := flyt.NewSharedStore()
+	shared.Set("llm_config", llmConfig)
+	shared.Set("question", req.Question)
+
+	runCtx, span := utils.StartSpan(ctx, "flow.qa")
+	err := flow.Run(runCtx, shared)
+	utils.EndSpan(span, err)
+	utils.RecordFlowRun("qa", err)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			status = http.StatusGatewayTimeout
+		}
+		slog.Error("Ask failed", "error", err)
+		writeJSON(w, status, askResponse{Error: err.Error()})
+		return
+	}
+
+	// A custom flow may finish without a string answer; report that
+	// instead of panicking the handler
+	value, ok := shared.Get("answer")
+	if !ok {
+		slog.Error("Ask flow finished without an answer")
+		writeJSON(w, http.StatusInternalServerError, askResponse{Error: "flow produced no answer"})
+		return
+	}
+	answer, ok := value.(string)
+	if !ok {
+		slog.Error("Ask flow answer is not a string", "type", fmt.Sprintf("%T", value))
+		writeJSON(w, http.StatusInternalServerError, askResponse{Error: fmt.Sprintf("flow produced a %T answer, want a string", value)})
+		return
+	}
+
+	resp := askResponse{Answer: answer}
+	if categories, ok := shared.Get("moderation_categories"); ok {
+		resp.Blocked, _ = categories.([]string)
+	}
+	if failure, ok := shared.Get("error"); ok {
+		// The QA flow fell back to a canned answer
+		resp.Error = fmt.Sprint(failure)
+		status := http.StatusBadGateway
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			status = http.StatusGatewayTimeout
+		}
+		writeJSON(w, status, resp)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleBatch runs the batch flow over {"items": [...]} and streams one
+// BatchItemResult JSON line per item as it completes, then a final
+// {"summary": ...} line. Item failures don't stop the batch. The flow runs
+// under the request context, so it stops if the client disconnects.
+func handleBatch(w http.ResponseWriter, r *http.Request, llmConfig *utils.LLMConfig, requestTimeout time.Duration, flow *flyt.Flow) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, askResponse{Error: "use POST"})
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSON(w, http.StatusInternalServerError, askResponse{Error: "streaming unsupported"})
+		return
+	}
+
+	var req batchRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes)).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, askResponse{Error: "invalid JSON body: " + err.Error()})
+		return
+	}
+	if len(req.Items) == 0 {
+		writeJSON(w, http.StatusBadRequest, askResponse{Error: "items are required"})
+		return
+	}
+
+	ctx := r.Context()
+	if requestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, requestTimeout)
+		defer cancel()
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+
+	// Items finish concurrently, so serialize writes to the response
+	var mu sync.Mutex
+	encoder := json.NewEncoder(w)
+	summary := batchSummary{Total: len(req.Items)}
+	onItemDone := func(item, result any, err error) {
+		line := BatchItemResult{Item: item, Result: result}
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			line.Error = err.Error()
+			summary.Failed++
+		} else {
+			summary.Succeeded++
+		}
+		if err := encoder.Encode(line); err == nil {
+			flusher.Flush()
+		}
+	}
+
+	shared := flyt.NewSharedStore()
+	shared.Set("llm_config", llmConfig)
+	shared.Set(flyt.KeyItems, req.Items)
+
+	// The shared batch flow reports items to this request's onItemDone
+	ctx = context.WithValue(ctx, itemDoneKey{}, onItemDone)
+	runCtx, span := utils.StartSpan(ctx, "flow.batch")
+	err := flow.Run(runCtx, shared)
+	utils.EndSpan(span, err)
+	utils.RecordFlowRun("batch", err)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if err != nil {
+		slog.Error("Batch failed", "error", err)
+		summary.Error = err.Error()
+	}
+	if err := encoder.Encode(map[string]batchSummary{"summary": summary}); err == nil {
+		flusher.Flush()
+	}
+}
+
+// itemDoneKey is the context key for a /batch request's item callback
+type itemDoneKey struct{}
+
+// writeJSON writes v as a JSON response with the given status
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Failed to write response", "error", err)
+	}
+}
+
+// runServer serves until ctx is cancelled, then shuts down gracefully,
+// giving in-flight requests a few seconds to finish
+func runServer(ctx context.Context, server *http.Server) error {
+	errs := make(chan error, 1)
+	go func() {
+		slog.Info("Serving", "addr", server.Addr)
+		errs <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+		slog.Info("Shutting down server")
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return server.Shutdown(shutdownCtx)
+}
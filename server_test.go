@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mark3labs/flyt"
+
+	"flyt-project-template/utils"
+)
+
+func TestHandleAskRejectsMissingOrNonStringAnswer(t *testing.T) {
+	tests := []struct {
+		name   string
+		answer any
+		set    bool
+	}{
+		{name: "no answer"},
+		{name: "non-string answer", answer: 42, set: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flow := flyt.NewFlow(flyt.NewNode(
+				flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+					if tt.set {
+						shared.Set("answer", tt.answer)
+					}
+					return flyt.DefaultAction, nil
+				}),
+			))
+
+			req := httptest.NewRequest(http.MethodPost, "/ask", strings.NewReader(`{"question": "What is Go?"}`))
+			rec := httptest.NewRecorder()
+			handleAsk(rec, req, utils.DefaultLLMConfig(), 0, flow)
+
+			if rec.Code != http.StatusInternalServerError {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+			}
+			var resp askResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("decoding response: %v", err)
+			}
+			if resp.Error == "" || resp.Answer != "" {
+				t.Errorf("response = %+v, want an error and no answer", resp)
+			}
+		})
+	}
+}
+
+func TestServerSharesFlowsAcrossConcurrentRequests(t *testing.T) {
+	t.Setenv("FLYT_LLM_CACHE", "")
+	t.Cleanup(utils.UseProvider(&utils.MockProvider{Default: "Answer to: {{prompt}}"}))
+
+	server := httptest.NewServer(newServer("", utils.DefaultLLMConfig(), 0, CreateQAFlow).Handler)
+	defer server.Close()
+
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			question := fmt.Sprintf("question %d", i)
+			resp, err := http.Post(server.URL+"/ask", "application/json", strings.NewReader(`{"question": "`+question+`"}`))
+			if err != nil {
+				t.Errorf("POST /ask: %v", err)
+				return
+			}
+			defer resp.Body.Close()
+			var body askResponse
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || !strings.Contains(body.Answer, question) {
+				t.Errorf("POST /ask %q = %+v (%v), want an answer to it", question, body, err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			items := []string{fmt.Sprintf("a%d", i), fmt.Sprintf("b%d", i)}
+			payload, _ := json.Marshal(batchRequest{Items: items})
+			resp, err := http.Post(server.URL+"/batch", "application/json", bytes.NewReader(payload))
+			if err != nil {
+				t.Errorf("POST /batch: %v", err)
+				return
+			}
+			defer resp.Body.Close()
+			data, _ := io.ReadAll(resp.Body)
+			lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+			if len(lines) != len(items)+1 {
+				t.Errorf("POST /batch %v streamed %d lines, want %d:\n%s", items, len(lines), len(items)+1, data)
+				return
+			}
+			for _, item := range items {
+				if !strings.Contains(string(data), `"item":"`+item+`"`) {
+					t.Errorf("POST /batch %v response is missing %q:\n%s", items, item, data)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"time"
+
+	"github.com/mark3labs/flyt"
+
+	"flyt-project-template/utils"
+)
+
+// SnapshotStore persists the shared store between runs, so a long-running
+// agent can pick up where it left off after a restart
+type SnapshotStore interface {
+	Save(shared *flyt.SharedStore) error
+	Load() (*flyt.SharedStore, error)
+}
+
+// FileSnapshotStore is a SnapshotStore backed by a JSON file, written by
+// SaveSnapshot and read by LoadSnapshot
+type FileSnapshotStore struct {
+	Path string
+}
+
+// Save implements SnapshotStore
+func (s FileSnapshotStore) Save(shared *flyt.SharedStore) error {
+	return SaveSnapshot(shared, s.Path)
+}
+
+// Load implements SnapshotStore
+func (s FileSnapshotStore) Load() (*flyt.SharedStore, error) {
+	return LoadSnapshot(s.Path)
+}
+
+// snapshotHooks returns flow hooks that save shared to store after every
+// node, so a crash or kill loses at most the node that was running. A
+// failed save is only a warning.
+func snapshotHooks(store SnapshotStore, shared *flyt.SharedStore) Hooks {
+	return Hooks{
+		AfterNode: func(ctx context.Context, info NodeInfo) {
+			if err := store.Save(shared); err != nil {
+				slog.WarnContext(ctx, "Failed to save snapshot", "node", info.Name, "error", err)
+			}
+		},
+	}
+}
+
+// snapshot is the file format of SaveSnapshot. Each value keeps its Go
+// type name so LoadSnapshot can restore it as that type.
+type snapshot struct {
+	SavedAt time.Time                `json:"saved_at"`
+	Values  map[string]snapshotValue `json:"values"`
+}
+
+type snapshotValue struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+// snapshotDecoders restore the value types nodes read back with type
+// assertions. Other types are restored as generic JSON values (maps,
+// slices, strings, float64s, and bools).
+var snapshotDecoders = map[string]func(json.RawMessage) (any, error){}
+
+func init() {
+	registerSnapshotType[string]()
+	registerSnapshotType[int]()
+	registerSnapshotType[float64]()
+	registerSnapshotType[bool]()
+	registerSnapshotType[[]string]()
+	registerSnapshotType[[]any]()
+	registerSnapshotType[map[string]any]()
+	registerSnapshotType[[]utils.Message]()
+	registerSnapshotType[[]utils.SearchResult]()
+	registerSnapshotType[utils.Entities]()
+	registerSnapshotType[utils.TextStatistics]()
+	registerSnapshotType[*utils.LLMConfig]()
+	registerSnapshotType[[]DocumentChunk]()
+}
+
+// unsavedKeys hold run-time objects each run sets up again, so snapshots
+// leave them out without a warning
+var unsavedKeys = map[string]bool{
+	"result_sinks":    true,
+	"prompt_template": true,
+	"on_token":        true,
+	"answer_stream":   true,
+}
+
+// registerSnapshotType lets LoadSnapshot restore values of type T
+func registerSnapshotType[T any]() {
+	var zero T
+	snapshotDecoders[fmt.Sprintf("%T", zero)] = func(data json.RawMessage) (any, error) {
+		var value T
+		err := json.Unmarshal(data, &value)
+		return value, err
+	}
+}
+
+// SaveSnapshot writes every JSON-serializable value in shared to path,
+// replacing the file atomically so a crash never leaves half a snapshot.
+// Values that can't be serialized, such as functions, errors, open sinks,
+// or structs with only unexported fields, are skipped with a warning.
+func SaveSnapshot(shared *flyt.SharedStore, path string) error {
+	snap := snapshot{
+		SavedAt: time.Now().UTC(),
+		Values:  make(map[string]snapshotValue),
+	}
+
+	all := shared.GetAll()
+	for _, key := range slices.Sorted(maps.Keys(all)) {
+		if unsavedKeys[key] {
+			continue
+		}
+		value := all[key]
+		data, ok := snapshotJSON(value)
+		if !ok {
+			slog.Warn("Not saving shared store value that can't be serialized", "key", key, "type", fmt.Sprintf("%T", value))
+			continue
+		}
+		snap.Values[key] = snapshotValue{Type: fmt.Sprintf("%T", value), Value: data}
+	}
+
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(dir, ".snapshot-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	return nil
+}
+
+// snapshotJSON encodes value, reporting false for values that don't
+// survive a JSON round trip in any useful form
+func snapshotJSON(value any) (json.RawMessage, bool) {
+	switch value.(type) {
+	case nil:
+		return nil, false
+	case error, []error:
+		return nil, false
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, false
+	}
+	// Structs with only unexported fields, like templates, encode as {}
+	if string(data) == "{}" && reflect.Indirect(reflect.ValueOf(value)).Kind() != reflect.Map {
+		return nil, false
+	}
+	return data, true
+}
+
+// LoadSnapshot reads a snapshot written by SaveSnapshot into a new shared
+// store. Values of registered types come back as the same Go type; others
+// come back as generic JSON values.
+func LoadSnapshot(path string) (*flyt.SharedStore, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("failed to parse snapshot %s: %w", path, err)
+	}
+	if snap.Values == nil {
+		return nil, fmt.Errorf("snapshot %s has no values", path)
+	}
+
+	shared := flyt.NewSharedStore()
+	var errs []error
+	for key, entry := range snap.Values {
+		decode, ok := snapshotDecoders[entry.Type]
+		if !ok {
+			slog.Debug("Restoring snapshot value as generic JSON", "key", key, "type", entry.Type)
+			decode = func(data json.RawMessage) (any, error) {
+				var value any
+				err := json.Unmarshal(data, &value)
+				return value, err
+			}
+		}
+		value, err := decode(entry.Value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("value %q: %w", key, err))
+			continue
+		}
+		shared.Set(key, value)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, fmt.Errorf("failed to restore snapshot %s: %w", path, err)
+	}
+	return shared, nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/mark3labs/flyt"
+)
+
+// recordingSnapshotStore keeps the "step" value of every saved store
+type recordingSnapshotStore struct {
+	steps []any
+}
+
+// Save implements SnapshotStore
+func (s *recordingSnapshotStore) Save(shared *flyt.SharedStore) error {
+	step, _ := shared.Get("step")
+	s.steps = append(s.steps, step)
+	return nil
+}
+
+// Load implements SnapshotStore
+func (s *recordingSnapshotStore) Load() (*flyt.SharedStore, error) {
+	return flyt.NewSharedStore(), nil
+}
+
+func TestSnapshotHooksSaveAfterEveryNode(t *testing.T) {
+	step := func(name string, err error) flyt.Node {
+		return traceNode(name, flyt.NewNode(
+			flyt.WithPostFunc(func(ctx context.Context, shared *flyt.SharedStore, prepResult, execResult any) (flyt.Action, error) {
+				if err != nil {
+					return "", err
+				}
+				shared.Set("step", name)
+				return flyt.DefaultAction, nil
+			}),
+		))
+	}
+	first, second, third := step("first", nil), step("second", nil), step("third", errors.New("killed"))
+	flow := newFlow(first)
+	connect(flow, first, flyt.DefaultAction, second)
+	connect(flow, second, flyt.DefaultAction, third)
+
+	store := &recordingSnapshotStore{}
+	shared := flyt.NewSharedStore()
+	if err := InstrumentFlow(flow, snapshotHooks(store, shared)); err != nil {
+		t.Fatalf("InstrumentFlow() error = %v", err)
+	}
+	if err := flow.Run(context.Background(), shared); err == nil {
+		t.Fatal("Run() error = nil, want the third node's error")
+	}
+
+	// The failing node still saves what the finished ones stored
+	want := []any{"first", "second", "second"}
+	if len(store.steps) != len(want) {
+		t.Fatalf("saved %v, want %v", store.steps, want)
+	}
+	for i := range want {
+		if store.steps[i] != want[i] {
+			t.Errorf("save %d has step %v, want %v", i, store.steps[i], want[i])
+		}
+	}
+}
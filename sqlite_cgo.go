@@ -0,0 +1,7 @@
+//go:build cgo
+
+package main
+
+// The SQLite driver needs cgo, so builds with CGO_ENABLED=0 leave it out
+// and report SQLite input and output as unavailable
+import _ "github.com/mattn/go-sqlite3" // Registers the "sqlite3" driver used by utils.SQLiteDriver
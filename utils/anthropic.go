@@ -0,0 +1,223 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// AnthropicProvider talks to the Anthropic Messages API
+type AnthropicProvider struct {
+	// APIKey overrides the ANTHROPIC_API_KEY environment variable when set
+	APIKey string
+}
+
+const (
+	anthropicAPIURL       = "https://api.anthropic.com/v1/messages"
+	anthropicVersion      = "2023-06-01"
+	anthropicDefaultModel = "claude-3-5-haiku-latest"
+
+	// The Messages API requires max_tokens, so use this when the config leaves it unset
+	anthropicDefaultMaxTokens = 1024
+)
+
+// Complete implements LLMProvider for Anthropic
+func (p *AnthropicProvider) Complete(ctx context.Context, prompt string, config *LLMConfig) (string, error) {
+	return p.Chat(ctx, PromptMessages(prompt), config)
+}
+
+// Chat implements LLMProvider for Anthropic. System messages are lifted
+// into the top-level system field since the Messages API has no system role.
+func (p *AnthropicProvider) Chat(ctx context.Context, messages []Message, config *LLMConfig) (string, error) {
+	jsonData, headers, model, err := p.request(messages, config, false)
+	if err != nil {
+		return "", err
+	}
+
+	body, err := postJSONWithRetry(ctx, anthropicAPIURL, headers, jsonData, config)
+	if err != nil {
+		return "", err
+	}
+
+	// Parse response
+	var result struct {
+		Content []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"content"`
+		Usage anthropicUsage `json:"usage"`
+	}
+
+	if err := json.Unmarshal(body, &result); err != nil {
+		return "", fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	var text strings.Builder
+	for _, block := range result.Content {
+		if block.Type == "text" {
+			text.WriteString(block.Text)
+		}
+	}
+
+	if text.Len() == 0 {
+		return "", fmt.Errorf("no response from API")
+	}
+
+	recordUsage(ctx, model, result.Usage.usage())
+	return text.String(), nil
+}
+
+// ChatStream implements StreamingProvider for Anthropic, reading the text
+// deltas of the Messages API's server-sent events
+func (p *AnthropicProvider) ChatStream(ctx context.Context, messages []Message, config *LLMConfig, onChunk func(string) error) error {
+	jsonData, headers, model, err := p.request(messages, config, true)
+	if err != nil {
+		return err
+	}
+
+	resp, err := openStream(ctx, anthropicAPIURL, "text/event-stream", headers, jsonData, config)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var usage anthropicUsage
+	stopped := false
+	err = readServerSentEvents(ctx, resp.Body, func(data string) (bool, error) {
+		var event struct {
+			Type  string `json:"type"`
+			Delta struct {
+				Type string `json:"type"`
+				Text string `json:"text"`
+			} `json:"delta"`
+			Message struct {
+				Usage anthropicUsage `json:"usage"`
+			} `json:"message"`
+			Usage anthropicUsage `json:"usage"`
+			Error struct {
+				Type    string `json:"type"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return false, fmt.Errorf("failed to parse stream chunk: %w", err)
+		}
+
+		switch event.Type {
+		case "message_start":
+			usage.InputTokens = event.Message.Usage.InputTokens
+		case "content_block_delta":
+			if event.Delta.Type == "text_delta" && event.Delta.Text != "" {
+				return false, onChunk(event.Delta.Text)
+			}
+		case "message_delta":
+			usage.OutputTokens = event.Usage.OutputTokens
+		case "message_stop":
+			stopped = true
+			return true, nil
+		case "error":
+			return false, fmt.Errorf("anthropic: %s: %s", event.Error.Type, event.Error.Message)
+		}
+		return false, nil
+	})
+	if err != nil {
+		return err
+	}
+	if !stopped {
+		return fmt.Errorf("stream ended before the reply was done")
+	}
+
+	recordUsage(ctx, model, usage.usage())
+	return nil
+}
+
+// anthropicUsage is the token usage the Messages API reports
+type anthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// usage converts u to the OpenAI-style Usage recorded for a run
+func (u anthropicUsage) usage() Usage {
+	return Usage{
+		PromptTokens:     u.InputTokens,
+		CompletionTokens: u.OutputTokens,
+		TotalTokens:      u.InputTokens + u.OutputTokens,
+	}
+}
+
+// request encodes a Messages API request for messages, returning it with
+// the auth headers and the model it asks for
+func (p *AnthropicProvider) request(messages []Message, config *LLMConfig, stream bool) ([]byte, map[string]string, string, error) {
+	if err := validateMessages(messages); err != nil {
+		return nil, nil, "", err
+	}
+
+	apiKey := p.APIKey
+	if apiKey == "" {
+		apiKey = os.Getenv("ANTHROPIC_API_KEY")
+	}
+	if apiKey == "" {
+		return nil, nil, "", fmt.Errorf("ANTHROPIC_API_KEY environment variable not set")
+	}
+
+	model := config.Model
+	if model == "" {
+		model = anthropicDefaultModel
+	}
+
+	maxTokens := config.MaxTokens
+	if maxTokens <= 0 {
+		maxTokens = anthropicDefaultMaxTokens
+	}
+
+	var system []string
+	var turns []Message
+	for _, msg := range messages {
+		if msg.Role == "system" {
+			system = append(system, msg.Content)
+			continue
+		}
+		turns = append(turns, msg)
+	}
+
+	// The Messages API has no JSON mode, so ask for it in the system prompt
+	if config.JSONMode {
+		system = append(system, "Respond only with a single valid JSON object and no other text.")
+	}
+
+	if len(turns) == 0 {
+		return nil, nil, "", fmt.Errorf("conversation must contain at least one user message")
+	}
+
+	// Prepare request body
+	requestBody := map[string]any{
+		"model":       model,
+		"messages":    turns,
+		"temperature": config.Temperature,
+		"max_tokens":  maxTokens,
+	}
+
+	if len(system) > 0 {
+		requestBody["system"] = strings.Join(system, "\n\n")
+	}
+
+	if stream {
+		requestBody["stream"] = true
+	}
+
+	jsonData, err := json.Marshal(requestBody)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	headers := map[string]string{
+		"x-api-key":         apiKey,
+		"anthropic-version": anthropicVersion,
+	}
+
+	return jsonData, headers, model, nil
+}
@@ -0,0 +1,148 @@
+package utils
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// AzureOpenAIProvider talks to an Azure OpenAI resource. Azure serves the
+// Chat Completions API under a per-deployment path, versioned with an
+// api-version query parameter and authenticated with an api-key header.
+type AzureOpenAIProvider struct {
+	// APIKey overrides the AZURE_OPENAI_API_KEY environment variable
+	APIKey string
+
+	// Endpoint is the resource URL, like https://myresource.openai.azure.com,
+	// overriding AZURE_OPENAI_ENDPOINT
+	Endpoint string
+
+	// Deployment overrides AZURE_OPENAI_DEPLOYMENT. When neither is set,
+	// LLMConfig.Model names the deployment.
+	Deployment string
+
+	// APIVersion overrides AZURE_OPENAI_API_VERSION, which defaults to
+	// AzureOpenAIAPIVersion
+	APIVersion string
+}
+
+// AzureOpenAIAPIVersion is the api-version used when neither the provider
+// nor AZURE_OPENAI_API_VERSION sets one
+var AzureOpenAIAPIVersion = "2024-06-01"
+
+// Complete implements LLMProvider for Azure OpenAI
+func (p *AzureOpenAIProvider) Complete(ctx context.Context, prompt string, config *LLMConfig) (string, error) {
+	return p.Chat(ctx, PromptMessages(prompt), config)
+}
+
+// Chat implements LLMProvider for Azure OpenAI
+func (p *AzureOpenAIProvider) Chat(ctx context.Context, messages []Message, config *LLMConfig) (string, error) {
+	content, usage, err := p.ChatWithUsage(ctx, messages, config)
+	if err != nil {
+		return "", err
+	}
+	slog.DebugContext(ctx, "LLM usage",
+		"prompt_tokens", usage.PromptTokens,
+		"completion_tokens", usage.CompletionTokens,
+		"system_fingerprint", usage.SystemFingerprint)
+	return content, nil
+}
+
+// ChatWithUsage implements UsageReporter for Azure OpenAI
+func (p *AzureOpenAIProvider) ChatWithUsage(ctx context.Context, messages []Message, config *LLMConfig) (string, Usage, error) {
+	if err := validateMessages(messages); err != nil {
+		return "", Usage{}, err
+	}
+
+	apiKey := cmp.Or(p.APIKey, os.Getenv("AZURE_OPENAI_API_KEY"))
+	if apiKey == "" {
+		return "", Usage{}, fmt.Errorf("AZURE_OPENAI_API_KEY environment variable not set")
+	}
+
+	endpoint, err := p.ChatCompletionsURL(config.Model)
+	if err != nil {
+		return "", Usage{}, err
+	}
+
+	headers := map[string]string{
+		"api-key": apiKey,
+	}
+
+	// Azure picks the model from the deployment and ignores "model"
+	return chatCompletion(ctx, endpoint, headers, p.deployment(config.Model), messages, config)
+}
+
+// ChatStream implements StreamingProvider for Azure OpenAI
+func (p *AzureOpenAIProvider) ChatStream(ctx context.Context, messages []Message, config *LLMConfig, onChunk func(string) error) error {
+	if err := validateMessages(messages); err != nil {
+		return err
+	}
+
+	apiKey := cmp.Or(p.APIKey, os.Getenv("AZURE_OPENAI_API_KEY"))
+	if apiKey == "" {
+		return fmt.Errorf("AZURE_OPENAI_API_KEY environment variable not set")
+	}
+
+	endpoint, err := p.ChatCompletionsURL(config.Model)
+	if err != nil {
+		return err
+	}
+
+	headers := map[string]string{
+		"api-key": apiKey,
+	}
+
+	return chatCompletionStream(ctx, endpoint, headers, p.deployment(config.Model), messages, config, onChunk)
+}
+
+// ChatWithTools implements ToolCaller for Azure OpenAI
+func (p *AzureOpenAIProvider) ChatWithTools(ctx context.Context, messages []Message, tools []ToolDef, config *LLMConfig) (*ToolResponse, error) {
+	if err := validateToolMessages(messages); err != nil {
+		return nil, err
+	}
+
+	apiKey := cmp.Or(p.APIKey, os.Getenv("AZURE_OPENAI_API_KEY"))
+	if apiKey == "" {
+		return nil, fmt.Errorf("AZURE_OPENAI_API_KEY environment variable not set")
+	}
+
+	endpoint, err := p.ChatCompletionsURL(config.Model)
+	if err != nil {
+		return nil, err
+	}
+
+	headers := map[string]string{
+		"api-key": apiKey,
+	}
+
+	return toolChatCompletion(ctx, endpoint, headers, p.deployment(config.Model), messages, tools, config)
+}
+
+// ChatCompletionsURL returns the Chat Completions URL for the provider's
+// deployment, or for model when no deployment is configured:
+// {endpoint}/openai/deployments/{deployment}/chat/completions?api-version=...
+func (p *AzureOpenAIProvider) ChatCompletionsURL(model string) (string, error) {
+	endpoint := strings.TrimRight(cmp.Or(p.Endpoint, os.Getenv("AZURE_OPENAI_ENDPOINT")), "/")
+	if endpoint == "" {
+		return "", fmt.Errorf("AZURE_OPENAI_ENDPOINT environment variable not set")
+	}
+
+	deployment := p.deployment(model)
+	if deployment == "" {
+		return "", fmt.Errorf("no Azure OpenAI deployment: set AZURE_OPENAI_DEPLOYMENT or the model")
+	}
+
+	version := cmp.Or(p.APIVersion, os.Getenv("AZURE_OPENAI_API_VERSION"), AzureOpenAIAPIVersion)
+
+	return fmt.Sprintf("%s/openai/deployments/%s/chat/completions?api-version=%s",
+		endpoint, url.PathEscape(deployment), url.QueryEscape(version)), nil
+}
+
+// deployment returns the configured deployment name, falling back to model
+func (p *AzureOpenAIProvider) deployment(model string) string {
+	return cmp.Or(p.Deployment, os.Getenv("AZURE_OPENAI_DEPLOYMENT"), model)
+}
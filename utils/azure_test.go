@@ -0,0 +1,128 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAzureChatCompletionsURL(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider AzureOpenAIProvider
+		env      map[string]string
+		model    string
+		want     string
+		wantErr  string
+	}{
+		{
+			name:     "endpoint without trailing slash",
+			provider: AzureOpenAIProvider{Endpoint: "https://res.openai.azure.com", Deployment: "gpt4o"},
+			want:     "https://res.openai.azure.com/openai/deployments/gpt4o/chat/completions?api-version=" + AzureOpenAIAPIVersion,
+		},
+		{
+			name:     "endpoint with trailing slash",
+			provider: AzureOpenAIProvider{Endpoint: "https://res.openai.azure.com/", Deployment: "gpt4o"},
+			want:     "https://res.openai.azure.com/openai/deployments/gpt4o/chat/completions?api-version=" + AzureOpenAIAPIVersion,
+		},
+		{
+			name:  "endpoint and deployment from env",
+			env:   map[string]string{"AZURE_OPENAI_ENDPOINT": "https://env.openai.azure.com/", "AZURE_OPENAI_DEPLOYMENT": "env-deploy"},
+			model: "gpt-4o",
+			want:  "https://env.openai.azure.com/openai/deployments/env-deploy/chat/completions?api-version=" + AzureOpenAIAPIVersion,
+		},
+		{
+			name:     "deployment overrides env",
+			provider: AzureOpenAIProvider{Deployment: "mine"},
+			env:      map[string]string{"AZURE_OPENAI_ENDPOINT": "https://env.openai.azure.com", "AZURE_OPENAI_DEPLOYMENT": "env-deploy"},
+			want:     "https://env.openai.azure.com/openai/deployments/mine/chat/completions?api-version=" + AzureOpenAIAPIVersion,
+		},
+		{
+			name:     "model when no deployment",
+			provider: AzureOpenAIProvider{Endpoint: "https://res.openai.azure.com"},
+			model:    "gpt-4o-mini",
+			want:     "https://res.openai.azure.com/openai/deployments/gpt-4o-mini/chat/completions?api-version=" + AzureOpenAIAPIVersion,
+		},
+		{
+			name:     "api-version from env",
+			provider: AzureOpenAIProvider{Endpoint: "https://res.openai.azure.com", Deployment: "gpt4o"},
+			env:      map[string]string{"AZURE_OPENAI_API_VERSION": "2024-10-21"},
+			want:     "https://res.openai.azure.com/openai/deployments/gpt4o/chat/completions?api-version=2024-10-21",
+		},
+		{
+			name:     "api-version overrides env",
+			provider: AzureOpenAIProvider{Endpoint: "https://res.openai.azure.com", Deployment: "gpt4o", APIVersion: "2025-01-01-preview"},
+			env:      map[string]string{"AZURE_OPENAI_API_VERSION": "2024-10-21"},
+			want:     "https://res.openai.azure.com/openai/deployments/gpt4o/chat/completions?api-version=2025-01-01-preview",
+		},
+		{
+			name:     "escaping",
+			provider: AzureOpenAIProvider{Endpoint: "https://res.openai.azure.com", Deployment: "my deploy/v1?x", APIVersion: "a&b=c"},
+			want:     "https://res.openai.azure.com/openai/deployments/my%20deploy%2Fv1%3Fx/chat/completions?api-version=a%26b%3Dc",
+		},
+		{
+			name:     "no endpoint",
+			provider: AzureOpenAIProvider{Deployment: "gpt4o"},
+			wantErr:  "AZURE_OPENAI_ENDPOINT",
+		},
+		{
+			name:     "no deployment or model",
+			provider: AzureOpenAIProvider{Endpoint: "https://res.openai.azure.com"},
+			wantErr:  "no Azure OpenAI deployment",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"AZURE_OPENAI_ENDPOINT", "AZURE_OPENAI_DEPLOYMENT", "AZURE_OPENAI_API_VERSION"} {
+				t.Setenv(key, tt.env[key])
+			}
+
+			got, err := tt.provider.ChatCompletionsURL(tt.model)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ChatCompletionsURL() error = %v, want one mentioning %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ChatCompletionsURL() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("ChatCompletionsURL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAzureChatStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/openai/deployments/gpt4o/chat/completions" || r.Header.Get("api-key") != "test-key" {
+			http.Error(w, "unexpected request "+r.URL.String(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, chunk := range []string{"Par", "is"} {
+			fmt.Fprintf(w, "data: {\"choices\":[{\"delta\":{\"content\":%q}}]}\n\n", chunk)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+
+	t.Cleanup(UseProvider(&AzureOpenAIProvider{APIKey: "test-key", Endpoint: server.URL, Deployment: "gpt4o"}))
+
+	var chunks []string
+	err := CallLLMStreamingWithConfig(context.Background(), "What is the capital of France?", DefaultLLMConfig(), func(chunk string) error {
+		chunks = append(chunks, chunk)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("CallLLMStreamingWithConfig() error = %v", err)
+	}
+	if got := strings.Join(chunks, "|"); got != "Par|is" {
+		t.Errorf("streamed chunks = %q, want %q", got, "Par|is")
+	}
+}
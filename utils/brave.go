@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+)
+
+// BraveSearcher searches using the Brave Search API
+type BraveSearcher struct {
+	// APIKey overrides the BRAVE_API_KEY environment variable when set
+	APIKey string
+
+	// Options controls result count and paging
+	Options SearchOptions
+}
+
+// braveMaxCount is the largest page size the Brave API accepts
+const braveMaxCount = 20
+
+// Search implements Searcher
+func (s *BraveSearcher) Search(ctx context.Context, query string) ([]SearchResult, error) {
+	apiKey := s.APIKey
+	if apiKey == "" {
+		apiKey = os.Getenv("BRAVE_API_KEY")
+	}
+	if apiKey == "" {
+		return nil, fmt.Errorf("BRAVE_API_KEY environment variable not set")
+	}
+
+	if err := s.Options.validate(); err != nil {
+		return nil, err
+	}
+
+	// Brave pages in whole pages of count results, so request enough to
+	// cover offset+limit and slice locally
+	params := url.Values{"q": {query}}
+	if s.Options.Limit > 0 {
+		params.Set("count", strconv.Itoa(min(s.Options.Offset+s.Options.Limit, braveMaxCount)))
+	}
+	apiURL := "https://api.search.brave.com/res/v1/web/search?" + params.Encode()
+	headers := map[string]string{
+		"Accept":               "application/json",
+		"X-Subscription-Token": apiKey,
+	}
+
+	body, err := searchGet(ctx, query, apiURL, headers)
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse Brave response
+	var braveResponse struct {
+		Web struct {
+			Results []struct {
+				Title       string `json:"title"`
+				URL         string `json:"url"`
+				Description string `json:"description"`
+			} `json:"results"`
+		} `json:"web"`
+	}
+
+	if err := json.Unmarshal(body, &braveResponse); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	var results []SearchResult
+	for _, r := range braveResponse.Web.Results {
+		snippet := stripTags(r.Description)
+		results = append(results, SearchResult{
+			Title:       stripTags(r.Title),
+			URL:         r.URL,
+			Snippet:     snippet,
+			Description: snippet,
+		})
+	}
+
+	return paginate(results, s.Options.Offset, s.Options.Limit), nil
+}
@@ -0,0 +1,151 @@
+package utils
+
+import (
+	"container/list"
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SearchCache wraps a Searcher and remembers results for a fixed TTL,
+// so repeated queries within an agent loop don't hit the backend again.
+// It is safe for concurrent use.
+type SearchCache struct {
+	inner   Searcher
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]searchCacheEntry
+}
+
+type searchCacheEntry struct {
+	results []SearchResult
+	expires time.Time
+}
+
+// NewCachedSearcher returns a Searcher that caches inner's results for ttl
+func NewCachedSearcher(inner Searcher, ttl time.Duration) *SearchCache {
+	return &SearchCache{
+		inner:   inner,
+		ttl:     ttl,
+		entries: make(map[string]searchCacheEntry),
+	}
+}
+
+// Search implements Searcher, serving cached results when still fresh
+func (c *SearchCache) Search(ctx context.Context, query string) ([]SearchResult, error) {
+	key := normalizeQuery(query)
+
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	if ok && time.Now().Before(entry.expires) {
+		c.mu.Unlock()
+		return append([]SearchResult(nil), entry.results...), nil
+	}
+	if ok {
+		delete(c.entries, key)
+	}
+	c.mu.Unlock()
+
+	results, err := c.inner.Search(ctx, query)
+	if err != nil {
+		// Errors are not cached so a transient failure can be retried
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.entries[key] = searchCacheEntry{
+		results: append([]SearchResult(nil), results...),
+		expires: time.Now().Add(c.ttl),
+	}
+	c.mu.Unlock()
+
+	return results, nil
+}
+
+// Purge drops all cached entries
+func (c *SearchCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]searchCacheEntry)
+}
+
+// normalizeQuery lowercases and collapses whitespace so trivially different
+// spellings of the same query share a cache entry
+func normalizeQuery(query string) string {
+	return strings.Join(strings.Fields(strings.ToLower(query)), " ")
+}
+
+// AnswerCache stores answers by question. Implementations decide how
+// questions are normalized into keys.
+type AnswerCache interface {
+	Get(question string) (string, bool)
+	Set(question, answer string)
+}
+
+// LRUAnswerCache is an in-memory AnswerCache holding the most recently
+// used answers. Questions are matched case-insensitively, ignoring
+// surrounding and repeated whitespace. It is safe for concurrent use.
+type LRUAnswerCache struct {
+	size    int
+	mu      sync.Mutex
+	order   *list.List // front is most recently used
+	entries map[string]*list.Element
+}
+
+type answerCacheEntry struct {
+	key    string
+	answer string
+}
+
+// NewLRUAnswerCache returns an LRUAnswerCache that keeps up to size
+// answers, evicting the least recently used beyond that. A size below 1
+// is treated as 1.
+func NewLRUAnswerCache(size int) *LRUAnswerCache {
+	return &LRUAnswerCache{
+		size:    max(size, 1),
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// Get implements AnswerCache
+func (c *LRUAnswerCache) Get(question string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[normalizeQuery(question)]
+	if !ok {
+		return "", false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*answerCacheEntry).answer, true
+}
+
+// Set implements AnswerCache
+func (c *LRUAnswerCache) Set(question, answer string) {
+	key := normalizeQuery(question)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		elem.Value.(*answerCacheEntry).answer = answer
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&answerCacheEntry{key: key, answer: answer})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*answerCacheEntry).key)
+	}
+}
+
+// Len returns the number of cached answers
+func (c *LRUAnswerCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
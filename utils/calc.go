@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/parser"
+	"go/token"
+	"math"
+)
+
+// EvaluateExpression computes an arithmetic expression such as
+// "(2 + 3) * 4.5 / 2" using +, -, *, / and parentheses. Integer division
+// is exact, so "7 / 2" is 3.5.
+func EvaluateExpression(expr string) (float64, error) {
+	node, err := parser.ParseExpr(expr)
+	if err != nil {
+		return 0, fmt.Errorf("invalid expression %q: %w", expr, err)
+	}
+
+	value, err := evalNode(node)
+	if err != nil {
+		return 0, fmt.Errorf("invalid expression %q: %w", expr, err)
+	}
+
+	result, _ := constant.Float64Val(constant.ToFloat(value))
+	if math.IsInf(result, 0) {
+		return 0, fmt.Errorf("invalid expression %q: result is out of range", expr)
+	}
+	return result, nil
+}
+
+// known rejects the unknown value go/constant returns for numbers it can't
+// represent, such as exponents too large to compute exactly
+func known(value constant.Value) (constant.Value, error) {
+	if value.Kind() == constant.Unknown {
+		return nil, fmt.Errorf("number out of range")
+	}
+	return value, nil
+}
+
+// evalNode evaluates a parsed arithmetic expression with exact constants
+func evalNode(node ast.Expr) (constant.Value, error) {
+	switch n := node.(type) {
+	case *ast.BasicLit:
+		if n.Kind != token.INT && n.Kind != token.FLOAT {
+			return nil, fmt.Errorf("unsupported literal %s", n.Value)
+		}
+		return known(constant.MakeFromLiteral(n.Value, n.Kind, 0))
+
+	case *ast.ParenExpr:
+		return evalNode(n.X)
+
+	case *ast.UnaryExpr:
+		if n.Op != token.ADD && n.Op != token.SUB {
+			return nil, fmt.Errorf("unsupported operator %s", n.Op)
+		}
+		x, err := evalNode(n.X)
+		if err != nil {
+			return nil, err
+		}
+		return known(constant.UnaryOp(n.Op, x, 0))
+
+	case *ast.BinaryExpr:
+		x, err := evalNode(n.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := evalNode(n.Y)
+		if err != nil {
+			return nil, err
+		}
+		switch n.Op {
+		case token.ADD, token.SUB, token.MUL:
+			return known(constant.BinaryOp(x, n.Op, y))
+		case token.QUO:
+			if constant.Sign(y) == 0 {
+				return nil, fmt.Errorf("division by zero")
+			}
+			// Convert first so integer operands don't truncate
+			return known(constant.BinaryOp(constant.ToFloat(x), token.QUO, constant.ToFloat(y)))
+		default:
+			return nil, fmt.Errorf("unsupported operator %s", n.Op)
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported syntax")
+	}
+}
@@ -0,0 +1,52 @@
+package utils
+
+import "testing"
+
+func TestEvaluateExpression(t *testing.T) {
+	tests := []struct {
+		expr string
+		want float64
+	}{
+		{expr: "(2 + 3) * 4.5 / 2", want: 11.25},
+		{expr: "7 / 2", want: 3.5},
+		{expr: "-(1 - 4)", want: 3},
+		{expr: "1e300 * 1e-300", want: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			got, err := EvaluateExpression(tt.expr)
+			if err != nil {
+				t.Fatalf("EvaluateExpression(%q) error = %v", tt.expr, err)
+			}
+			if got != tt.want {
+				t.Errorf("EvaluateExpression(%q) = %v, want %v", tt.expr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateExpressionErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+	}{
+		{name: "exponent too large", expr: "0.1e999999999"},
+		{name: "overflows float64", expr: "1e400"},
+		{name: "negative overflow", expr: "-1e400"},
+		{name: "product overflows", expr: "1e200 * 1e200"},
+		{name: "division by zero", expr: "1 / (2 - 2)"},
+		{name: "string literal", expr: `"1" + 2`},
+		{name: "function call", expr: "max(1, 2)"},
+		{name: "syntax error", expr: "2 +"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := EvaluateExpression(tt.expr)
+			if err == nil {
+				t.Errorf("EvaluateExpression(%q) = %v, want an error", tt.expr, got)
+			}
+		})
+	}
+}
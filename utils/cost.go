@@ -0,0 +1,146 @@
+package utils
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// ModelPrice is what a model costs in US dollars per 1,000 tokens
+type ModelPrice struct {
+	Prompt     float64 `json:"prompt"`
+	Completion float64 `json:"completion"`
+}
+
+// ModelPrices is the price table EstimateCost uses, keyed by model name or
+// name prefix, so "gpt-4o-2024-08-06" is priced as "gpt-4o". Prices change;
+// replace or extend entries to match your contract, e.g.
+//
+//	utils.ModelPrices["my-finetune"] = utils.ModelPrice{Prompt: 0.003, Completion: 0.012}
+var ModelPrices = map[string]ModelPrice{
+	"gpt-4o":            {Prompt: 0.0025, Completion: 0.01},
+	"gpt-4o-mini":       {Prompt: 0.00015, Completion: 0.0006},
+	"gpt-4.1":           {Prompt: 0.002, Completion: 0.008},
+	"gpt-4.1-mini":      {Prompt: 0.0004, Completion: 0.0016},
+	"gpt-4.1-nano":      {Prompt: 0.0001, Completion: 0.0004},
+	"gpt-4-turbo":       {Prompt: 0.01, Completion: 0.03},
+	"gpt-4":             {Prompt: 0.03, Completion: 0.06},
+	"gpt-3.5-turbo":     {Prompt: 0.0005, Completion: 0.0015},
+	"claude-3-5-sonnet": {Prompt: 0.003, Completion: 0.015},
+	"claude-3-5-haiku":  {Prompt: 0.0008, Completion: 0.004},
+	"claude-3-opus":     {Prompt: 0.015, Completion: 0.075},
+	"claude-3-haiku":    {Prompt: 0.00025, Completion: 0.00125},
+}
+
+// warnedModels remembers models already reported as unpriced
+var warnedModels sync.Map
+
+// LookupModelPrice returns model's entry in ModelPrices, matching the
+// longest name prefix, and whether there was one
+func LookupModelPrice(model string) (ModelPrice, bool) {
+	if price, ok := ModelPrices[model]; ok {
+		return price, true
+	}
+	best := ""
+	for name := range ModelPrices {
+		if strings.HasPrefix(model, name) && len(name) > len(best) {
+			best = name
+		}
+	}
+	if best == "" {
+		return ModelPrice{}, false
+	}
+	return ModelPrices[best], true
+}
+
+// EstimateCost returns the dollar cost of usage on model from ModelPrices.
+// A model with no price costs 0 and is logged as a warning, once per model;
+// use LookupModelPrice to tell that apart from a free call.
+func EstimateCost(model string, usage Usage) float64 {
+	price, ok := LookupModelPrice(model)
+	if !ok {
+		if _, warned := warnedModels.LoadOrStore(model, true); !warned {
+			slog.Warn("No price for model, counting its cost as $0", "model", model)
+		}
+		return 0
+	}
+	return float64(usage.PromptTokens)/1000*price.Prompt +
+		float64(usage.CompletionTokens)/1000*price.Completion
+}
+
+// UsageTracker adds up the token usage of every LLM call in a run, per
+// model. Cached replies and streamed answers report no usage. A nil
+// *UsageTracker records nothing. It is safe for concurrent use.
+type UsageTracker struct {
+	mu      sync.Mutex
+	byModel map[string]Usage
+	calls   int
+}
+
+// NewUsageTracker returns an empty tracker
+func NewUsageTracker() *UsageTracker {
+	return &UsageTracker{byModel: make(map[string]Usage)}
+}
+
+// Add records one call's usage on model
+func (t *UsageTracker) Add(model string, usage Usage) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	total := t.byModel[model]
+	total.PromptTokens += usage.PromptTokens
+	total.CompletionTokens += usage.CompletionTokens
+	total.TotalTokens += usage.TotalTokens
+	t.byModel[model] = total
+	t.calls++
+}
+
+// ByModel returns the usage so far, summed per model
+func (t *UsageTracker) ByModel() map[string]Usage {
+	if t == nil {
+		return map[string]Usage{}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	totals := make(map[string]Usage, len(t.byModel))
+	for model, usage := range t.byModel {
+		totals[model] = usage
+	}
+	return totals
+}
+
+// Calls returns how many calls have been recorded
+func (t *UsageTracker) Calls() int {
+	if t == nil {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.calls
+}
+
+// usageTrackerKey is the context key for a run's UsageTracker
+type usageTrackerKey struct{}
+
+// WithUsageTracker returns a context whose LLM calls record their usage
+// in tracker
+func WithUsageTracker(ctx context.Context, tracker *UsageTracker) context.Context {
+	return context.WithValue(ctx, usageTrackerKey{}, tracker)
+}
+
+// UsageTrackerFromContext returns the UsageTracker set by
+// WithUsageTracker, or nil when there is none
+func UsageTrackerFromContext(ctx context.Context) *UsageTracker {
+	tracker, _ := ctx.Value(usageTrackerKey{}).(*UsageTracker)
+	return tracker
+}
+
+// recordUsage adds a completed call's usage to the run's tracker, if any
+func recordUsage(ctx context.Context, model string, usage Usage) {
+	UsageTrackerFromContext(ctx).Add(model, usage)
+}
@@ -0,0 +1,135 @@
+package utils
+
+import "strings"
+
+// NoDifferences is what DiffText returns for inputs that match
+const NoDifferences = "no differences"
+
+// diffOp is one step of an edit script: kept, deleted from a, or added
+// from b
+type diffOp struct {
+	kind byte // ' ', '-', or '+'
+	text string
+}
+
+// DiffText compares a and b and returns a human-readable diff, or
+// NoDifferences when they're identical. When either spans several lines
+// it is a line diff, with each line prefixed by "- " (only in a), "+ "
+// (only in b), or "  " (in both). Single lines get a word diff instead,
+// marking deletions as [-words-] and additions as {+words+}. Both use the
+// longest common subsequence, so unchanged text is kept in place.
+func DiffText(a, b string) string {
+	if a == b {
+		return NoDifferences
+	}
+
+	a = strings.ReplaceAll(a, "\r\n", "\n")
+	b = strings.ReplaceAll(b, "\r\n", "\n")
+	if strings.Contains(a, "\n") || strings.Contains(b, "\n") {
+		return diffLines(a, b)
+	}
+	return diffWords(a, b)
+}
+
+// diffLines formats a line diff of a and b
+func diffLines(a, b string) string {
+	ops := diffSequences(strings.Split(a, "\n"), strings.Split(b, "\n"))
+
+	var out strings.Builder
+	for _, op := range ops {
+		out.WriteByte(op.kind)
+		out.WriteByte(' ')
+		out.WriteString(op.text)
+		out.WriteByte('\n')
+	}
+	return strings.TrimSuffix(out.String(), "\n")
+}
+
+// diffWords formats a word diff of a and b, grouping neighbouring changes
+func diffWords(a, b string) string {
+	ops := diffSequences(strings.Fields(a), strings.Fields(b))
+	if len(ops) == 0 || !hasChanges(ops) {
+		// Only the spacing differs
+		return NoDifferences
+	}
+
+	var parts []string
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			parts = append(parts, ops[i].text)
+			i++
+			continue
+		}
+
+		// Collect a run of changes, deletions before additions
+		var deleted, added []string
+		for ; i < len(ops) && ops[i].kind != ' '; i++ {
+			if ops[i].kind == '-' {
+				deleted = append(deleted, ops[i].text)
+			} else {
+				added = append(added, ops[i].text)
+			}
+		}
+		if len(deleted) > 0 {
+			parts = append(parts, "[-"+strings.Join(deleted, " ")+"-]")
+		}
+		if len(added) > 0 {
+			parts = append(parts, "{+"+strings.Join(added, " ")+"+}")
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// hasChanges reports whether ops deletes or adds anything
+func hasChanges(ops []diffOp) bool {
+	for _, op := range ops {
+		if op.kind != ' ' {
+			return true
+		}
+	}
+	return false
+}
+
+// diffSequences returns the edit script turning a into b, built from a
+// longest common subsequence table. Deletions come before additions
+// where both are possible.
+func diffSequences(a, b []string) []diffOp {
+	// lcs[i][j] is the LCS length of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	ops := make([]diffOp, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		ops = append(ops, diffOp{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, diffOp{'+', b[j]})
+	}
+	return ops
+}
@@ -0,0 +1,199 @@
+package utils
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EmbeddingCacheDir overrides where cached embeddings are stored. When
+// empty, FLYT_EMBEDDING_CACHE_DIR is used, then ~/.flyt-cache/embeddings.
+var EmbeddingCacheDir string
+
+// EmbeddingCacheMaxEntries caps how many vectors the embedding cache keeps.
+// When a write takes it over the cap, the least recently used entries are
+// removed. Zero or less disables eviction.
+var EmbeddingCacheMaxEntries = 10000
+
+// embeddingCacheExt is the extension of cached vector files
+const embeddingCacheExt = ".f32"
+
+// embeddingCacheMu serializes eviction, so concurrent writers don't race
+// to remove the same files
+var embeddingCacheMu sync.Mutex
+
+// embeddingCacheDir resolves the cache directory
+func embeddingCacheDir() (string, error) {
+	if EmbeddingCacheDir != "" {
+		return EmbeddingCacheDir, nil
+	}
+	if dir := os.Getenv("FLYT_EMBEDDING_CACHE_DIR"); dir != "" {
+		return dir, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find home directory for embedding cache: %w", err)
+	}
+	return filepath.Join(home, ".flyt-cache", "embeddings"), nil
+}
+
+// GetEmbeddingCached is GetEmbedding for a given model with an on-disk
+// cache: a vector already stored for the same text and model is returned
+// without calling the API. An empty model uses EmbeddingModel.
+func GetEmbeddingCached(ctx context.Context, text, model string) ([]float32, error) {
+	embeddings, err := GetEmbeddingsCached(ctx, []string{text}, model)
+	if err != nil {
+		return nil, err
+	}
+	return embeddings[0], nil
+}
+
+// GetEmbeddingsCached is GetEmbeddingCached for many texts. Only the texts
+// missing from the cache are sent to the API, in as few requests as it
+// allows, and their vectors are written through. Cache I/O problems are
+// logged, never fatal.
+func GetEmbeddingsCached(ctx context.Context, texts []string, model string) ([][]float32, error) {
+	model = cmp.Or(model, EmbeddingModel)
+
+	dir, err := embeddingCacheDir()
+	if err != nil {
+		slog.Warn("Embedding cache disabled", "error", err)
+		return getEmbeddings(ctx, texts, model)
+	}
+
+	embeddings := make([][]float32, len(texts))
+	var missing []string
+	var missingIndexes []int
+	for i, text := range texts {
+		path := filepath.Join(dir, embeddingCacheKey(text, model)+embeddingCacheExt)
+		embedding, err := readEmbedding(path)
+		switch {
+		case err == nil:
+			embeddings[i] = embedding
+			// Mark the entry as recently used for eviction
+			now := time.Now()
+			os.Chtimes(path, now, now)
+			continue
+		case errors.Is(err, os.ErrNotExist):
+		default:
+			slog.Warn("Ignoring unreadable embedding cache entry", "path", path, "error", err)
+		}
+		missing = append(missing, text)
+		missingIndexes = append(missingIndexes, i)
+	}
+
+	slog.Debug("Embedding cache lookup", "hits", len(texts)-len(missing), "misses", len(missing))
+	if len(missing) == 0 {
+		return embeddings, nil
+	}
+
+	fetched, err := getEmbeddings(ctx, missing, model)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, embedding := range fetched {
+		embeddings[missingIndexes[i]] = embedding
+		path := filepath.Join(dir, embeddingCacheKey(missing[i], model)+embeddingCacheExt)
+		if err := writeCacheFile(dir, path, encodeEmbedding(embedding)); err != nil {
+			slog.Warn("Failed to write embedding cache", "path", path, "error", err)
+		}
+	}
+
+	if err := evictEmbeddings(dir, EmbeddingCacheMaxEntries); err != nil {
+		slog.Warn("Failed to evict embedding cache entries", "dir", dir, "error", err)
+	}
+	return embeddings, nil
+}
+
+// embeddingCacheKey hashes the model and text, which together determine
+// the vector
+func embeddingCacheKey(text, model string) string {
+	sum := sha256.Sum256([]byte(model + "\x00" + text))
+	return hex.EncodeToString(sum[:])
+}
+
+// encodeEmbedding packs a vector as little-endian float32s
+func encodeEmbedding(embedding []float32) []byte {
+	data := make([]byte, 4*len(embedding))
+	for i, value := range embedding {
+		binary.LittleEndian.PutUint32(data[4*i:], math.Float32bits(value))
+	}
+	return data
+}
+
+// readEmbedding loads a vector written by encodeEmbedding
+func readEmbedding(path string) ([]float32, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 || len(data)%4 != 0 {
+		return nil, fmt.Errorf("corrupt entry of %d bytes", len(data))
+	}
+
+	embedding := make([]float32, len(data)/4)
+	for i := range embedding {
+		embedding[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[4*i:]))
+	}
+	return embedding, nil
+}
+
+// evictEmbeddings removes the least recently used vectors in dir until at
+// most maxEntries remain
+func evictEmbeddings(dir string, maxEntries int) error {
+	if maxEntries <= 0 {
+		return nil
+	}
+
+	embeddingCacheMu.Lock()
+	defer embeddingCacheMu.Unlock()
+
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	type cacheFile struct {
+		path    string
+		modTime time.Time
+	}
+	var files []cacheFile
+	for _, entry := range dirEntries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), embeddingCacheExt) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			// Removed by another process since the listing
+			continue
+		}
+		files = append(files, cacheFile{filepath.Join(dir, entry.Name()), info.ModTime()})
+	}
+	if len(files) <= maxEntries {
+		return nil
+	}
+
+	slices.SortFunc(files, func(a, b cacheFile) int {
+		return a.modTime.Compare(b.modTime)
+	})
+	for _, file := range files[:len(files)-maxEntries] {
+		if err := os.Remove(file.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	slog.Debug("Evicted embedding cache entries", "removed", len(files)-maxEntries)
+	return nil
+}
@@ -0,0 +1,164 @@
+package utils
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Entities are the structured facts extracted from free text
+type Entities struct {
+	Names  []string `json:"names"`
+	Dates  []string `json:"dates"`
+	Emails []string `json:"emails"`
+	URLs   []string `json:"urls"`
+}
+
+const monthPattern = `(?:jan(?:uary)?|feb(?:ruary)?|mar(?:ch)?|apr(?:il)?|may|june?|july?|aug(?:ust)?|sep(?:t(?:ember)?)?|oct(?:ober)?|nov(?:ember)?|dec(?:ember)?)`
+
+var (
+	emailRe = regexp.MustCompile(`(?i)\b[a-z0-9._%+-]+@[a-z0-9-]+(?:\.[a-z0-9-]+)*\.[a-z]{2,}\b`)
+	urlRe   = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>"'` + "`" + `]+`)
+
+	// dateRes match ISO, numeric, and written dates, longest forms first
+	dateRes = []*regexp.Regexp{
+		regexp.MustCompile(`\b\d{4}-\d{2}-\d{2}\b`),
+		regexp.MustCompile(`\b\d{1,2}[/.]\d{1,2}[/.]\d{2,4}\b`),
+		regexp.MustCompile(`(?i)\b` + monthPattern + `\.?\s+\d{1,2}(?:st|nd|rd|th)?(?:,?\s+\d{4})?\b`),
+		regexp.MustCompile(`(?i)\b\d{1,2}(?:st|nd|rd|th)?\s+(?:of\s+)?` + monthPattern + `\.?(?:,?\s+\d{4})?\b`),
+	}
+)
+
+// ExtractEmails returns the distinct email addresses in text, lowercased,
+// in order of first appearance. Addresses inside URLs are skipped.
+func ExtractEmails(text string) []string {
+	urls := urlRe.FindAllStringIndex(text, -1)
+
+	var spans [][]int
+	for _, span := range emailRe.FindAllStringIndex(text, -1) {
+		if !overlapsAny(span, urls) {
+			spans = append(spans, span)
+		}
+	}
+	return dedupeSpans(text, spans, strings.ToLower)
+}
+
+// ExtractURLs returns the distinct http(s) and www. URLs in text in order
+// of first appearance, without trailing punctuation
+func ExtractURLs(text string) []string {
+	spans := urlRe.FindAllStringIndex(text, -1)
+	for _, span := range spans {
+		span[1] = span[0] + len(trimURL(text[span[0]:span[1]]))
+	}
+	return dedupeSpans(text, spans, nil)
+}
+
+// ExtractDates returns the distinct dates written in text in order of
+// appearance: ISO (2024-01-15), numeric (15/01/2024), and written forms
+// (January 15, 2024 or 15th of Jan). Where patterns overlap, the longest
+// match wins. Dates are returned as written, not normalized.
+func ExtractDates(text string) []string {
+	var spans [][]int
+	for _, re := range dateRes {
+		spans = append(spans, re.FindAllStringIndex(text, -1)...)
+	}
+
+	// Keep the longest of any overlapping matches
+	sort.SliceStable(spans, func(i, j int) bool {
+		if spans[i][0] != spans[j][0] {
+			return spans[i][0] < spans[j][0]
+		}
+		return spans[i][1] > spans[j][1]
+	})
+	var kept [][]int
+	for _, span := range spans {
+		if last := len(kept) - 1; last >= 0 && span[0] < kept[last][1] {
+			if span[1]-span[0] > kept[last][1]-kept[last][0] {
+				kept[last] = span
+			}
+			continue
+		}
+		kept = append(kept, span)
+	}
+
+	return dedupeSpans(text, kept, nil)
+}
+
+// ExtractEntitiesRegex extracts emails, URLs, and dates with the regex
+// helpers. It can't recognize names, so Names is always empty.
+func ExtractEntitiesRegex(text string) Entities {
+	return Entities{
+		Names:  []string{},
+		Dates:  ExtractDates(text),
+		Emails: ExtractEmails(text),
+		URLs:   ExtractURLs(text),
+	}
+}
+
+// MergeEntities combines a and b, deduplicating each list case-insensitively
+// and keeping a's entries first
+func MergeEntities(a, b Entities) Entities {
+	return Entities{
+		Names:  dedupeFold(append(append([]string{}, a.Names...), b.Names...)),
+		Dates:  dedupeFold(append(append([]string{}, a.Dates...), b.Dates...)),
+		Emails: dedupeFold(append(append([]string{}, a.Emails...), b.Emails...)),
+		URLs:   dedupeFold(append(append([]string{}, a.URLs...), b.URLs...)),
+	}
+}
+
+// trimURL drops punctuation that ends a sentence rather than the URL,
+// keeping a closing parenthesis that balances one in the URL
+func trimURL(u string) string {
+	for len(u) > 0 {
+		last := u[len(u)-1]
+		switch {
+		case strings.IndexByte(".,;:!?'\"]", last) >= 0:
+			u = u[:len(u)-1]
+		case last == ')' && strings.Count(u, "(") < strings.Count(u, ")"):
+			u = u[:len(u)-1]
+		default:
+			return u
+		}
+	}
+	return u
+}
+
+// overlapsAny reports whether span overlaps any of spans
+func overlapsAny(span []int, spans [][]int) bool {
+	for _, other := range spans {
+		if span[0] < other[1] && other[0] < span[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// dedupeSpans returns the text of each span, optionally transformed, with
+// duplicates removed
+func dedupeSpans(text string, spans [][]int, transform func(string) string) []string {
+	values := make([]string, 0, len(spans))
+	for _, span := range spans {
+		value := text[span[0]:span[1]]
+		if transform != nil {
+			value = transform(value)
+		}
+		values = append(values, value)
+	}
+	return dedupeFold(values)
+}
+
+// dedupeFold drops blank values and case-insensitive repeats, keeping order
+func dedupeFold(values []string) []string {
+	seen := map[string]bool{}
+	result := []string{}
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		key := strings.ToLower(value)
+		if value == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		result = append(result, value)
+	}
+	return result
+}
@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+)
+
+// UserAgent is sent with every request made through HTTPClient unless
+// the client or request sets its own. Override it to identify your
+// application to the sites you fetch.
+var UserAgent = "Mozilla/5.0 (compatible; flyt-project-template)"
+
+// Page fetch timeout per attempt, and retries for transient failures
+var (
+	FetchTimeout    = 15 * time.Second
+	FetchMaxRetries = 2
+)
+
+const (
+	// maxPageBytes caps how much of a page body is read into memory
+	maxPageBytes = 1 << 20
+
+	// maxPageRedirects bounds the redirect chain followed by FetchPageText
+	maxPageRedirects = 5
+)
+
+// FetchPageText downloads an HTML page and returns its visible text.
+// Transient failures are retried, redirects are followed up to a small
+// limit, bodies larger than 1MB are truncated, and non-200 statuses or
+// non-HTML content types are errors.
+func FetchPageText(ctx context.Context, pageURL string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", pageURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+
+	client := NewHTTPClient(FetchTimeout, FetchMaxRetries)
+	client.MaxRedirects = maxPageRedirects
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch page: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("fetch %s failed with status %d", pageURL, resp.StatusCode)
+	}
+
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || (mediaType != "text/html" && mediaType != "application/xhtml+xml") {
+		return "", fmt.Errorf("unsupported content type %q for %s", resp.Header.Get("Content-Type"), pageURL)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxPageBytes))
+	if err != nil {
+		return "", fmt.Errorf("failed to read page: %w", err)
+	}
+
+	return StripHTML(string(body)), nil
+}
@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+)
+
+// GoogleCSESearcher searches using the Google Custom Search JSON API
+type GoogleCSESearcher struct {
+	// APIKey overrides the GOOGLE_API_KEY environment variable when set
+	APIKey string
+	// CX overrides the GOOGLE_CSE_ID environment variable when set
+	CX string
+
+	// Options controls result count and paging
+	Options SearchOptions
+}
+
+// googleMaxNum is the largest page size the Custom Search API accepts
+const googleMaxNum = 10
+
+// Search implements Searcher
+func (s *GoogleCSESearcher) Search(ctx context.Context, query string) ([]SearchResult, error) {
+	apiKey := s.APIKey
+	if apiKey == "" {
+		apiKey = os.Getenv("GOOGLE_API_KEY")
+	}
+	cx := s.CX
+	if cx == "" {
+		cx = os.Getenv("GOOGLE_CSE_ID")
+	}
+	if apiKey == "" || cx == "" {
+		return nil, fmt.Errorf("GOOGLE_API_KEY and GOOGLE_CSE_ID environment variables must be set")
+	}
+
+	if err := s.Options.validate(); err != nil {
+		return nil, err
+	}
+
+	params := url.Values{
+		"key": {apiKey},
+		"cx":  {cx},
+		"q":   {query},
+	}
+
+	// Custom Search pages natively via a 1-based start index
+	if s.Options.Offset > 0 {
+		params.Set("start", strconv.Itoa(s.Options.Offset+1))
+	}
+	if s.Options.Limit > 0 {
+		params.Set("num", strconv.Itoa(min(s.Options.Limit, googleMaxNum)))
+	}
+	apiURL := "https://www.googleapis.com/customsearch/v1?" + params.Encode()
+
+	body, err := searchGet(ctx, query, apiURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse Google response
+	var googleResponse struct {
+		Items []struct {
+			Title   string `json:"title"`
+			Link    string `json:"link"`
+			Snippet string `json:"snippet"`
+		} `json:"items"`
+	}
+
+	if err := json.Unmarshal(body, &googleResponse); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	var results []SearchResult
+	for _, item := range googleResponse.Items {
+		results = append(results, SearchResult{
+			Title:       item.Title,
+			URL:         item.Link,
+			Snippet:     item.Snippet,
+			Description: item.Snippet,
+		})
+	}
+
+	return results, nil
+}
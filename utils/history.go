@@ -0,0 +1,51 @@
+package utils
+
+// TrimHistory drops the oldest turns of a conversation until its
+// estimated size, the sum of CountTokens over message contents, is at most
+// maxTokens. A turn is a user message with the replies and tool messages
+// that follow it, so tool results never lose their call. System messages
+// and the latest user turn are always kept, even when they alone exceed
+// maxTokens. A maxTokens of zero or less keeps everything. The result is
+// a new slice; messages is not modified.
+func TrimHistory(messages []Message, maxTokens int) []Message {
+	total := 0
+	for _, m := range messages {
+		total += CountTokens(m.Content)
+	}
+	if maxTokens <= 0 || total <= maxTokens {
+		return append([]Message(nil), messages...)
+	}
+
+	latest := len(messages)
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "user" {
+			latest = i
+			break
+		}
+	}
+
+	// Drop whole turns from the front until the rest fits. Messages before
+	// the first user message count as a turn of their own.
+	dropped := make([]bool, len(messages))
+	for start := 0; start < latest && total > maxTokens; {
+		end := start + 1
+		for end < latest && messages[end].Role != "user" {
+			end++
+		}
+		for i := start; i < end; i++ {
+			if messages[i].Role != "system" {
+				dropped[i] = true
+				total -= CountTokens(messages[i].Content)
+			}
+		}
+		start = end
+	}
+
+	trimmed := make([]Message, 0, len(messages))
+	for i, m := range messages {
+		if !dropped[i] {
+			trimmed = append(trimmed, m)
+		}
+	}
+	return trimmed
+}
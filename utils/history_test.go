@@ -0,0 +1,118 @@
+package utils
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// historyTokens is the size TrimHistory measures messages by
+func historyTokens(messages ...Message) int {
+	total := 0
+	for _, m := range messages {
+		total += CountTokens(m.Content)
+	}
+	return total
+}
+
+func TestTrimHistory(t *testing.T) {
+	system := Message{Role: "system", Content: "You are a helpful assistant."}
+	bigSystem := Message{Role: "system", Content: strings.Repeat("Follow these rules carefully. ", 50)}
+	user1 := Message{Role: "user", Content: "What is the weather in Paris today?"}
+	call := Message{Role: "assistant", ToolCalls: []ToolCall{{ID: "call_1"}}}
+	result := Message{Role: "tool", Content: "Sunny and 24 degrees in Paris.", ToolCallID: "call_1"}
+	answer1 := Message{Role: "assistant", Content: "It is sunny and 24 degrees in Paris."}
+	user2 := Message{Role: "user", Content: "And in London?"}
+	answer2 := Message{Role: "assistant", Content: "London is cloudy with light rain."}
+	user3 := Message{Role: "user", Content: "Which city is warmer?"}
+
+	conversation := []Message{system, user1, call, result, answer1, user2, answer2, user3}
+
+	tests := []struct {
+		name      string
+		messages  []Message
+		maxTokens int
+		want      []Message
+	}{
+		{
+			name:      "exactly at the budget",
+			messages:  conversation,
+			maxTokens: historyTokens(conversation...),
+			want:      conversation,
+		},
+		{
+			name:      "no budget",
+			messages:  conversation,
+			maxTokens: 0,
+			want:      conversation,
+		},
+		{
+			name:      "one token over drops the oldest turn with its tool messages",
+			messages:  conversation,
+			maxTokens: historyTokens(conversation...) - 1,
+			want:      []Message{system, user2, answer2, user3},
+		},
+		{
+			name:      "older turn kept with its tool messages",
+			messages:  []Message{system, user2, answer2, user1, call, result, answer1, user3},
+			maxTokens: historyTokens(system, user1, call, result, answer1, user3),
+			want:      []Message{system, user1, call, result, answer1, user3},
+		},
+		{
+			name:      "only the latest user turn kept",
+			messages:  conversation,
+			maxTokens: historyTokens(system, user3),
+			want:      []Message{system, user3},
+		},
+		{
+			name:      "latest user turn kept over the budget",
+			messages:  conversation,
+			maxTokens: 1,
+			want:      []Message{system, user3},
+		},
+		{
+			name:      "system message alone over the budget",
+			messages:  []Message{bigSystem, user1, answer1, user3},
+			maxTokens: historyTokens(bigSystem) - 1,
+			want:      []Message{bigSystem, user3},
+		},
+		{
+			name:      "latest turn keeps its replies",
+			messages:  []Message{user1, answer1, user3, call, result},
+			maxTokens: historyTokens(user3, call, result),
+			want:      []Message{user3, call, result},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := TrimHistory(tt.messages, tt.maxTokens)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("TrimHistory() =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrimHistoryDoesNotModifyInput(t *testing.T) {
+	messages := []Message{
+		{Role: "system", Content: "You are a helpful assistant."},
+		{Role: "user", Content: "What is the capital of France?"},
+		{Role: "assistant", Content: "Paris is the capital of France."},
+		{Role: "user", Content: "And of Spain?"},
+	}
+	original := append([]Message(nil), messages...)
+
+	for _, maxTokens := range []int{0, 1, historyTokens(messages...)} {
+		trimmed := TrimHistory(messages, maxTokens)
+		if !reflect.DeepEqual(messages, original) {
+			t.Fatalf("TrimHistory(%d) modified its input: %+v", maxTokens, messages)
+		}
+
+		// The result is a copy, so changing it leaves the input alone
+		trimmed[0].Content = "changed"
+		if messages[0].Content != original[0].Content {
+			t.Fatalf("TrimHistory(%d) returned the input slice", maxTokens)
+		}
+	}
+}
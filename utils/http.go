@@ -0,0 +1,314 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// HTTPTransport, when set, sends every request made through an HTTPClient
+// that has no Transport of its own. Tests can inject a stub RoundTripper
+// here to exercise the LLM, search, and page fetch code offline, or point
+// OpenAIBaseURL and DuckDuckGoAPIURL at an httptest.Server instead:
+//
+//	srv := httptest.NewServer(handler)
+//	defer srv.Close()
+//	utils.OpenAIBaseURL = srv.URL
+var HTTPTransport http.RoundTripper
+
+// HTTPClient sends requests with a consistent timeout, User-Agent, and
+// retry policy. Network errors and transient statuses (429 and 5xx) are
+// retried with exponential backoff and jitter, or after the delay a
+// Retry-After header asks for.
+type HTTPClient struct {
+	// Timeout bounds each attempt, including reading the body; zero means
+	// no timeout beyond the request's context
+	Timeout time.Duration
+
+	// MaxRetries is how many times a failed attempt is retried
+	MaxRetries  int
+	BaseBackoff time.Duration
+
+	// UserAgent is sent unless the request sets one; empty uses UserAgent
+	UserAgent string
+
+	// MaxRedirects caps the redirects followed; zero uses Go's default of 10
+	MaxRedirects int
+
+	// Transport sends the requests; nil uses HTTPTransport, then
+	// http.DefaultTransport
+	Transport http.RoundTripper
+
+	// BeforeAttempt, when set, runs before every attempt including retries,
+	// e.g. to wait on a rate limiter. Its error aborts the request.
+	BeforeAttempt func(req *http.Request) error
+
+	// Latency, when set, records how long each attempt took to get a
+	// response. Attempts that time out count as taking their timeout, so
+	// adaptive timeouts grow again when an endpoint slows down for good;
+	// other failures aren't recorded.
+	Latency *LatencyTracker
+
+	// AdaptiveTimeout bounds each attempt by Latency's SuggestedTimeout
+	// instead of Timeout, or by the shorter of the two when both are set,
+	// so a degraded endpoint fails fast
+	AdaptiveTimeout bool
+
+	// RetryBudget, when set, must grant every retry on top of MaxRetries.
+	// When it's spent, a failed attempt ends the request with an error
+	// wrapping ErrRetryBudgetExhausted, for network errors and transient
+	// statuses alike.
+	RetryBudget *RetryBudget
+}
+
+// NewHTTPClient returns an HTTPClient with the given timeout and retry
+// count and a 500ms base backoff
+func NewHTTPClient(timeout time.Duration, maxRetries int) *HTTPClient {
+	return &HTTPClient{
+		Timeout:     timeout,
+		MaxRetries:  maxRetries,
+		BaseBackoff: 500 * time.Millisecond,
+	}
+}
+
+// MaxBackoff caps the wait before a retry, both the exponential backoff
+// and any longer delay a Retry-After header asks for
+const MaxBackoff = 30 * time.Second
+
+// Do sends req, retrying transient failures. The final response is
+// returned whatever its status, so callers check StatusCode as they would
+// with http.Client, except that a transient status still failing after
+// retries is a *RetryStatusError reporting the attempts made. Network
+// errors and cancellation are errors too.
+// A request body is resent on retries only if req.GetBody is set, which
+// http.NewRequest does for bytes and strings readers.
+func (c *HTTPClient) Do(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+
+	maxRetries := c.MaxRetries
+	if req.Body != nil && req.GetBody == nil {
+		maxRetries = 0
+	}
+
+	attempts := 0
+	for {
+		attempts++
+
+		attempt, err := c.prepareAttempt(req, attempts)
+		if err != nil {
+			return nil, err
+		}
+
+		var wait time.Duration
+		start := time.Now()
+		resp, err := c.httpClient().Do(attempt)
+		if c.Latency != nil && (err == nil || isTimeout(err)) && ctx.Err() == nil {
+			c.Latency.Observe(time.Since(start))
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, fmt.Errorf("request cancelled after %d attempt(s): %w", attempts, ctx.Err())
+			}
+			err = fmt.Errorf("failed to make request: %w", err)
+			if attempts > maxRetries {
+				if attempts > 1 {
+					return nil, fmt.Errorf("giving up after %d attempt(s): %w", attempts, err)
+				}
+				return nil, err
+			}
+			if !c.RetryBudget.Take() {
+				return nil, fmt.Errorf("%w after %d attempt(s): %w", ErrRetryBudgetExhausted, attempts, err)
+			}
+		} else {
+			if !retryableStatus(resp.StatusCode) || attempts > maxRetries && attempts == 1 {
+				return resp, nil
+			}
+			if attempts > maxRetries {
+				return nil, newRetryStatusError(resp, attempts)
+			}
+			if !c.RetryBudget.Take() {
+				slog.WarnContext(ctx, "retry budget exhausted, not retrying", "url", req.URL.Redacted(), "status", resp.StatusCode)
+				statusErr := newRetryStatusError(resp, attempts)
+				statusErr.BudgetExhausted = true
+				return nil, statusErr
+			}
+			wait, _ = retryAfter(resp.Header.Get("Retry-After"))
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+
+		if wait == 0 {
+			wait = backoffDelay(c.BaseBackoff, attempts-1)
+		}
+		slog.DebugContext(ctx, "retrying HTTP request", "url", req.URL.Redacted(), "attempt", attempts, "wait", wait,
+			"retry_budget_remaining", c.RetryBudget.Remaining())
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, fmt.Errorf("request cancelled after %d attempt(s): %w", attempts, ctx.Err())
+		}
+	}
+}
+
+// RetryStatusError is returned by HTTPClient.Do when a request still got a
+// transient status (429 or 5xx) on its last attempt
+type RetryStatusError struct {
+	StatusCode int
+	Attempts   int
+
+	// Body is the start of the final response body
+	Body []byte
+
+	// BudgetExhausted is set when the RetryBudget, not MaxRetries, stopped
+	// the retries; the error then wraps ErrRetryBudgetExhausted
+	BudgetExhausted bool
+}
+
+// newRetryStatusError drains and closes resp into a RetryStatusError
+func newRetryStatusError(resp *http.Response, attempts int) *RetryStatusError {
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return &RetryStatusError{StatusCode: resp.StatusCode, Attempts: attempts, Body: body}
+}
+
+// Error implements error
+func (e *RetryStatusError) Error() string {
+	reason := "giving up"
+	if e.BudgetExhausted {
+		reason = ErrRetryBudgetExhausted.Error()
+	}
+	return fmt.Sprintf("%s after %d attempt(s): request failed with status %d: %s",
+		reason, e.Attempts, e.StatusCode, bodySnippet(e.Body))
+}
+
+// Unwrap returns ErrRetryBudgetExhausted when the budget stopped the retries
+func (e *RetryStatusError) Unwrap() error {
+	if e.BudgetExhausted {
+		return ErrRetryBudgetExhausted
+	}
+	return nil
+}
+
+// prepareAttempt copies req for one attempt with a fresh body and the
+// User-Agent set, after running BeforeAttempt
+func (c *HTTPClient) prepareAttempt(req *http.Request, attempts int) (*http.Request, error) {
+	ctx := req.Context()
+
+	if c.BeforeAttempt != nil {
+		if err := c.BeforeAttempt(req); err != nil {
+			return nil, fmt.Errorf("request cancelled after %d attempt(s): %w", attempts-1, err)
+		}
+	}
+
+	attempt := req.Clone(ctx)
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, fmt.Errorf("failed to reset request body: %w", err)
+		}
+		attempt.Body = body
+	}
+
+	if attempt.Header.Get("User-Agent") == "" {
+		userAgent := c.UserAgent
+		if userAgent == "" {
+			userAgent = UserAgent
+		}
+		attempt.Header.Set("User-Agent", userAgent)
+	}
+
+	return attempt, nil
+}
+
+// httpClient builds the http.Client used for each attempt
+func (c *HTTPClient) httpClient() *http.Client {
+	transport := c.Transport
+	if transport == nil {
+		transport = HTTPTransport
+	}
+
+	client := &http.Client{
+		Timeout:   c.attemptTimeout(),
+		Transport: transport,
+	}
+	if c.MaxRedirects > 0 {
+		maxRedirects := c.MaxRedirects
+		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+			}
+			return nil
+		}
+	}
+	return client
+}
+
+// attemptTimeout is the timeout for the next attempt
+func (c *HTTPClient) attemptTimeout() time.Duration {
+	if !c.AdaptiveTimeout || c.Latency == nil {
+		return c.Timeout
+	}
+	suggested := c.Latency.SuggestedTimeout()
+	if c.Timeout > 0 && (suggested <= 0 || c.Timeout < suggested) {
+		return c.Timeout
+	}
+	return suggested
+}
+
+// isTimeout reports whether err is a network or client timeout
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// retryableStatus reports whether an HTTP status is worth retrying
+func retryableStatus(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryAfter parses a Retry-After header given in seconds or as an HTTP
+// date, capped at MaxBackoff
+func retryAfter(header string) (time.Duration, bool) {
+	if header == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		if seconds > int(MaxBackoff/time.Second) {
+			return MaxBackoff, true
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(header); err == nil {
+		return min(max(time.Until(t), 0), MaxBackoff), true
+	}
+	return 0, false
+}
+
+// backoffDelay returns the exponential backoff for an attempt with up to
+// 50% jitter, capped at MaxBackoff
+func backoffDelay(base time.Duration, attempt int) time.Duration {
+	if base <= 0 {
+		return 0
+	}
+	delay := MaxBackoff
+	// Shifting further would overflow; the cap applies long before that
+	if attempt < 32 && base < MaxBackoff>>attempt {
+		delay = base << attempt
+	}
+	return min(delay+time.Duration(rand.Int63n(int64(delay)/2+1)), MaxBackoff)
+}
@@ -0,0 +1,124 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// useHTTPTransport routes HTTPClient requests through a stub answering
+// every request with status, and returns the number of requests sent
+func useHTTPTransport(t *testing.T, status int) *atomic.Int32 {
+	t.Helper()
+	var requests atomic.Int32
+	previous := HTTPTransport
+	HTTPTransport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		requests.Add(1)
+		return &http.Response{
+			StatusCode: status,
+			Header:     http.Header{},
+			Body:       io.NopCloser(strings.NewReader(`{"error": "overloaded"}`)),
+			Request:    req,
+		}, nil
+	})
+	t.Cleanup(func() { HTTPTransport = previous })
+	return &requests
+}
+
+func TestPostJSONWithRetryReportsAttempts(t *testing.T) {
+	requests := useHTTPTransport(t, http.StatusServiceUnavailable)
+
+	config := DefaultLLMConfig()
+	config.MaxRetries = 2
+	config.BaseBackoff = time.Millisecond
+
+	_, err := postJSONWithRetry(context.Background(), "http://llm.test/chat/completions", nil, []byte(`{}`), config)
+	if err == nil {
+		t.Fatal("postJSONWithRetry() error = nil, want an error")
+	}
+	if !strings.Contains(err.Error(), "after 3 attempt(s)") || !strings.Contains(err.Error(), "503") {
+		t.Errorf("postJSONWithRetry() error = %v, want the status and \"after 3 attempt(s)\"", err)
+	}
+	var statusErr *RetryStatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("postJSONWithRetry() error = %v, want a RetryStatusError for 503", err)
+	}
+	if got := requests.Load(); got != 3 {
+		t.Errorf("sent %d requests, want 3", got)
+	}
+}
+
+func TestHTTPClientWithoutRetriesReturnsStatus(t *testing.T) {
+	useHTTPTransport(t, http.StatusServiceUnavailable)
+
+	req, err := http.NewRequest(http.MethodGet, "http://search.test/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := NewHTTPClient(time.Second, 0).Do(req)
+	if err != nil {
+		t.Fatalf("Do() error = %v, want the response", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Do() status = %d, want 503", resp.StatusCode)
+	}
+}
+
+func TestPostJSONWithRetryBudgetExhausted(t *testing.T) {
+	requests := useHTTPTransport(t, http.StatusServiceUnavailable)
+
+	config := DefaultLLMConfig()
+	config.MaxRetries = 5
+	config.BaseBackoff = time.Millisecond
+
+	// One retry is left, so the second attempt's 503 ends the request
+	ctx := WithRetryBudget(context.Background(), NewRetryBudget(1))
+	_, err := postJSONWithRetry(ctx, "http://llm.test/chat/completions", nil, []byte(`{}`), config)
+	if !errors.Is(err, ErrRetryBudgetExhausted) {
+		t.Fatalf("postJSONWithRetry() error = %v, want ErrRetryBudgetExhausted", err)
+	}
+	if !strings.Contains(err.Error(), "after 2 attempt(s)") || !strings.Contains(err.Error(), "503") {
+		t.Errorf("postJSONWithRetry() error = %v, want the status and \"after 2 attempt(s)\"", err)
+	}
+	if got := requests.Load(); got != 2 {
+		t.Errorf("sent %d requests, want 2", got)
+	}
+}
+
+func TestBackoffDelayIsCapped(t *testing.T) {
+	for _, attempt := range []int{0, 1, 5, 6, 35, 63, 64, 1000} {
+		got := backoffDelay(500*time.Millisecond, attempt)
+		if got <= 0 || got > MaxBackoff {
+			t.Errorf("backoffDelay(500ms, %d) = %v, want between 0 and %v", attempt, got, MaxBackoff)
+		}
+	}
+	if got := backoffDelay(500*time.Millisecond, 0); got < 500*time.Millisecond || got > 750*time.Millisecond {
+		t.Errorf("backoffDelay(500ms, 0) = %v, want 500ms plus up to 50%% jitter", got)
+	}
+}
+
+func TestRetryAfterIsCapped(t *testing.T) {
+	tests := []struct {
+		header string
+		want   time.Duration
+	}{
+		{header: "2", want: 2 * time.Second},
+		{header: "86400", want: MaxBackoff},
+		{header: "9999999999999999999", want: 0},
+		{header: time.Now().Add(48 * time.Hour).UTC().Format(http.TimeFormat), want: MaxBackoff},
+		{header: "Mon, 01 Jan 1990 00:00:00 GMT", want: 0},
+	}
+
+	for _, tt := range tests {
+		got, _ := retryAfter(tt.header)
+		if got != tt.want {
+			t.Errorf("retryAfter(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
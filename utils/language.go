@@ -0,0 +1,159 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// LanguageUndetermined is the ISO 639 code for text whose language is unknown
+const LanguageUndetermined = "und"
+
+// languageNames maps ISO 639-1 codes to English names for prompts
+var languageNames = map[string]string{
+	"ar": "Arabic",
+	"de": "German",
+	"el": "Greek",
+	"en": "English",
+	"es": "Spanish",
+	"fr": "French",
+	"he": "Hebrew",
+	"hi": "Hindi",
+	"it": "Italian",
+	"ja": "Japanese",
+	"ko": "Korean",
+	"nl": "Dutch",
+	"pl": "Polish",
+	"pt": "Portuguese",
+	"ru": "Russian",
+	"sv": "Swedish",
+	"th": "Thai",
+	"tr": "Turkish",
+	"uk": "Ukrainian",
+	"zh": "Chinese",
+}
+
+// LanguageName returns the English name for an ISO 639-1 code such as
+// "es", or code itself when it isn't a known code (so a name like
+// "Spanish" passes through)
+func LanguageName(code string) string {
+	if name, ok := languageNames[strings.ToLower(code)]; ok {
+		return name
+	}
+	return code
+}
+
+// scriptLanguages maps scripts used by essentially one language we support
+// to that language's ISO 639-1 code
+var scriptLanguages = []struct {
+	table *unicode.RangeTable
+	code  string
+}{
+	{unicode.Hangul, "ko"},
+	{unicode.Cyrillic, "ru"},
+	{unicode.Arabic, "ar"},
+	{unicode.Greek, "el"},
+	{unicode.Hebrew, "he"},
+	{unicode.Devanagari, "hi"},
+	{unicode.Thai, "th"},
+}
+
+// languageStopwords holds very common function words used to tell apart
+// languages written in the Latin script
+var languageStopwords = map[string][]string{
+	"en": {"the", "and", "is", "are", "was", "of", "to", "in", "that", "it", "with", "for", "this", "have", "not", "you", "be", "on", "what", "how"},
+	"es": {"el", "la", "los", "las", "y", "es", "que", "de", "en", "un", "una", "por", "con", "para", "no", "del", "se", "como", "está", "qué"},
+	"fr": {"le", "la", "les", "et", "est", "que", "de", "des", "un", "une", "pour", "avec", "dans", "pas", "du", "ce", "qui", "sont", "il", "je"},
+	"de": {"der", "die", "das", "und", "ist", "nicht", "ein", "eine", "zu", "mit", "den", "von", "ich", "sie", "auf", "für", "dem", "es", "wie", "sind"},
+	"it": {"il", "lo", "gli", "e", "è", "che", "di", "un", "una", "per", "con", "non", "del", "della", "sono", "come", "questo", "nel", "si", "anche"},
+	"pt": {"o", "os", "as", "e", "é", "que", "de", "um", "uma", "para", "com", "não", "do", "da", "em", "no", "na", "se", "como", "são"},
+	"nl": {"de", "het", "een", "en", "is", "van", "dat", "niet", "zijn", "op", "met", "voor", "ik", "je", "ook", "wat", "aan", "er", "maar", "naar"},
+}
+
+// stopwordLanguages is languageStopwords inverted for lookup by word
+var stopwordLanguages = func() map[string][]string {
+	index := map[string][]string{}
+	for code, words := range languageStopwords {
+		for _, word := range words {
+			index[word] = append(index[word], code)
+		}
+	}
+	return index
+}()
+
+// DetectLanguage guesses the ISO 639-1 code of text using its script and,
+// for Latin-script text, counts of common stopwords. It returns "und" when
+// the text is empty or no language clearly wins. This is a lightweight
+// heuristic; expect it to struggle with very short or mixed-language input.
+func DetectLanguage(text string) (string, error) {
+	if !utf8.ValidString(text) {
+		return LanguageUndetermined, fmt.Errorf("text is not valid UTF-8")
+	}
+
+	var letters, han, kana, latin int
+	scripts := make([]int, len(scriptLanguages))
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		switch {
+		case unicode.Is(unicode.Han, r):
+			han++
+		case unicode.In(r, unicode.Hiragana, unicode.Katakana):
+			kana++
+		case unicode.Is(unicode.Latin, r):
+			latin++
+		default:
+			for i, script := range scriptLanguages {
+				if unicode.Is(script.table, r) {
+					scripts[i]++
+					break
+				}
+			}
+		}
+	}
+
+	if letters == 0 {
+		return LanguageUndetermined, nil
+	}
+
+	// Japanese mixes kana with kanji; Han without kana is taken as Chinese
+	if kana > 0 && (kana+han)*2 > letters {
+		return "ja", nil
+	}
+	if han*2 > letters {
+		return "zh", nil
+	}
+	for i, count := range scripts {
+		if count*2 > letters {
+			return scriptLanguages[i].code, nil
+		}
+	}
+	if latin*2 <= letters {
+		return LanguageUndetermined, nil
+	}
+
+	scores := map[string]int{}
+	for _, token := range TokenizeText(text) {
+		for _, code := range stopwordLanguages[token] {
+			scores[code]++
+		}
+	}
+
+	best, bestScore, tied := LanguageUndetermined, 0, false
+	for code, score := range scores {
+		switch {
+		case score > bestScore:
+			best, bestScore, tied = code, score, false
+		case score == bestScore:
+			tied = true
+		}
+	}
+	if bestScore == 0 || tied {
+		return LanguageUndetermined, nil
+	}
+
+	return best, nil
+}
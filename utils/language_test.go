@@ -0,0 +1,41 @@
+package utils
+
+import "testing"
+
+func TestDetectLanguage(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{name: "English", text: "What is the capital of France and how many people live in it?", want: "en"},
+		{name: "Spanish", text: "¿Cuál es la capital de Francia y cuántas personas viven en la ciudad?", want: "es"},
+		{name: "Chinese", text: "法国的首都是哪个城市？", want: "zh"},
+		{name: "Japanese", text: "フランスの首都はどこですか？", want: "ja"},
+		{name: "Korean", text: "프랑스의 수도는 어디입니까?", want: "ko"},
+		{name: "empty", text: "", want: LanguageUndetermined},
+		{name: "no letters", text: "12345 !?", want: LanguageUndetermined},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DetectLanguage(tt.text)
+			if err != nil {
+				t.Fatalf("DetectLanguage(%q) error = %v", tt.text, err)
+			}
+			if got != tt.want {
+				t.Errorf("DetectLanguage(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetectLanguageRejectsInvalidUTF8(t *testing.T) {
+	got, err := DetectLanguage("caf\xe9")
+	if err == nil {
+		t.Error("DetectLanguage(invalid UTF-8) error = nil, want an error")
+	}
+	if got != LanguageUndetermined {
+		t.Errorf("DetectLanguage(invalid UTF-8) = %q, want %q", got, LanguageUndetermined)
+	}
+}
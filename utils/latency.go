@@ -0,0 +1,121 @@
+package utils
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// LatencyTracker keeps an exponential moving average of request durations
+// and of their variance, and suggests a timeout from them. It is safe for
+// concurrent use.
+type LatencyTracker struct {
+	// Alpha weights each new sample in the averages, between 0 and 1
+	Alpha float64
+
+	// Deviations is how many standard deviations above the mean
+	// SuggestedTimeout allows
+	Deviations float64
+
+	// MinSamples is how many samples are needed before SuggestedTimeout
+	// trusts the averages; until then it returns Max
+	MinSamples int
+
+	// Min and Max bound SuggestedTimeout
+	Min, Max time.Duration
+
+	mu       sync.Mutex
+	mean     float64 // seconds
+	variance float64 // seconds squared
+	samples  int
+}
+
+// NewLatencyTracker returns a tracker whose suggested timeouts stay
+// between min and max, with a smoothing factor of 0.2 and four standard
+// deviations of headroom once five samples are in
+func NewLatencyTracker(min, max time.Duration) *LatencyTracker {
+	return &LatencyTracker{
+		Alpha:      0.2,
+		Deviations: 4,
+		MinSamples: 5,
+		Min:        min,
+		Max:        max,
+	}
+}
+
+// Observe records how long a request took
+func (t *LatencyTracker) Observe(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	x := d.Seconds()
+	t.samples++
+	if t.samples == 1 {
+		t.mean = x
+		t.variance = 0
+		return
+	}
+
+	// Incremental exponentially weighted mean and variance
+	diff := x - t.mean
+	incr := t.Alpha * diff
+	t.mean += incr
+	t.variance = (1 - t.Alpha) * (t.variance + diff*incr)
+}
+
+// Stats returns the averaged duration, its standard deviation, and how
+// many samples have been observed
+func (t *LatencyTracker) Stats() (mean, stddev time.Duration, samples int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return seconds(t.mean), seconds(math.Sqrt(t.variance)), t.samples
+}
+
+// SuggestedTimeout returns the averaged duration plus Deviations standard
+// deviations, clamped to Min and Max. With fewer than MinSamples samples
+// it returns Max.
+func (t *LatencyTracker) SuggestedTimeout() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.samples < t.MinSamples {
+		return t.Max
+	}
+
+	suggested := seconds(t.mean + t.Deviations*math.Sqrt(t.variance))
+	if t.Max > 0 {
+		suggested = min(suggested, t.Max)
+	}
+	return max(suggested, t.Min)
+}
+
+// seconds converts a float number of seconds to a Duration
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// llmLatency tracks LLM API latency per endpoint and model, since models
+// answer at very different speeds
+var (
+	llmLatencyMu sync.Mutex
+	llmLatency   = map[[2]string]*LatencyTracker{}
+)
+
+// LLMLatency returns the latency tracker for model's calls to the given
+// API endpoint URL. Every non-streaming LLM request is recorded; the
+// timeouts it suggests are only used when LLMConfig.AdaptiveTimeout is
+// set. A new tracker's suggestions range from MinAdaptiveLLMTimeout to
+// LLMTimeout.
+func LLMLatency(endpoint, model string) *LatencyTracker {
+	key := [2]string{endpoint, model}
+
+	llmLatencyMu.Lock()
+	defer llmLatencyMu.Unlock()
+
+	tracker, ok := llmLatency[key]
+	if !ok {
+		tracker = NewLatencyTracker(MinAdaptiveLLMTimeout, LLMTimeout)
+		llmLatency[key] = tracker
+	}
+	return tracker
+}
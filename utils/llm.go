@@ -1,12 +1,17 @@
 package utils
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
+	"math"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 )
 
@@ -15,42 +20,448 @@ type LLMConfig struct {
 	Model       string  `json:"model"`
 	Temperature float64 `json:"temperature"`
 	MaxTokens   int     `json:"max_tokens,omitempty"`
+
+	// JSONMode asks the model to reply with a single JSON object
+	JSONMode bool `json:"json_mode,omitempty"`
+
+	// ToolChoice controls tool use in CallLLMWithTools: "auto" (the default
+	// when empty), "none", or "required"
+	ToolChoice string `json:"tool_choice,omitempty"`
+
+	// Retry policy for transient API failures (429 and 5xx)
+	MaxRetries  int           `json:"max_retries"`
+	BaseBackoff time.Duration `json:"base_backoff"`
+
+	// Rate limit shared by all calls with the same settings; zero is unlimited
+	RequestsPerMinute int `json:"requests_per_minute,omitempty"`
+	Burst             int `json:"burst,omitempty"`
+
+	// Seed, when set, is sent to OpenAI as "seed" so repeated requests with
+	// the same settings tend to return the same reply. Determinism is best
+	// effort: it only holds while the backend's system_fingerprint stays the
+	// same, and providers without seed support ignore it.
+	Seed *int `json:"seed,omitempty"`
+
+	// AdaptiveTimeout bounds each non-streaming API attempt by the model's
+	// observed latency (see LLMLatency) instead of always waiting the full
+	// LLMTimeout, so batch jobs fail fast on a degraded endpoint
+	AdaptiveTimeout bool `json:"adaptive_timeout,omitempty"`
+
+	// FewShot are example user/assistant turns that CallLLMConversation
+	// inserts after the system message, ahead of the real conversation
+	FewShot []Message `json:"few_shot,omitempty"`
+}
+
+// WithoutFewShot returns a copy of c without few-shot examples, for calls
+// whose task differs from the one the examples demonstrate
+func (c *LLMConfig) WithoutFewShot() *LLMConfig {
+	copied := *c
+	copied.FewShot = nil
+	return &copied
+}
+
+// Usage is what the API reported about a single completion
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+
+	// SystemFingerprint identifies the backend configuration that served
+	// the request; seeded replies are only reproducible while it's unchanged
+	SystemFingerprint string `json:"system_fingerprint,omitempty"`
+}
+
+// UsageReporter is implemented by providers that report token usage
+type UsageReporter interface {
+	// ChatWithUsage is Chat that also returns the API's usage report
+	ChatWithUsage(ctx context.Context, messages []Message, config *LLMConfig) (string, Usage, error)
+}
+
+// Message is a single turn in a conversation
+type Message struct {
+	Role    string `json:"role"` // "system", "user", "assistant", or "tool"
+	Content string `json:"content"`
+
+	// ToolCalls are the tools an assistant message asked to run
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+
+	// ToolCallID links a "tool" message to the call it answers
+	ToolCallID string `json:"tool_call_id,omitempty"`
+}
+
+// DefaultSystemPrompt is the system message of single-prompt calls, and
+// the one nodes start their conversations with
+const DefaultSystemPrompt = "You are a helpful assistant."
+
+// LLMProvider is implemented by each LLM backend
+type LLMProvider interface {
+	// Complete sends a single user prompt
+	Complete(ctx context.Context, prompt string, config *LLMConfig) (string, error)
+
+	// Chat sends a full conversation and returns the assistant's reply
+	Chat(ctx context.Context, messages []Message, config *LLMConfig) (string, error)
+}
+
+// DefaultProvider is the provider used by CallLLM and CallLLMWithConfig.
+// It is selected from the LLM_PROVIDER environment variable at startup; an
+// unrecognized name leaves a provider that fails every call rather than
+// sending prompts to a vendor the user didn't choose.
+var DefaultProvider LLMProvider = defaultProviderFromEnv()
+
+// defaultProviderFromEnv is ProviderFromEnv, with an unrecognized name
+// turned into an invalidProvider
+func defaultProviderFromEnv() LLMProvider {
+	provider, err := ProviderFromEnv()
+	if err != nil {
+		return invalidProvider{err: err}
+	}
+	return provider
+}
+
+// invalidProvider stands in for an unrecognized LLM_PROVIDER and fails
+// every call with err
+type invalidProvider struct {
+	err error
+}
+
+// Complete implements LLMProvider
+func (p invalidProvider) Complete(ctx context.Context, prompt string, config *LLMConfig) (string, error) {
+	return "", p.err
+}
+
+// Chat implements LLMProvider
+func (p invalidProvider) Chat(ctx context.Context, messages []Message, config *LLMConfig) (string, error) {
+	return "", p.err
 }
 
+// SetProvider replaces the provider used by CallLLM
+func SetProvider(provider LLMProvider) {
+	DefaultProvider = provider
+}
+
+// ProviderFromEnv returns the provider named by LLM_PROVIDER ("openai",
+// "azure", "anthropic", or "ollama"). When it's unset, Azure OpenAI is used if
+// AZURE_OPENAI_ENDPOINT is set and OpenAI otherwise; an unrecognized name
+// is an error.
+func ProviderFromEnv() (LLMProvider, error) {
+	name := os.Getenv("LLM_PROVIDER")
+	if name == "" && os.Getenv("AZURE_OPENAI_ENDPOINT") != "" {
+		name = "azure"
+	}
+	provider, err := ProviderByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("LLM_PROVIDER: %w", err)
+	}
+	return provider, nil
+}
+
+// ProviderByName returns the provider for name ("openai", "azure",
+// "anthropic", or "ollama"). An empty name selects OpenAI.
+func ProviderByName(name string) (LLMProvider, error) {
+	switch strings.ToLower(name) {
+	case "", "openai":
+		return &OpenAIProvider{}, nil
+	case "azure", "azure-openai":
+		return &AzureOpenAIProvider{}, nil
+	case "anthropic", "claude":
+		return &AnthropicProvider{}, nil
+	case "ollama", "local":
+		return &OllamaProvider{}, nil
+	default:
+		return nil, fmt.Errorf("unknown LLM provider %q", name)
+	}
+}
+
+// ProviderAPIKeyEnv returns the API key environment variable the configured
+// provider reads, so callers can warn about missing credentials. It is
+// empty for providers that need no key.
+func ProviderAPIKeyEnv() string {
+	switch DefaultProvider.(type) {
+	case *AnthropicProvider:
+		return "ANTHROPIC_API_KEY"
+	case *AzureOpenAIProvider:
+		return "AZURE_OPENAI_API_KEY"
+	case *MockProvider, *OllamaProvider, invalidProvider:
+		return ""
+	default:
+		return "OPENAI_API_KEY"
+	}
+}
+
+// HasLLMCredentials reports whether the configured provider's API key is set.
+// Helpers use this to fall back to offline behavior instead of failing.
+func HasLLMCredentials() bool {
+	keyEnv := ProviderAPIKeyEnv()
+	return keyEnv == "" || os.Getenv(keyEnv) != ""
+}
+
+// DefaultMaxTokens is the completion length limit DefaultLLMConfig
+// starts with; 0 leaves it to the model. The -max-tokens flag sets it so
+// every config in a run shares one ceiling.
+var DefaultMaxTokens int
+
 // DefaultLLMConfig returns default configuration
 func DefaultLLMConfig() *LLMConfig {
 	return &LLMConfig{
-		Model:       "gpt-3.5-turbo",
+		Model:       "", // Use provider default
 		Temperature: 0.7,
-		MaxTokens:   0, // Use model default
+		MaxTokens:   DefaultMaxTokens,
+		MaxRetries:  3,
+		BaseBackoff: 500 * time.Millisecond,
 	}
 }
 
-// CallLLM calls the OpenAI API with the given prompt
+// CallLLM calls the configured provider with the given prompt
 func CallLLM(prompt string) (string, error) {
 	return CallLLMWithConfig(prompt, DefaultLLMConfig())
 }
 
-// CallLLMWithConfig calls the OpenAI API with custom configuration
+// CallLLMWithConfig calls the configured provider with custom configuration.
+// Responses are served from the on-disk cache when FLYT_LLM_CACHE is set.
 func CallLLMWithConfig(prompt string, config *LLMConfig) (string, error) {
-	apiKey := os.Getenv("OPENAI_API_KEY")
+	return CallLLMCached(context.Background(), prompt, config)
+}
+
+// CallLLMConversation sends a multi-turn conversation to the configured provider,
+// through the on-disk cache when FLYT_LLM_CACHE is set. Any config.FewShot
+// examples are spliced in after the leading system messages.
+func CallLLMConversation(ctx context.Context, messages []Message, config *LLMConfig) (string, error) {
+	messages, err := withFewShot(messages, config.FewShot)
+	if err != nil {
+		return "", err
+	}
+	if err := validateMessages(messages); err != nil {
+		return "", err
+	}
+	return cachedCall(ctx, messages, config, func(ctx context.Context) (string, error) {
+		return DefaultProvider.Chat(ctx, messages, config)
+	})
+}
+
+// CallLLMWithUsage sends a conversation like CallLLMConversation, few-shot
+// examples included, and also returns the provider's usage report, including the system fingerprint.
+// It always calls the API, since a cached reply has no usage to report.
+// Providers that aren't a UsageReporter return a zero Usage.
+func CallLLMWithUsage(ctx context.Context, messages []Message, config *LLMConfig) (response string, usage Usage, err error) {
+	messages, err = withFewShot(messages, config.FewShot)
+	if err != nil {
+		return "", Usage{}, err
+	}
+	if err := validateMessages(messages); err != nil {
+		return "", Usage{}, err
+	}
+
+	ctx, span := startLLMSpan(ctx, messages, config)
+	defer func() {
+		endLLMSpan(span, response, err)
+	}()
+
+	call := instrumentLLMCall(config, func(ctx context.Context) (string, error) {
+		reporter, ok := DefaultProvider.(UsageReporter)
+		if !ok {
+			return DefaultProvider.Chat(ctx, messages, config)
+		}
+		var content string
+		content, usage, err = reporter.ChatWithUsage(ctx, messages, config)
+		return content, err
+	})
+
+	response, err = call(ctx)
+	if err != nil {
+		return "", Usage{}, err
+	}
+	return response, usage, nil
+}
+
+// CallLLMJSON calls the configured provider in JSON mode and unmarshals
+// the reply into out, which must be a pointer
+func CallLLMJSON(ctx context.Context, prompt string, out any, config *LLMConfig) error {
+	jsonConfig := *config
+	jsonConfig.JSONMode = true
+
+	// OpenAI rejects JSON mode unless the prompt mentions JSON
+	if !strings.Contains(strings.ToLower(prompt), "json") {
+		prompt += "\n\nRespond with a single JSON object."
+	}
+
+	content, err := CallLLMCached(ctx, prompt, &jsonConfig)
+	if err != nil {
+		return err
+	}
+
+	raw := stripCodeFence(content)
+	if err := json.Unmarshal([]byte(raw), out); err != nil {
+		return fmt.Errorf("model returned invalid JSON: %w\nraw content: %s", err, content)
+	}
+
+	return nil
+}
+
+// stripCodeFence removes a surrounding ``` or ```json fence some models add
+func stripCodeFence(content string) string {
+	content = strings.TrimSpace(content)
+	if !strings.HasPrefix(content, "```") {
+		return content
+	}
+	content = strings.TrimPrefix(content, "```")
+	content = strings.TrimPrefix(content, "json")
+	content = strings.TrimSuffix(content, "```")
+	return strings.TrimSpace(content)
+}
+
+// validateMessages catches malformed conversations before they reach the API
+func validateMessages(messages []Message) error {
+	if len(messages) == 0 {
+		return fmt.Errorf("conversation must contain at least one message")
+	}
+	for i, msg := range messages {
+		switch msg.Role {
+		case "system", "user", "assistant":
+		default:
+			return fmt.Errorf("message %d has invalid role %q", i, msg.Role)
+		}
+	}
+	return nil
+}
+
+// ValidateFewShot checks that examples are complete user/assistant pairs:
+// alternating roles starting with "user" and ending with "assistant",
+// each with content
+func ValidateFewShot(examples []Message) error {
+	for i, msg := range examples {
+		want := "user"
+		if i%2 == 1 {
+			want = "assistant"
+		}
+		if msg.Role != want {
+			return fmt.Errorf("few-shot example %d has role %q, want %q (roles must alternate user, assistant)", i, msg.Role, want)
+		}
+		if strings.TrimSpace(msg.Content) == "" {
+			return fmt.Errorf("few-shot example %d has no content", i)
+		}
+	}
+	if len(examples)%2 == 1 {
+		return fmt.Errorf("few-shot examples end with a user message; each needs an assistant reply")
+	}
+	return nil
+}
+
+// LoadFewShotFromJSON reads few-shot examples from a JSON array of
+// {"role": "user"|"assistant", "content": "..."} messages
+func LoadFewShotFromJSON(path string) ([]Message, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read few-shot examples: %w", err)
+	}
+
+	var examples []Message
+	if err := json.Unmarshal(data, &examples); err != nil {
+		return nil, fmt.Errorf("failed to parse few-shot examples in %s: %w", path, err)
+	}
+	if len(examples) == 0 {
+		return nil, fmt.Errorf("no few-shot examples in %s", path)
+	}
+	if err := ValidateFewShot(examples); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return examples, nil
+}
+
+// withFewShot returns messages with examples inserted after any leading
+// system messages
+func withFewShot(messages, examples []Message) ([]Message, error) {
+	if len(examples) == 0 {
+		return messages, nil
+	}
+	if err := ValidateFewShot(examples); err != nil {
+		return nil, err
+	}
+
+	split := 0
+	for split < len(messages) && messages[split].Role == "system" {
+		split++
+	}
+
+	spliced := make([]Message, 0, len(messages)+len(examples))
+	spliced = append(spliced, messages[:split]...)
+	spliced = append(spliced, examples...)
+	return append(spliced, messages[split:]...), nil
+}
+
+// PromptMessages wraps a single prompt as a conversation with the default
+// system message
+func PromptMessages(prompt string) []Message {
+	return []Message{
+		{Role: "system", Content: DefaultSystemPrompt},
+		{Role: "user", Content: prompt},
+	}
+}
+
+// OpenAIProvider talks to the OpenAI Chat Completions API
+type OpenAIProvider struct {
+	// APIKey overrides the OPENAI_API_KEY environment variable when set
+	APIKey string
+}
+
+// OpenAIBaseURL is the root of the OpenAI API. Point it at an
+// httptest.Server to test the OpenAI calls without the network.
+var OpenAIBaseURL = "https://api.openai.com/v1"
+
+// openAIDefaultModel is used when LLMConfig.Model is empty
+const openAIDefaultModel = "gpt-3.5-turbo"
+
+// Complete implements LLMProvider for OpenAI
+func (p *OpenAIProvider) Complete(ctx context.Context, prompt string, config *LLMConfig) (string, error) {
+	return p.Chat(ctx, PromptMessages(prompt), config)
+}
+
+// Chat implements LLMProvider for OpenAI
+func (p *OpenAIProvider) Chat(ctx context.Context, messages []Message, config *LLMConfig) (string, error) {
+	content, usage, err := p.ChatWithUsage(ctx, messages, config)
+	if err != nil {
+		return "", err
+	}
+	slog.DebugContext(ctx, "LLM usage",
+		"prompt_tokens", usage.PromptTokens,
+		"completion_tokens", usage.CompletionTokens,
+		"system_fingerprint", usage.SystemFingerprint)
+	return content, nil
+}
+
+// ChatWithUsage implements UsageReporter for OpenAI
+func (p *OpenAIProvider) ChatWithUsage(ctx context.Context, messages []Message, config *LLMConfig) (string, Usage, error) {
+	if err := validateMessages(messages); err != nil {
+		return "", Usage{}, err
+	}
+
+	apiKey := p.APIKey
+	if apiKey == "" {
+		apiKey = os.Getenv("OPENAI_API_KEY")
+	}
 	if apiKey == "" {
-		return "", fmt.Errorf("OPENAI_API_KEY environment variable not set")
+		return "", Usage{}, fmt.Errorf("OPENAI_API_KEY environment variable not set")
+	}
+
+	model := config.Model
+	if model == "" {
+		model = openAIDefaultModel
+	}
+
+	headers := map[string]string{
+		"Authorization": "Bearer " + apiKey,
 	}
 
+	return chatCompletion(ctx, OpenAIBaseURL+"/chat/completions", headers, model, messages, config)
+}
+
+// chatCompletion sends a Chat Completions request to url and parses the
+// reply. OpenAI and Azure OpenAI share the format and differ only in URL
+// and auth headers.
+func chatCompletion(ctx context.Context, url string, headers map[string]string, model string, messages []Message, config *LLMConfig) (string, Usage, error) {
 	// Prepare request body
 	requestBody := map[string]any{
-		"model": config.Model,
-		"messages": []map[string]string{
-			{
-				"role":    "system",
-				"content": "You are a helpful assistant.",
-			},
-			{
-				"role":    "user",
-				"content": prompt,
-			},
-		},
+		"model":       model,
+		"messages":    messages,
 		"temperature": config.Temperature,
 	}
 
@@ -58,70 +469,393 @@ func CallLLMWithConfig(prompt string, config *LLMConfig) (string, error) {
 		requestBody["max_tokens"] = config.MaxTokens
 	}
 
+	if config.JSONMode {
+		requestBody["response_format"] = map[string]string{"type": "json_object"}
+	}
+
+	if config.Seed != nil {
+		requestBody["seed"] = *config.Seed
+	}
+
 	jsonData, err := json.Marshal(requestBody)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %w", err)
+		return "", Usage{}, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	// Create HTTP request
-	req, err := http.NewRequest("POST", "https://api.openai.com/v1/chat/completions", bytes.NewBuffer(jsonData))
+	body, err := postJSONWithRetry(ctx, url, headers, jsonData, config)
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+		return "", Usage{}, err
+	}
+
+	// Parse response
+	var result struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+		Usage             Usage  `json:"usage"`
+		SystemFingerprint string `json:"system_fingerprint"`
+	}
+
+	if err := json.Unmarshal(body, &result); err != nil {
+		return "", Usage{}, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	if len(result.Choices) == 0 {
+		return "", Usage{}, fmt.Errorf("no response from API")
+	}
+
+	usage := result.Usage
+	usage.SystemFingerprint = result.SystemFingerprint
+	recordUsage(ctx, model, usage)
+	return result.Choices[0].Message.Content, usage, nil
+}
+
+// EmbeddingModel is the OpenAI model used by GetEmbedding and GetEmbeddings
+var EmbeddingModel = "text-embedding-3-small"
+
+// maxEmbeddingInputs is the most inputs OpenAI accepts in one embeddings request
+const maxEmbeddingInputs = 2048
+
+// GetEmbedding returns the embedding vector for a single text
+func GetEmbedding(ctx context.Context, text string) ([]float32, error) {
+	embeddings, err := GetEmbeddings(ctx, []string{text})
+	if err != nil {
+		return nil, err
+	}
+	return embeddings[0], nil
+}
+
+// GetEmbeddings returns one embedding vector per text, in input order.
+// Inputs are sent in as few requests as the API allows.
+func GetEmbeddings(ctx context.Context, texts []string) ([][]float32, error) {
+	return getEmbeddings(ctx, texts, EmbeddingModel)
+}
+
+// getEmbeddings is GetEmbeddings with an explicit model
+func getEmbeddings(ctx context.Context, texts []string, model string) ([][]float32, error) {
+	if len(texts) == 0 {
+		return [][]float32{}, nil
+	}
+
+	apiKey := os.Getenv("OPENAI_API_KEY")
+	if apiKey == "" {
+		return nil, fmt.Errorf("OPENAI_API_KEY environment variable not set")
+	}
+
+	headers := map[string]string{
+		"Authorization": "Bearer " + apiKey,
+	}
+	config := DefaultLLMConfig()
+
+	embeddings := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += maxEmbeddingInputs {
+		batch := texts[start:min(start+maxEmbeddingInputs, len(texts))]
+
+		jsonData, err := json.Marshal(map[string]any{
+			"model": model,
+			"input": batch,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal request: %w", err)
+		}
+
+		body, err := postJSONWithRetry(ctx, OpenAIBaseURL+"/embeddings", headers, jsonData, config)
+		if err != nil {
+			return nil, err
+		}
+
+		// Parse response
+		var result struct {
+			Data []struct {
+				Index     int       `json:"index"`
+				Embedding []float32 `json:"embedding"`
+			} `json:"data"`
+		}
+
+		if err := json.Unmarshal(body, &result); err != nil {
+			return nil, fmt.Errorf("failed to parse response: %w", err)
+		}
+
+		if len(result.Data) != len(batch) {
+			return nil, fmt.Errorf("expected %d embeddings, got %d", len(batch), len(result.Data))
+		}
+
+		// The API may return items out of order, so place them by index
+		ordered := make([][]float32, len(batch))
+		for _, item := range result.Data {
+			if item.Index < 0 || item.Index >= len(batch) {
+				return nil, fmt.Errorf("embedding index %d out of range", item.Index)
+			}
+			if len(item.Embedding) == 0 {
+				return nil, fmt.Errorf("embedding %d has zero dimensions", start+item.Index)
+			}
+			ordered[item.Index] = item.Embedding
+		}
+		embeddings = append(embeddings, ordered...)
+	}
+
+	return embeddings, nil
+}
+
+// CosineSimilarity returns the cosine of the angle between two vectors,
+// from -1 to 1. It returns 0 if the vectors differ in length or either is
+// all zeros; check lengths first when that distinction matters.
+func CosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// LLMTimeout bounds each LLM API attempt; retries follow the LLMConfig
+var LLMTimeout = 30 * time.Second
+
+// MinAdaptiveLLMTimeout is the shortest timeout AdaptiveTimeout will use,
+// so a run of fast replies doesn't make an ordinary slow one time out
+var MinAdaptiveLLMTimeout = 5 * time.Second
+
+// postJSONWithRetry POSTs a JSON payload and returns the response body.
+// Transient failures are retried with exponential backoff according to the
+// config's retry policy; other non-200 responses fail immediately.
+func postJSONWithRetry(ctx context.Context, url string, headers map[string]string, payload []byte, config *LLMConfig) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+apiKey)
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
 
-	// Make request with timeout
-	client := &http.Client{
-		Timeout: 30 * time.Second,
+	// Every attempt, including retries, counts against the rate limit
+	limiter := rateLimiterFor(config)
+	client := &HTTPClient{
+		Timeout:     LLMTimeout,
+		MaxRetries:  config.MaxRetries,
+		BaseBackoff: config.BaseBackoff,
+		BeforeAttempt: func(req *http.Request) error {
+			return limiter.Wait(req.Context())
+		},
+		RetryBudget:     RetryBudgetFromContext(ctx),
+		Latency:         LLMLatency(url, config.Model),
+		AdaptiveTimeout: config.AdaptiveTimeout,
 	}
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to make request: %w", err)
+		return nil, err
 	}
 	defer resp.Body.Close()
 
-	// Read response
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("failed to read response: %w", err)
+		return nil, fmt.Errorf("failed to read response: %w", err)
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
 	}
 
-	// Parse response
-	var result struct {
-		Choices []struct {
-			Message struct {
-				Content string `json:"content"`
-			} `json:"message"`
-		} `json:"choices"`
+	return body, nil
+}
+
+// StreamingProvider is implemented by providers that can stream replies.
+// CallLLMStreaming fails for providers that don't implement it rather
+// than sending the prompt to another vendor.
+type StreamingProvider interface {
+	// ChatStream sends a conversation and calls onChunk with each piece of
+	// the reply as it arrives; an error from onChunk stops the stream
+	ChatStream(ctx context.Context, messages []Message, config *LLMConfig, onChunk func(string) error) error
+}
+
+// CallLLMStreaming calls the LLM API with streaming response
+// This is useful for long responses where you want to show progress.
+func CallLLMStreaming(ctx context.Context, prompt string, onChunk func(string) error) error {
+	return CallLLMStreamingWithConfig(ctx, prompt, DefaultLLMConfig(), onChunk)
+}
+
+// CallLLMStreamingWithConfig streams a completion with custom configuration.
+// onChunk is invoked once per content delta as it arrives; returning an error
+// from onChunk stops the stream and the error is returned to the caller.
+func CallLLMStreamingWithConfig(ctx context.Context, prompt string, config *LLMConfig, onChunk func(string) error) error {
+	streamer, ok := DefaultProvider.(StreamingProvider)
+	if !ok {
+		return fmt.Errorf("LLM provider %s does not support streaming", providerName(DefaultProvider))
+	}
+	return streamer.ChatStream(ctx, PromptMessages(prompt), config, onChunk)
+}
+
+// ChatStream implements StreamingProvider for OpenAI
+func (p *OpenAIProvider) ChatStream(ctx context.Context, messages []Message, config *LLMConfig, onChunk func(string) error) error {
+	if err := validateMessages(messages); err != nil {
+		return err
 	}
 
-	if err := json.Unmarshal(body, &result); err != nil {
-		return "", fmt.Errorf("failed to parse response: %w", err)
+	apiKey := p.APIKey
+	if apiKey == "" {
+		apiKey = os.Getenv("OPENAI_API_KEY")
+	}
+	if apiKey == "" {
+		return fmt.Errorf("OPENAI_API_KEY environment variable not set")
 	}
 
-	if len(result.Choices) == 0 {
-		return "", fmt.Errorf("no response from API")
+	model := config.Model
+	if model == "" {
+		model = openAIDefaultModel
 	}
 
-	return result.Choices[0].Message.Content, nil
+	headers := map[string]string{
+		"Authorization": "Bearer " + apiKey,
+	}
+
+	return chatCompletionStream(ctx, OpenAIBaseURL+"/chat/completions", headers, model, messages, config, onChunk)
 }
 
-// CallLLMStreaming calls the OpenAI API with streaming response
-// This is useful for long responses where you want to show progress
-func CallLLMStreaming(prompt string, onChunk func(string) error) error {
-	// Implementation would handle streaming responses
-	// For now, we'll use the regular call
-	response, err := CallLLM(prompt)
+// chatCompletionStream is chatCompletion with "stream": true, calling
+// onChunk with each content delta of the server-sent events
+func chatCompletionStream(ctx context.Context, url string, headers map[string]string, model string, messages []Message, config *LLMConfig, onChunk func(string) error) error {
+	// Prepare request body
+	requestBody := map[string]any{
+		"model":       model,
+		"messages":    messages,
+		"temperature": config.Temperature,
+		"stream":      true,
+	}
+
+	if config.MaxTokens > 0 {
+		requestBody["max_tokens"] = config.MaxTokens
+	}
+
+	if config.Seed != nil {
+		requestBody["seed"] = *config.Seed
+	}
+
+	jsonData, err := json.Marshal(requestBody)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	resp, err := openStream(ctx, url, "text/event-stream", headers, jsonData, config)
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
+
+	return readServerSentEvents(ctx, resp.Body, func(data string) (bool, error) {
+		if data == "[DONE]" {
+			return true, nil
+		}
+
+		var chunk struct {
+			Choices []struct {
+				Delta struct {
+					Content string `json:"content"`
+				} `json:"delta"`
+			} `json:"choices"`
+		}
+
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return false, fmt.Errorf("failed to parse stream chunk: %w", err)
+		}
+
+		for _, choice := range chunk.Choices {
+			if choice.Delta.Content == "" {
+				continue
+			}
+			if err := onChunk(choice.Delta.Content); err != nil {
+				return false, err
+			}
+		}
+		return false, nil
+	})
+}
+
+// openStream posts a streaming request to url, asking for the accept
+// content type, and returns the response once it has started, or an error
+// for a non-200 status
+func openStream(ctx context.Context, url, accept string, headers map[string]string, jsonData []byte, config *LLMConfig) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", accept)
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	// No client timeout here: long streams are bounded by ctx instead.
+	// Retries only happen before the stream starts.
+	limiter := rateLimiterFor(config)
+	client := &HTTPClient{
+		MaxRetries:  config.MaxRetries,
+		BaseBackoff: config.BaseBackoff,
+		BeforeAttempt: func(req *http.Request) error {
+			return limiter.Wait(req.Context())
+		},
+		RetryBudget: RetryBudgetFromContext(ctx),
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	return resp, nil
+}
+
+// readServerSentEvents calls onData with the data of each server-sent
+// event in body, until onData reports the stream done or fails, or the
+// body ends
+func readServerSentEvents(ctx context.Context, body io.Reader, onData func(data string) (done bool, err error)) error {
+	// Parse Server-Sent Events line by line
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "data:") {
+			// Skip blank keep-alive lines, event names, and SSE comments
+			continue
+		}
+
+		done, err := onData(strings.TrimSpace(strings.TrimPrefix(line, "data:")))
+		if err != nil || done {
+			return err
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("failed to read stream: %w", err)
+	}
 
-	return onChunk(response)
+	return nil
 }
@@ -0,0 +1,99 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// useOpenAIServer points the OpenAI provider at handler for one test
+func useOpenAIServer(t *testing.T, handler http.HandlerFunc) {
+	t.Helper()
+	t.Setenv("FLYT_LLM_CACHE", "")
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	previous := OpenAIBaseURL
+	OpenAIBaseURL = server.URL
+	t.Cleanup(func() { OpenAIBaseURL = previous })
+	t.Cleanup(UseProvider(&OpenAIProvider{APIKey: "test-key"}))
+}
+
+func TestCallLLMWithConfigOpenAI(t *testing.T) {
+	var request struct {
+		Model       string    `json:"model"`
+		Messages    []Message `json:"messages"`
+		Temperature float64   `json:"temperature"`
+		MaxTokens   int       `json:"max_tokens"`
+	}
+	useOpenAIServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/chat/completions" {
+			http.Error(w, "unexpected request "+r.Method+" "+r.URL.Path, http.StatusNotFound)
+			return
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer test-key" {
+			http.Error(w, "bad auth "+got, http.StatusUnauthorized)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices": [{"message": {"role": "assistant", "content": "Paris"}}],
+			"usage": {"prompt_tokens": 12, "completion_tokens": 1, "total_tokens": 13}}`))
+	})
+
+	config := DefaultLLMConfig()
+	config.Model = "gpt-4o-mini"
+	config.Temperature = 0.2
+	config.MaxTokens = 50
+
+	answer, err := CallLLMWithConfig("What is the capital of France?", config)
+	if err != nil {
+		t.Fatalf("CallLLMWithConfig() error = %v", err)
+	}
+	if answer != "Paris" {
+		t.Errorf("CallLLMWithConfig() = %q, want %q", answer, "Paris")
+	}
+
+	if request.Model != "gpt-4o-mini" || request.Temperature != 0.2 || request.MaxTokens != 50 {
+		t.Errorf("request = %+v, want the config's model, temperature, and max_tokens", request)
+	}
+	last := request.Messages[len(request.Messages)-1]
+	if last.Role != "user" || last.Content != "What is the capital of France?" {
+		t.Errorf("last message = %+v, want the prompt as a user message", last)
+	}
+}
+
+func TestCallLLMWithConfigOpenAIError(t *testing.T) {
+	useOpenAIServer(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error": {"message": "invalid model"}}`, http.StatusBadRequest)
+	})
+
+	_, err := CallLLMWithConfig("What is the capital of France?", DefaultLLMConfig())
+	if err == nil || !strings.Contains(err.Error(), "400") || !strings.Contains(err.Error(), "invalid model") {
+		t.Errorf("CallLLMWithConfig() error = %v, want the status and API message", err)
+	}
+}
+
+func TestProviderFromEnvRejectsUnknownName(t *testing.T) {
+	t.Setenv("LLM_PROVIDER", "antropic")
+
+	if provider, err := ProviderFromEnv(); err == nil || !strings.Contains(err.Error(), "antropic") {
+		t.Errorf("ProviderFromEnv() = %T, %v; want an error naming the provider", provider, err)
+	}
+
+	// The startup default must fail calls, not send them to OpenAI
+	provider := defaultProviderFromEnv()
+	if _, ok := provider.(*OpenAIProvider); ok {
+		t.Fatal("defaultProviderFromEnv() fell back to OpenAI")
+	}
+	if _, err := provider.Chat(context.Background(), PromptMessages("hi"), DefaultLLMConfig()); err == nil {
+		t.Error("Chat() on an unknown provider error = nil, want an error")
+	}
+}
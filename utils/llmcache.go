@@ -0,0 +1,152 @@
+package utils
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// LLMCacheForce caches responses even when the temperature is above zero,
+// where replies are normally too random to reuse
+var LLMCacheForce bool
+
+// LLMCacheDir overrides where cached responses are stored. When empty,
+// FLYT_LLM_CACHE_DIR is used, then ~/.flyt-cache.
+var LLMCacheDir string
+
+// LLMCacheEnabled reports whether the on-disk response cache is turned on
+// via FLYT_LLM_CACHE=1. It is meant for development, to avoid paying for
+// the same prompt on every run.
+func LLMCacheEnabled() bool {
+	value := os.Getenv("FLYT_LLM_CACHE")
+	return value == "1" || value == "true"
+}
+
+// llmCacheDir resolves the cache directory
+func llmCacheDir() (string, error) {
+	if LLMCacheDir != "" {
+		return LLMCacheDir, nil
+	}
+	if dir := os.Getenv("FLYT_LLM_CACHE_DIR"); dir != "" {
+		return dir, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find home directory for LLM cache: %w", err)
+	}
+	return filepath.Join(home, ".flyt-cache"), nil
+}
+
+// CallLLMCached is CallLLMWithConfig with an on-disk cache: when the cache
+// is enabled, a response already stored for the same prompt, provider,
+// model, and sampling settings is returned without calling the API, and
+// new responses are written through. Calls with a temperature above zero
+// bypass the cache unless LLMCacheForce is set.
+func CallLLMCached(ctx context.Context, prompt string, config *LLMConfig) (string, error) {
+	return cachedCall(ctx, PromptMessages(prompt), config, func(ctx context.Context) (string, error) {
+		return DefaultProvider.Complete(ctx, prompt, config)
+	})
+}
+
+// cachedCall returns the cached response for messages and config, or runs
+// call and stores its result. Cache I/O problems are logged, never fatal.
+// Every call gets a tracing span; only real API calls count in metrics.
+func cachedCall(ctx context.Context, messages []Message, config *LLMConfig, call func(context.Context) (string, error)) (response string, err error) {
+	ctx, span := startLLMSpan(ctx, messages, config)
+	defer func() {
+		endLLMSpan(span, response, err)
+	}()
+
+	call = instrumentLLMCall(config, call)
+
+	if !LLMCacheEnabled() || (config.Temperature > 0 && !LLMCacheForce) {
+		return call(ctx)
+	}
+
+	dir, err := llmCacheDir()
+	if err != nil {
+		slog.Warn("LLM cache disabled", "error", err)
+		return call(ctx)
+	}
+	path := filepath.Join(dir, llmCacheKey(messages, config)+".json")
+
+	var entry struct {
+		Response string `json:"response"`
+	}
+	if data, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(data, &entry); err == nil {
+			slog.Debug("LLM cache hit", "path", path)
+			span.SetAttributes(attribute.Bool("llm.cache_hit", true))
+			return entry.Response, nil
+		}
+		slog.Warn("Ignoring corrupt LLM cache entry", "path", path)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		slog.Warn("Failed to read LLM cache", "path", path, "error", err)
+	}
+
+	response, err = call(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	entry.Response = response
+	if err := writeCacheEntry(dir, path, entry); err != nil {
+		slog.Warn("Failed to write LLM cache", "path", path, "error", err)
+	}
+	return response, nil
+}
+
+// llmCacheKey hashes everything that affects a response
+func llmCacheKey(messages []Message, config *LLMConfig) string {
+	key, _ := json.Marshal(map[string]any{
+		"provider":    fmt.Sprintf("%T", DefaultProvider),
+		"model":       config.Model,
+		"temperature": config.Temperature,
+		"max_tokens":  config.MaxTokens,
+		"json_mode":   config.JSONMode,
+		"seed":        config.Seed,
+		"messages":    messages,
+	})
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:])
+}
+
+// writeCacheEntry stores entry at path as JSON
+func writeCacheEntry(dir, path string, entry any) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return writeCacheFile(dir, path, data)
+}
+
+// writeCacheFile stores data at path via a temp file, so concurrent
+// readers never see a partial write
+func writeCacheFile(dir, path string, data []byte) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, "entry-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+// ParseLogLevel maps a -log-level name to the slog level to log at:
+// "quiet" logs only warnings and errors, "info" adds progress messages, and
+// "debug" adds per-node detail
+func ParseLogLevel(name string) (slog.Level, error) {
+	switch name {
+	case "quiet":
+		return slog.LevelWarn, nil
+	case "info", "":
+		return slog.LevelInfo, nil
+	case "debug":
+		return slog.LevelDebug, nil
+	default:
+		return 0, fmt.Errorf("unknown log level %q (use quiet, info, or debug)", name)
+	}
+}
+
+// NewLogger creates a slog.Logger writing to w in "text" or "json" format
+// that logs records at level and above
+func NewLogger(w io.Writer, format string, level slog.Level) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: level}
+
+	switch format {
+	case "text", "":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q (use text or json)", format)
+	}
+}
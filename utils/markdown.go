@@ -0,0 +1,212 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+)
+
+// ANSI escape sequences used by RenderMarkdown
+const (
+	ansiReset     = "\x1b[0m"
+	ansiBold      = "\x1b[1m"
+	ansiDim       = "\x1b[2m"
+	ansiItalic    = "\x1b[3m"
+	ansiUnderline = "\x1b[4m"
+	ansiCyan      = "\x1b[36m"
+)
+
+var (
+	mdHeadingRe  = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	mdBulletRe   = regexp.MustCompile(`^(\s*)[-*+]\s+(.*)$`)
+	mdOrderedRe  = regexp.MustCompile(`^(\s*)(\d+)[.)]\s+(.*)$`)
+	mdQuoteRe    = regexp.MustCompile(`^\s*>\s?(.*)$`)
+	mdRuleRe     = regexp.MustCompile(`^\s*(?:(?:-\s*){3,}|(?:\*\s*){3,}|(?:_\s*){3,})$`)
+	mdCodeSpanRe = regexp.MustCompile("`([^`]+)`")
+	mdBoldRe     = regexp.MustCompile(`\*\*(.+?)\*\*|__(.+?)__`)
+	mdItalicRe   = regexp.MustCompile(`\*([^*\s][^*]*?)\*`)
+	mdLinkRe     = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+)
+
+// mdFenceMarker opens and closes a code block
+const mdFenceMarker = "```"
+
+// RenderMarkdown converts common markdown (headings, bold, italics, inline
+// code, links, lists, block quotes, rules, and code fences) to ANSI-styled
+// text for a terminal. Anything else passes through unchanged.
+func RenderMarkdown(text string) string {
+	lines := strings.Split(text, "\n")
+	out := make([]string, 0, len(lines))
+
+	inFence := false
+	for _, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), mdFenceMarker) {
+			inFence = !inFence
+			continue
+		}
+		if inFence {
+			out = append(out, "    "+ansiCyan+line+ansiReset)
+			continue
+		}
+
+		if m := mdHeadingRe.FindStringSubmatch(line); m != nil {
+			style := ansiBold
+			if len(m[1]) == 1 {
+				style += ansiUnderline
+			}
+			out = append(out, style+stripInlineMarkdown(m[2])+ansiReset)
+			continue
+		}
+		if mdRuleRe.MatchString(line) {
+			out = append(out, ansiDim+strings.Repeat("─", 40)+ansiReset)
+			continue
+		}
+		if m := mdBulletRe.FindStringSubmatch(line); m != nil {
+			out = append(out, m[1]+"  • "+renderInline(m[2]))
+			continue
+		}
+		if m := mdOrderedRe.FindStringSubmatch(line); m != nil {
+			out = append(out, m[1]+"  "+m[2]+". "+renderInline(m[3]))
+			continue
+		}
+		if m := mdQuoteRe.FindStringSubmatch(line); m != nil {
+			out = append(out, ansiDim+"│ "+ansiReset+ansiItalic+renderInline(m[1])+ansiReset)
+			continue
+		}
+
+		out = append(out, renderInline(line))
+	}
+
+	return strings.Join(out, "\n")
+}
+
+// renderInline styles inline markdown, leaving code spans untouched
+func renderInline(line string) string {
+	var b strings.Builder
+	last := 0
+	for _, loc := range mdCodeSpanRe.FindAllStringSubmatchIndex(line, -1) {
+		b.WriteString(renderEmphasis(line[last:loc[0]]))
+		b.WriteString(ansiCyan + line[loc[2]:loc[3]] + ansiReset)
+		last = loc[1]
+	}
+	b.WriteString(renderEmphasis(line[last:]))
+	return b.String()
+}
+
+// renderEmphasis styles bold, italics, and links in text without code spans
+func renderEmphasis(text string) string {
+	text = mdLinkRe.ReplaceAllString(text, ansiUnderline+"$1"+ansiReset+" "+ansiDim+"($2)"+ansiReset)
+	text = mdBoldRe.ReplaceAllString(text, ansiBold+"$1$2"+ansiReset)
+	text = mdItalicRe.ReplaceAllString(text, ansiItalic+"$1"+ansiReset)
+	return text
+}
+
+// stripInlineMarkdown removes inline markers from text that is styled as a whole
+func stripInlineMarkdown(text string) string {
+	text = mdCodeSpanRe.ReplaceAllString(text, "$1")
+	text = mdLinkRe.ReplaceAllString(text, "$1")
+	text = mdBoldRe.ReplaceAllString(text, "$1$2")
+	return mdItalicRe.ReplaceAllString(text, "$1")
+}
+
+// mdEscaper backslash-escapes the characters that start inline markdown
+// or HTML anywhere in a line
+var mdEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", `*`, `\*`, `_`, `\_`, `[`, `\[`, `]`, `\]`,
+	`<`, `\<`, `>`, `\>`, `#`, `\#`, `|`, `\|`, `~`, `\~`, `!`, `\!`,
+)
+
+// mdBlockStartRe matches line starts that markdown reads as a list item,
+// setext underline, or rule even after inline escaping
+var mdBlockStartRe = regexp.MustCompile(`^\s*(\d+[.)]|[-+=])`)
+
+// EscapeMarkdown escapes text so it renders literally inside a markdown
+// document, keeping user input from adding headings, lists, links, or
+// HTML. Line breaks are kept.
+func EscapeMarkdown(text string) string {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		line = mdEscaper.Replace(line)
+		if loc := mdBlockStartRe.FindStringSubmatchIndex(line); loc != nil {
+			// Escape the list or underline marker, the match's last byte
+			line = line[:loc[3]-1] + `\` + line[loc[3]-1:]
+		}
+		lines[i] = line
+	}
+	return strings.Join(lines, "\n")
+}
+
+// mdURLEscaper encodes the characters that would end a link destination
+var mdURLEscaper = strings.NewReplacer(" ", "%20", "(", "%28", ")", "%29", "<", "%3C", ">", "%3E")
+
+// MarkdownLink returns a markdown link to url with text escaped as its
+// label, or just the escaped text when url is empty
+func MarkdownLink(text, url string) string {
+	if url == "" {
+		return EscapeMarkdown(text)
+	}
+	if text == "" {
+		text = url
+	}
+	return "[" + EscapeMarkdown(text) + "](" + mdURLEscaper.Replace(url) + ")"
+}
+
+// NestMarkdownHeadings adds levels to every ATX heading in text outside
+// code fences, capped at level 6, so a markdown fragment can sit under a
+// heading of its own
+func NestMarkdownHeadings(text string, levels int) string {
+	lines := strings.Split(text, "\n")
+	inFence := false
+	for i, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), mdFenceMarker) {
+			inFence = !inFence
+			continue
+		}
+		if inFence {
+			continue
+		}
+		if m := mdHeadingRe.FindStringSubmatch(line); m != nil {
+			level := min(len(m[1])+levels, 6)
+			lines[i] = strings.Repeat("#", level) + " " + m[2]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// mdUnescapeRe matches the backslash escapes EscapeMarkdown adds
+var mdUnescapeRe = regexp.MustCompile(`\\([\\` + "`" + `*_\[\]<>#|~!.)=+-])`)
+
+// StripMarkdown converts markdown to plain text, the inverse of
+// RenderMarkdown's styling: heading, list, and quote markers, rules, code
+// fences, emphasis, and backslash escapes are dropped, links keep only
+// their text, and code is kept as is
+func StripMarkdown(text string) string {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	out := make([]string, 0, len(lines))
+
+	inFence := false
+	for _, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), mdFenceMarker) {
+			inFence = !inFence
+			continue
+		}
+		if inFence {
+			out = append(out, line)
+			continue
+		}
+
+		if m := mdHeadingRe.FindStringSubmatch(line); m != nil {
+			line = m[2]
+		} else if mdRuleRe.MatchString(line) {
+			continue
+		} else if m := mdBulletRe.FindStringSubmatch(line); m != nil {
+			line = m[1] + m[2]
+		} else if m := mdOrderedRe.FindStringSubmatch(line); m != nil {
+			line = m[1] + m[2] + ". " + m[3]
+		} else if m := mdQuoteRe.FindStringSubmatch(line); m != nil {
+			line = m[1]
+		}
+		out = append(out, mdUnescapeRe.ReplaceAllString(stripInlineMarkdown(line), "$1"))
+	}
+
+	return strings.Join(out, "\n")
+}
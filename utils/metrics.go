@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricSet holds the Prometheus collectors for LLM calls and flow runs
+type metricSet struct {
+	llmRequests *prometheus.CounterVec
+	llmErrors   *prometheus.CounterVec
+	llmLatency  *prometheus.HistogramVec
+	flowRuns    *prometheus.CounterVec
+}
+
+// activeMetrics is nil until EnableMetrics is called, which keeps
+// instrumentation a no-op for CLI runs
+var activeMetrics atomic.Pointer[metricSet]
+
+// EnableMetrics starts recording LLM and flow metrics and returns the
+// handler that serves them in the Prometheus text format. Metrics live in
+// a private registry, so calling it again starts from fresh counters.
+func EnableMetrics() http.Handler {
+	m := &metricSet{
+		llmRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "flyt_llm_requests_total",
+			Help: "LLM API requests, excluding cache hits.",
+		}, []string{"provider", "model"}),
+		llmErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "flyt_llm_errors_total",
+			Help: "LLM API requests that returned an error.",
+		}, []string{"provider", "model"}),
+		llmLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "flyt_llm_request_duration_seconds",
+			Help:    "LLM API request latency, including retries.",
+			Buckets: prometheus.ExponentialBuckets(0.1, 2, 10),
+		}, []string{"provider", "model"}),
+		flowRuns: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "flyt_flow_runs_total",
+			Help: "Flow runs by mode and outcome.",
+		}, []string{"mode", "status"}),
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(m.llmRequests, m.llmErrors, m.llmLatency, m.flowRuns)
+	activeMetrics.Store(m)
+
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// RecordFlowRun counts a finished flow run; err marks it as failed
+func RecordFlowRun(mode string, err error) {
+	m := activeMetrics.Load()
+	if m == nil {
+		return
+	}
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+	m.flowRuns.WithLabelValues(mode, status).Inc()
+}
+
+// instrumentLLMCall wraps an LLM API call to record its count, errors,
+// and latency when metrics are enabled
+func instrumentLLMCall(config *LLMConfig, call func(context.Context) (string, error)) func(context.Context) (string, error) {
+	return func(ctx context.Context) (string, error) {
+		m := activeMetrics.Load()
+		if m == nil {
+			return call(ctx)
+		}
+
+		labels := prometheus.Labels{"provider": providerName(DefaultProvider), "model": config.Model}
+		if config.Model == "" {
+			labels["model"] = "default"
+		}
+
+		start := time.Now()
+		response, err := call(ctx)
+		m.llmLatency.With(labels).Observe(time.Since(start).Seconds())
+		m.llmRequests.With(labels).Inc()
+		if err != nil {
+			m.llmErrors.With(labels).Inc()
+		}
+		return response, err
+	}
+}
+
+// providerName returns a short label for provider
+func providerName(provider LLMProvider) string {
+	switch provider.(type) {
+	case *OpenAIProvider:
+		return "openai"
+	case *AzureOpenAIProvider:
+		return "azure"
+	case *AnthropicProvider:
+		return "anthropic"
+	case *OllamaProvider:
+		return "ollama"
+	case *MockProvider:
+		return "mock"
+	default:
+		return fmt.Sprintf("%T", provider)
+	}
+}
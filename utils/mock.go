@@ -0,0 +1,137 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// MockProvider is an offline LLMProvider for tests and demos. Replies come
+// from Respond when set, otherwise from the Responses entry whose key is the
+// longest substring of the last user message, otherwise from Default.
+// "{{prompt}}" in a canned reply is replaced with that message.
+//
+// Install it for a test with
+//
+//	mock := &utils.MockProvider{Responses: map[string]string{"capital": "Paris"}}
+//	t.Cleanup(utils.UseProvider(mock))
+type MockProvider struct {
+	// Responses maps prompt substrings to canned replies
+	Responses map[string]string
+
+	// Default is the reply when no Responses key matches; when empty, an
+	// unmatched prompt is an error
+	Default string
+
+	// Respond, when set, produces every reply instead of Responses
+	Respond func(messages []Message, config *LLMConfig) (string, error)
+
+	// RespondTools, when set, answers CallLLMWithTools; otherwise tool
+	// calls get a plain text reply chosen as for Chat
+	RespondTools func(messages []Message, tools []ToolDef, config *LLMConfig) (*ToolResponse, error)
+
+	mu    sync.Mutex
+	calls [][]Message
+}
+
+// Complete implements LLMProvider
+func (p *MockProvider) Complete(ctx context.Context, prompt string, config *LLMConfig) (string, error) {
+	return p.Chat(ctx, PromptMessages(prompt), config)
+}
+
+// Chat implements LLMProvider
+func (p *MockProvider) Chat(ctx context.Context, messages []Message, config *LLMConfig) (string, error) {
+	if err := validateMessages(messages); err != nil {
+		return "", err
+	}
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	p.mu.Lock()
+	p.calls = append(p.calls, append([]Message(nil), messages...))
+	p.mu.Unlock()
+
+	if p.Respond != nil {
+		return p.Respond(messages, config)
+	}
+
+	var prompt string
+	for _, msg := range messages {
+		if msg.Role == "user" {
+			prompt = msg.Content
+		}
+	}
+
+	reply, matched := p.Default, ""
+	for key, response := range p.Responses {
+		if strings.Contains(prompt, key) && len(key) > len(matched) {
+			reply, matched = response, key
+		}
+	}
+	if reply == "" && matched == "" {
+		return "", fmt.Errorf("mock provider has no response for prompt %q", prompt)
+	}
+
+	return strings.ReplaceAll(reply, "{{prompt}}", prompt), nil
+}
+
+// ChatStream implements StreamingProvider, sending the reply Chat would
+// give one word at a time
+func (p *MockProvider) ChatStream(ctx context.Context, messages []Message, config *LLMConfig, onChunk func(string) error) error {
+	reply, err := p.Chat(ctx, messages, config)
+	if err != nil {
+		return err
+	}
+
+	for _, chunk := range strings.SplitAfter(reply, " ") {
+		if chunk == "" {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := onChunk(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ChatWithTools implements ToolCaller
+func (p *MockProvider) ChatWithTools(ctx context.Context, messages []Message, tools []ToolDef, config *LLMConfig) (*ToolResponse, error) {
+	if p.RespondTools == nil {
+		content, err := p.Chat(ctx, messages, config)
+		if err != nil {
+			return nil, err
+		}
+		return &ToolResponse{Content: content}, nil
+	}
+
+	if err := validateToolMessages(messages); err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	p.calls = append(p.calls, append([]Message(nil), messages...))
+	p.mu.Unlock()
+
+	return p.RespondTools(messages, tools, config)
+}
+
+// Calls returns the conversations sent to the provider so far, oldest first
+func (p *MockProvider) Calls() [][]Message {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([][]Message(nil), p.calls...)
+}
+
+// UseProvider installs provider as DefaultProvider and returns a function
+// that restores the previous one, suitable for t.Cleanup or defer
+func UseProvider(provider LLMProvider) (restore func()) {
+	previous := DefaultProvider
+	SetProvider(provider)
+	return func() {
+		SetProvider(previous)
+	}
+}
@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ModerationModel is the OpenAI model used by Moderate
+var ModerationModel = "omni-moderation-latest"
+
+// ModerationResult reports whether text violates content policy
+type ModerationResult struct {
+	Flagged bool
+
+	// Categories that triggered the flag, sorted, e.g. "violence"
+	Categories []string
+
+	// Source is "openai" or "blocklist", whichever made the decision
+	Source string
+}
+
+// ModerationBlocklist maps categories to lowercase phrases used when no
+// OpenAI key is available. It is deliberately small and phrase based so
+// that everyday questions ("how do I kill a process?") aren't blocked;
+// extend it for your domain.
+var ModerationBlocklist = map[string][]string{
+	"violence":   {"build a bomb", "make a bomb", "kill you", "kill them", "shoot up"},
+	"self-harm":  {"kill myself", "end my life", "hurt myself"},
+	"illicit":    {"make meth", "cook meth", "buy stolen"},
+	"harassment": {"you are worthless", "you're worthless"},
+}
+
+// Moderate checks text with OpenAI's moderation endpoint, or with
+// ModerationBlocklist when OPENAI_API_KEY is not set
+func Moderate(ctx context.Context, text string) (ModerationResult, error) {
+	apiKey := os.Getenv("OPENAI_API_KEY")
+	if apiKey == "" {
+		return ModerateBlocklist(text), nil
+	}
+
+	jsonData, err := json.Marshal(map[string]any{
+		"model": ModerationModel,
+		"input": text,
+	})
+	if err != nil {
+		return ModerationResult{}, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	headers := map[string]string{
+		"Authorization": "Bearer " + apiKey,
+	}
+
+	body, err := postJSONWithRetry(ctx, OpenAIBaseURL+"/moderations", headers, jsonData, DefaultLLMConfig())
+	if err != nil {
+		return ModerationResult{}, err
+	}
+
+	// Parse response
+	var result struct {
+		Results []struct {
+			Flagged    bool            `json:"flagged"`
+			Categories map[string]bool `json:"categories"`
+		} `json:"results"`
+	}
+
+	if err := json.Unmarshal(body, &result); err != nil {
+		return ModerationResult{}, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	if len(result.Results) == 0 {
+		return ModerationResult{}, fmt.Errorf("no moderation result from API")
+	}
+
+	moderation := ModerationResult{Flagged: result.Results[0].Flagged, Categories: []string{}, Source: "openai"}
+	for category, flagged := range result.Results[0].Categories {
+		if flagged {
+			moderation.Categories = append(moderation.Categories, category)
+		}
+	}
+	sort.Strings(moderation.Categories)
+
+	return moderation, nil
+}
+
+// ModerateBlocklist flags text containing any ModerationBlocklist phrase.
+// It is what Moderate uses offline, and a fallback if the API fails.
+func ModerateBlocklist(text string) ModerationResult {
+	normalized := " " + strings.Join(TokenizeText(text), " ") + " "
+
+	result := ModerationResult{Categories: []string{}, Source: "blocklist"}
+	for category, phrases := range ModerationBlocklist {
+		for _, phrase := range phrases {
+			if strings.Contains(normalized, " "+phrase+" ") {
+				result.Categories = append(result.Categories, category)
+				break
+			}
+		}
+	}
+	sort.Strings(result.Categories)
+	result.Flagged = len(result.Categories) > 0
+
+	return result
+}
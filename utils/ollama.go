@@ -0,0 +1,198 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// OllamaProvider talks to a local Ollama server's chat API. It needs no
+// API key.
+type OllamaProvider struct {
+	// BaseURL overrides the OLLAMA_HOST environment variable and
+	// OllamaBaseURL
+	BaseURL string
+}
+
+// OllamaBaseURL is the Ollama server used when neither the provider nor
+// OLLAMA_HOST sets one
+var OllamaBaseURL = "http://localhost:11434"
+
+// ollamaDefaultModel is used when LLMConfig.Model is empty
+const ollamaDefaultModel = "llama3"
+
+// ollamaChunk is a /api/chat reply, or one NDJSON line of a streamed one
+type ollamaChunk struct {
+	Message struct {
+		Content string `json:"content"`
+	} `json:"message"`
+	Done            bool   `json:"done"`
+	Error           string `json:"error"`
+	PromptEvalCount int    `json:"prompt_eval_count"`
+	EvalCount       int    `json:"eval_count"`
+}
+
+// usage returns the token counts of a reply or a stream's last chunk
+func (c ollamaChunk) usage() Usage {
+	return Usage{
+		PromptTokens:     c.PromptEvalCount,
+		CompletionTokens: c.EvalCount,
+		TotalTokens:      c.PromptEvalCount + c.EvalCount,
+	}
+}
+
+// Complete implements LLMProvider for Ollama
+func (p *OllamaProvider) Complete(ctx context.Context, prompt string, config *LLMConfig) (string, error) {
+	return p.Chat(ctx, PromptMessages(prompt), config)
+}
+
+// Chat implements LLMProvider for Ollama
+func (p *OllamaProvider) Chat(ctx context.Context, messages []Message, config *LLMConfig) (string, error) {
+	content, usage, err := p.ChatWithUsage(ctx, messages, config)
+	if err != nil {
+		return "", err
+	}
+	slog.DebugContext(ctx, "LLM usage",
+		"prompt_tokens", usage.PromptTokens,
+		"completion_tokens", usage.CompletionTokens)
+	return content, nil
+}
+
+// ChatWithUsage implements UsageReporter for Ollama
+func (p *OllamaProvider) ChatWithUsage(ctx context.Context, messages []Message, config *LLMConfig) (string, Usage, error) {
+	if err := validateMessages(messages); err != nil {
+		return "", Usage{}, err
+	}
+
+	jsonData, err := p.requestBody(messages, config, false)
+	if err != nil {
+		return "", Usage{}, err
+	}
+
+	body, err := postJSONWithRetry(ctx, p.chatURL(), nil, jsonData, config)
+	if err != nil {
+		return "", Usage{}, err
+	}
+
+	var result ollamaChunk
+	if err := json.Unmarshal(body, &result); err != nil {
+		return "", Usage{}, fmt.Errorf("failed to parse response: %w", err)
+	}
+	if result.Error != "" {
+		return "", Usage{}, fmt.Errorf("ollama: %s", result.Error)
+	}
+	if result.Message.Content == "" {
+		return "", Usage{}, fmt.Errorf("no response from API")
+	}
+
+	usage := result.usage()
+	recordUsage(ctx, cmp.Or(config.Model, ollamaDefaultModel), usage)
+	return result.Message.Content, usage, nil
+}
+
+// ChatStream implements StreamingProvider for Ollama, which streams
+// newline-delimited JSON objects rather than server-sent events
+func (p *OllamaProvider) ChatStream(ctx context.Context, messages []Message, config *LLMConfig, onChunk func(string) error) error {
+	if err := validateMessages(messages); err != nil {
+		return err
+	}
+
+	jsonData, err := p.requestBody(messages, config, true)
+	if err != nil {
+		return err
+	}
+
+	resp, err := openStream(ctx, p.chatURL(), "application/x-ndjson", nil, jsonData, config)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var chunk ollamaChunk
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			return fmt.Errorf("failed to parse stream chunk: %w", err)
+		}
+		if chunk.Error != "" {
+			return fmt.Errorf("ollama: %s", chunk.Error)
+		}
+
+		if chunk.Message.Content != "" {
+			if err := onChunk(chunk.Message.Content); err != nil {
+				return err
+			}
+		}
+		// The last chunk carries the token counts
+		if chunk.Done {
+			recordUsage(ctx, cmp.Or(config.Model, ollamaDefaultModel), chunk.usage())
+			return nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("failed to read stream: %w", err)
+	}
+
+	return fmt.Errorf("stream ended before the reply was done")
+}
+
+// requestBody encodes a /api/chat request
+func (p *OllamaProvider) requestBody(messages []Message, config *LLMConfig, stream bool) ([]byte, error) {
+	options := map[string]any{
+		"temperature": config.Temperature,
+	}
+	if config.MaxTokens > 0 {
+		options["num_predict"] = config.MaxTokens
+	}
+	if config.Seed != nil {
+		options["seed"] = *config.Seed
+	}
+
+	requestBody := map[string]any{
+		"model":    cmp.Or(config.Model, ollamaDefaultModel),
+		"messages": messages,
+		"stream":   stream,
+		"options":  options,
+	}
+
+	if config.JSONMode {
+		requestBody["format"] = "json"
+	}
+
+	jsonData, err := json.Marshal(requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	return jsonData, nil
+}
+
+// chatURL returns the /api/chat URL. OLLAMA_HOST may omit the scheme, as
+// the Ollama CLI allows.
+func (p *OllamaProvider) chatURL() string {
+	base := cmp.Or(p.BaseURL, os.Getenv("OLLAMA_HOST"), OllamaBaseURL)
+	if !strings.Contains(base, "://") {
+		base = "http://" + base
+	}
+	return strings.TrimRight(base, "/") + "/api/chat"
+}
@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestOllamaChatStreamRecordsUsage(t *testing.T) {
+	var accept string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accept = r.Header.Get("Accept")
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Write([]byte(`{"message": {"content": "Par"}, "done": false}
+{"message": {"content": "is"}, "done": false}
+{"message": {"content": ""}, "done": true, "prompt_eval_count": 12, "eval_count": 2}
+`))
+	}))
+	defer server.Close()
+
+	tracker := NewUsageTracker()
+	ctx := WithUsageTracker(context.Background(), tracker)
+	config := DefaultLLMConfig()
+	config.Model = "llama3.2"
+
+	var reply strings.Builder
+	provider := &OllamaProvider{BaseURL: server.URL}
+	err := provider.ChatStream(ctx, PromptMessages("What is the capital of France?"), config, func(chunk string) error {
+		reply.WriteString(chunk)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ChatStream() error = %v", err)
+	}
+
+	if reply.String() != "Paris" {
+		t.Errorf("streamed %q, want %q", reply.String(), "Paris")
+	}
+	if accept != "application/x-ndjson" {
+		t.Errorf("Accept = %q, want application/x-ndjson", accept)
+	}
+	want := Usage{PromptTokens: 12, CompletionTokens: 2, TotalTokens: 14}
+	if got := tracker.ByModel()["llama3.2"]; got != want || tracker.Calls() != 1 {
+		t.Errorf("recorded %+v in %d call(s), want %+v in 1", got, tracker.Calls(), want)
+	}
+}
@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"unicode/utf8"
+)
+
+// RankResults sorts results by how well their title and snippet match
+// query (see RankTexts), best first. Ties keep their original order, and
+// results is not modified.
+func RankResults(query string, results []SearchResult) []SearchResult {
+	texts := make([]string, len(results))
+	for i, result := range results {
+		texts[i] = result.Title + " " + result.Snippet
+	}
+
+	ranked := make([]SearchResult, len(results))
+	for i, index := range RankTexts(query, texts) {
+		ranked[i] = results[index]
+	}
+	return ranked
+}
+
+// RankTexts returns the indices of texts ordered by term overlap with
+// query, best first. Each query term found in a text adds 1+log(count), so
+// covering more distinct terms beats repeating one. Stop words are
+// ignored, and ties keep their original order.
+func RankTexts(query string, texts []string) []int {
+	terms := map[string]bool{}
+	for _, token := range TokenizeText(query) {
+		if utf8.RuneCountInString(token) >= 2 && !IsStopWord(token) {
+			terms[token] = true
+		}
+	}
+
+	scores := make([]float64, len(texts))
+	for i, text := range texts {
+		counts := map[string]int{}
+		for _, token := range TokenizeText(text) {
+			if terms[token] {
+				counts[token]++
+			}
+		}
+		for _, count := range counts {
+			scores[i] += 1 + math.Log(float64(count))
+		}
+	}
+
+	return orderByScore(scores)
+}
+
+// RankResultsSemantic sorts results by the cosine similarity between the
+// embedding of query and of each result's title and snippet, best first.
+// Ties keep their original order. It makes one embeddings request.
+func RankResultsSemantic(ctx context.Context, query string, results []SearchResult) ([]SearchResult, error) {
+	if len(results) == 0 {
+		return []SearchResult{}, nil
+	}
+
+	texts := make([]string, 0, len(results)+1)
+	texts = append(texts, query)
+	for _, result := range results {
+		texts = append(texts, result.Title+"\n"+result.Snippet)
+	}
+
+	embeddings, err := GetEmbeddings(ctx, texts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed search results: %w", err)
+	}
+
+	ranked := make([]SearchResult, len(results))
+	for i, index := range RankEmbeddings(embeddings[0], embeddings[1:]) {
+		ranked[i] = results[index]
+	}
+	return ranked, nil
+}
+
+// RankEmbeddings returns the indices of embeddings ordered by cosine
+// similarity to query, best first. Ties keep their original order.
+func RankEmbeddings(query []float32, embeddings [][]float32) []int {
+	scores := make([]float64, len(embeddings))
+	for i, embedding := range embeddings {
+		scores[i] = CosineSimilarity(query, embedding)
+	}
+	return orderByScore(scores)
+}
+
+// orderByScore returns indices ordered by descending score, with ties
+// broken by original index
+func orderByScore(scores []float64) []int {
+	order := make([]int, len(scores))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return scores[order[a]] > scores[order[b]]
+	})
+	return order
+}
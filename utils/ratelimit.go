@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket that paces outgoing API requests.
+// It is safe for concurrent use.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // tokens added per second; zero means unlimited
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter allows requestsPerMinute on average with bursts of up to
+// burst requests. A non-positive requestsPerMinute means unlimited.
+func NewRateLimiter(requestsPerMinute, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		rate:   float64(requestsPerMinute) / 60,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// DefaultRateLimiter is used when LLMConfig sets no rate; it never blocks
+var DefaultRateLimiter = NewRateLimiter(0, 1)
+
+// Wait blocks until a request may proceed or ctx is done
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		if l.rate <= 0 {
+			l.mu.Unlock()
+			return ctx.Err()
+		}
+
+		// Refill based on elapsed time
+		now := time.Now()
+		l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+		l.last = now
+
+		if l.tokens >= 1 {
+			l.tokens--
+			l.mu.Unlock()
+			return nil
+		}
+
+		wait := time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+		l.mu.Unlock()
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// limiters shares one bucket between all calls with the same rate settings,
+// so concurrent batch items draw from a single quota
+var (
+	limitersMu sync.Mutex
+	limiters   = map[[2]int]*RateLimiter{}
+)
+
+// rateLimiterFor returns the shared limiter for a config's rate settings
+func rateLimiterFor(config *LLMConfig) *RateLimiter {
+	if config.RequestsPerMinute <= 0 {
+		return DefaultRateLimiter
+	}
+
+	key := [2]int{config.RequestsPerMinute, config.Burst}
+
+	limitersMu.Lock()
+	defer limitersMu.Unlock()
+
+	limiter, ok := limiters[key]
+	if !ok {
+		limiter = NewRateLimiter(config.RequestsPerMinute, config.Burst)
+		limiters[key] = limiter
+	}
+	return limiter
+}
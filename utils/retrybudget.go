@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+)
+
+// ErrRetryBudgetExhausted is wrapped by request errors that would have
+// been retried if the run's RetryBudget had retries left
+var ErrRetryBudgetExhausted = errors.New("retry budget exhausted")
+
+// RetryBudget caps the total number of retries shared by every LLM call in
+// a run, on top of each call's own MaxRetries, so an outage can't turn a
+// batch job into a retry storm. Once it's spent, calls still make their
+// first attempt but fail on the first transient error. A nil *RetryBudget
+// allows unlimited retries. It is safe for concurrent use.
+type RetryBudget struct {
+	limit int64
+	used  atomic.Int64
+}
+
+// NewRetryBudget returns a budget of limit retries
+func NewRetryBudget(limit int) *RetryBudget {
+	return &RetryBudget{limit: int64(max(limit, 0))}
+}
+
+// Take spends one retry, reporting false when none are left
+func (b *RetryBudget) Take() bool {
+	if b == nil {
+		return true
+	}
+	for {
+		used := b.used.Load()
+		if used >= b.limit {
+			return false
+		}
+		if b.used.CompareAndSwap(used, used+1) {
+			return true
+		}
+	}
+}
+
+// Remaining returns how many retries are left; -1 for a nil budget
+func (b *RetryBudget) Remaining() int {
+	if b == nil {
+		return -1
+	}
+	return int(b.limit - b.used.Load())
+}
+
+// Used returns how many retries have been spent
+func (b *RetryBudget) Used() int {
+	if b == nil {
+		return 0
+	}
+	return int(b.used.Load())
+}
+
+// retryBudgetKey is the context key for a run's RetryBudget
+type retryBudgetKey struct{}
+
+// WithRetryBudget returns a context whose LLM calls share budget
+func WithRetryBudget(ctx context.Context, budget *RetryBudget) context.Context {
+	return context.WithValue(ctx, retryBudgetKey{}, budget)
+}
+
+// RetryBudgetFromContext returns the RetryBudget set by WithRetryBudget,
+// or nil when there is none
+func RetryBudgetFromContext(ctx context.Context) *RetryBudget {
+	budget, _ := ctx.Value(retryBudgetKey{}).(*RetryBudget)
+	return budget
+}
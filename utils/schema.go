@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// SchemaMaxRetries caps how many times CallLLMJSONSchema re-prompts the
+// model after a reply that fails validation
+var SchemaMaxRetries = 2
+
+// CallLLMJSONSchema is CallLLMJSON with the reply checked against schema, a
+// JSON Schema document, before it is unmarshaled into out. A reply that
+// isn't valid JSON or doesn't match the schema is sent back to the model
+// with the validation errors, up to SchemaMaxRetries times. An invalid
+// schema is an error without calling the model.
+func CallLLMJSONSchema(ctx context.Context, prompt, schema string, out any, config *LLMConfig) error {
+	compiled, err := jsonschema.CompileString("llm-output.schema.json", schema)
+	if err != nil {
+		return fmt.Errorf("invalid JSON schema: %w", err)
+	}
+
+	// Few-shot examples show a different reply format than the schema's
+	jsonConfig := *config.WithoutFewShot()
+	jsonConfig.JSONMode = true
+
+	messages := []Message{
+		{Role: "system", Content: DefaultSystemPrompt},
+		{Role: "user", Content: fmt.Sprintf("%s\n\nReply with a single JSON object matching this JSON Schema:\n%s", prompt, schema)},
+	}
+
+	var lastErr error
+	for attempt := 0; attempt <= SchemaMaxRetries; attempt++ {
+		content, err := CallLLMConversation(ctx, messages, &jsonConfig)
+		if err != nil {
+			return err
+		}
+
+		problems := validateJSONReply(compiled, content, out)
+		if len(problems) == 0 {
+			return nil
+		}
+		lastErr = fmt.Errorf("model output failed schema validation: %s\nraw content: %s", strings.Join(problems, "; "), content)
+
+		messages = append(messages,
+			Message{Role: "assistant", Content: content},
+			Message{Role: "user", Content: "That reply is invalid:\n- " + strings.Join(problems, "\n- ") +
+				"\n\nReply again with only the corrected JSON object."},
+		)
+	}
+
+	return fmt.Errorf("giving up after %d attempt(s): %w", SchemaMaxRetries+1, lastErr)
+}
+
+// validateJSONReply parses content, validates it against schema, and
+// unmarshals it into out, returning what was wrong if any step fails
+func validateJSONReply(schema *jsonschema.Schema, content string, out any) []string {
+	raw := stripCodeFence(content)
+
+	var doc any
+	if err := json.Unmarshal([]byte(raw), &doc); err != nil {
+		return []string{fmt.Sprintf("not valid JSON: %v", err)}
+	}
+
+	if err := schema.Validate(doc); err != nil {
+		return schemaProblems(err)
+	}
+
+	if err := json.Unmarshal([]byte(raw), out); err != nil {
+		return []string{fmt.Sprintf("does not fit the expected result: %v", err)}
+	}
+	return nil
+}
+
+// schemaProblems flattens a validation error into one line per failed
+// keyword, such as `at /action: value must be one of "search", "answer"`
+func schemaProblems(err error) []string {
+	var validationErr *jsonschema.ValidationError
+	if !errors.As(err, &validationErr) {
+		return []string{err.Error()}
+	}
+
+	var problems []string
+	for _, unit := range validationErr.BasicOutput().Errors {
+		// Skip the summary units that only say a subschema failed
+		if unit.Error == "" || strings.HasPrefix(unit.Error, "doesn't validate with") {
+			continue
+		}
+		location := unit.InstanceLocation
+		if location == "" {
+			location = "/"
+		}
+		problems = append(problems, fmt.Sprintf("at %s: %s", location, unit.Error))
+	}
+	if len(problems) == 0 {
+		problems = append(problems, validationErr.Error())
+	}
+	return problems
+}
@@ -1,11 +1,20 @@
 package utils
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html"
 	"io"
+	"maps"
 	"net/http"
 	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
 	"time"
 )
 
@@ -17,6 +26,58 @@ type SearchResult struct {
 	Description string `json:"description"`
 }
 
+// Searcher is implemented by each web search backend
+type Searcher interface {
+	Search(ctx context.Context, query string) ([]SearchResult, error)
+}
+
+// SearcherFromEnv returns the searcher named by SEARCH_PROVIDER ("duckduckgo",
+// "brave", or "google"), defaulting to the offline mock searcher when it's
+// unset. An unrecognized name is an error, so a typo can't pass canned
+// results off as web results.
+func SearcherFromEnv() (Searcher, error) {
+	searcher, err := SearcherByName(os.Getenv("SEARCH_PROVIDER"))
+	if err != nil {
+		return nil, fmt.Errorf("SEARCH_PROVIDER: %w", err)
+	}
+	return searcher, nil
+}
+
+// SearcherByName returns the searcher for name ("mock", "duckduckgo",
+// "brave", or "google"). An empty name selects the mock searcher.
+func SearcherByName(name string) (Searcher, error) {
+	switch strings.ToLower(name) {
+	case "", "mock":
+		return MockSearcher{}, nil
+	case "duckduckgo", "ddg":
+		return &DuckDuckGoSearcher{}, nil
+	case "brave":
+		return &BraveSearcher{}, nil
+	case "google":
+		return &GoogleCSESearcher{}, nil
+	default:
+		return nil, fmt.Errorf("unknown search provider %q", name)
+	}
+}
+
+// MockSearcher returns canned results from SearchWeb without network access
+type MockSearcher struct{}
+
+// Search implements Searcher
+func (MockSearcher) Search(ctx context.Context, query string) ([]SearchResult, error) {
+	return SearchWeb(query)
+}
+
+// DuckDuckGoSearcher searches using the DuckDuckGo Instant Answer API
+type DuckDuckGoSearcher struct {
+	Options SearchOptions
+}
+
+// Search implements Searcher
+func (s *DuckDuckGoSearcher) Search(ctx context.Context, query string) ([]SearchResult, error) {
+	return searchDuckDuckGo(ctx, query, s.Options)
+}
+
 // SearchWeb performs a web search using DuckDuckGo API
 // In production, you might want to use a proper search API like Brave Search or Google Custom Search
 func SearchWeb(query string) ([]SearchResult, error) {
@@ -47,25 +108,98 @@ func SearchWeb(query string) ([]SearchResult, error) {
 	return results, nil
 }
 
+// SearchOptions controls optional search behavior
+type SearchOptions struct {
+	// HTMLFallback scrapes html.duckduckgo.com when the Instant Answer API
+	// returns nothing. The HTML endpoint is rate limited aggressively, so
+	// only enable this for low-volume use.
+	HTMLFallback bool
+
+	// Limit caps the number of results returned; zero means no limit
+	Limit int
+
+	// Offset skips this many results, for paging; backends without native
+	// paging fetch and slice
+	Offset int
+}
+
+// validate checks that paging options are usable
+func (o SearchOptions) validate() error {
+	if o.Limit < 0 {
+		return fmt.Errorf("search limit must be positive, got %d", o.Limit)
+	}
+	if o.Offset < 0 {
+		return fmt.Errorf("search offset must not be negative, got %d", o.Offset)
+	}
+	return nil
+}
+
+// paginate applies offset and limit to an already-fetched result list.
+// Fewer than limit results are returned without error if the list is short.
+func paginate(results []SearchResult, offset, limit int) []SearchResult {
+	if offset >= len(results) {
+		return []SearchResult{}
+	}
+	results = results[offset:]
+	if limit > 0 && limit < len(results) {
+		results = results[:limit]
+	}
+	return results
+}
+
+// SearchWebN performs a web search and returns at most limit results
+func SearchWebN(query string, limit int) ([]SearchResult, error) {
+	return SearchWebPage(query, 0, limit)
+}
+
+// SearchWebPage performs a web search and returns up to limit results
+// starting at offset
+func SearchWebPage(query string, offset, limit int) ([]SearchResult, error) {
+	if limit <= 0 {
+		return nil, fmt.Errorf("search limit must be positive, got %d", limit)
+	}
+	opts := SearchOptions{Limit: limit, Offset: offset}
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	results, err := SearchWeb(query)
+	if err != nil {
+		return nil, err
+	}
+	return paginate(results, offset, limit), nil
+}
+
 // SearchWebDuckDuckGo performs a real web search using DuckDuckGo Instant Answer API
 // Note: This API is limited and may not return results for all queries
 func SearchWebDuckDuckGo(query string) ([]SearchResult, error) {
-	apiURL := fmt.Sprintf("https://api.duckduckgo.com/?q=%s&format=json&no_html=1&skip_disambig=1",
-		url.QueryEscape(query))
+	return SearchWebDuckDuckGoWithOptions(query, SearchOptions{})
+}
 
-	client := &http.Client{
-		Timeout: 10 * time.Second,
-	}
+// SearchWebDuckDuckGoWithOptions performs a DuckDuckGo search with custom options
+func SearchWebDuckDuckGoWithOptions(query string, opts SearchOptions) ([]SearchResult, error) {
+	return searchDuckDuckGo(context.Background(), query, opts)
+}
 
-	resp, err := client.Get(apiURL)
-	if err != nil {
-		return nil, fmt.Errorf("failed to search: %w", err)
+// DuckDuckGo endpoints, variables so tests can point them at an
+// httptest.Server
+var (
+	DuckDuckGoAPIURL  = "https://api.duckduckgo.com/"
+	DuckDuckGoHTMLURL = "https://html.duckduckgo.com/html/"
+)
+
+// searchDuckDuckGo queries the Instant Answer API, falling back to HTML if enabled
+func searchDuckDuckGo(ctx context.Context, query string, opts SearchOptions) ([]SearchResult, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	apiURL := fmt.Sprintf("%s?q=%s&format=json&no_html=1&skip_disambig=1",
+		DuckDuckGoAPIURL, url.QueryEscape(query))
+
+	body, err := searchGet(ctx, query, apiURL, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
+		return nil, err
 	}
 
 	// Parse DuckDuckGo response
@@ -81,8 +215,16 @@ func SearchWebDuckDuckGo(query string) ([]SearchResult, error) {
 		} `json:"RelatedTopics"`
 	}
 
+	if err := validateDuckDuckGoResponse(body); err != nil {
+		return nil, &SearchError{Kind: SearchErrorInvalidResponse, Status: http.StatusOK, Query: query, Err: err}
+	}
 	if err := json.Unmarshal(body, &ddgResponse); err != nil {
-		return nil, fmt.Errorf("failed to parse response: %w", err)
+		return nil, &SearchError{
+			Kind:   SearchErrorInvalidResponse,
+			Status: http.StatusOK,
+			Query:  query,
+			Err:    fmt.Errorf("failed to parse response: %w; body: %s", err, bodySnippet(body)),
+		}
 	}
 
 	var results []SearchResult
@@ -109,9 +251,407 @@ func SearchWebDuckDuckGo(query string) ([]SearchResult, error) {
 		}
 	}
 
+	if len(results) == 0 && opts.HTMLFallback {
+		results, err = searchDuckDuckGoHTML(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return paginate(results, opts.Offset, opts.Limit), nil
+}
+
+// ddgResponseFields are the fields every Instant Answer API reply has,
+// even when it found nothing
+var ddgResponseFields = []string{"Abstract", "AbstractText", "AbstractURL", "Heading", "RelatedTopics", "Results", "Type"}
+
+// validateDuckDuckGoResponse checks that body looks like an Instant Answer
+// API reply, so a changed API or an error page fails loudly instead of
+// reading as no results. A reply with the expected fields but nothing in
+// them is valid.
+func validateDuckDuckGoResponse(body []byte) error {
+	trimmed := bytes.TrimSpace(body)
+	switch {
+	case len(trimmed) == 0:
+		return errors.New("empty response body")
+	case trimmed[0] == '<':
+		return fmt.Errorf("got HTML instead of JSON: %s", bodySnippet(trimmed))
+	case trimmed[0] != '{':
+		return fmt.Errorf("response is not a JSON object: %s", bodySnippet(trimmed))
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(trimmed, &fields); err != nil {
+		return fmt.Errorf("failed to parse response: %w; body: %s", err, bodySnippet(trimmed))
+	}
+
+	for _, name := range ddgResponseFields {
+		if _, ok := fields[name]; ok {
+			return nil
+		}
+	}
+
+	// An error object such as {"error": "..."} in place of a reply
+	for _, name := range []string{"error", "Error", "message", "Message"} {
+		if raw, ok := fields[name]; ok {
+			return fmt.Errorf("API returned an error: %s", bodySnippet(raw))
+		}
+	}
+	return fmt.Errorf("unexpected response shape (fields: %s): %s",
+		strings.Join(slices.Sorted(maps.Keys(fields)), ", "), bodySnippet(trimmed))
+}
+
+var (
+	ddgResultLinkRe    = regexp.MustCompile(`(?s)<a[^>]*class="result__a"[^>]*>.*?</a>`)
+	ddgResultSnippetRe = regexp.MustCompile(`(?s)class="result__snippet"[^>]*>(.*?)</(?:a|div|td)>`)
+	hrefRe             = regexp.MustCompile(`href="([^"]*)"`)
+	tagRe              = regexp.MustCompile(`(?s)<[^>]*>`)
+)
+
+// searchDuckDuckGoHTML scrapes results from the DuckDuckGo HTML endpoint
+func searchDuckDuckGoHTML(ctx context.Context, query string) ([]SearchResult, error) {
+	form := url.Values{"q": {query}}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", DuckDuckGoHTMLURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	// Not retried, see the 202 handling below
+	client := NewHTTPClient(SearchTimeout, 0)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, requestError(query, err)
+	}
+	defer resp.Body.Close()
+
+	// DuckDuckGo answers 202 with a challenge page when it suspects
+	// automation. The HTML endpoint isn't retried, since hammering it only
+	// prolongs the block.
+	if resp.StatusCode == http.StatusAccepted || resp.StatusCode == http.StatusForbidden {
+		return nil, &SearchError{
+			Kind:   SearchErrorRateLimited,
+			Status: resp.StatusCode,
+			Query:  query,
+			Err:    errors.New("DuckDuckGo HTML search is likely being rate limited or blocked, slow down and retry later"),
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, newStatusError(query, resp.StatusCode, body)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	page := string(body)
+	links := ddgResultLinkRe.FindAllString(page, -1)
+	snippets := ddgResultSnippetRe.FindAllStringSubmatch(page, -1)
+
+	var results []SearchResult
+	for i, link := range links {
+		href := ""
+		if m := hrefRe.FindStringSubmatch(link); m != nil {
+			href = resolveDuckDuckGoLink(html.UnescapeString(m[1]))
+		}
+
+		result := SearchResult{
+			Title: stripTags(link),
+			URL:   href,
+		}
+		if i < len(snippets) {
+			result.Snippet = stripTags(snippets[i][1])
+			result.Description = result.Snippet
+		}
+		results = append(results, result)
+	}
+
 	return results, nil
 }
 
+// SearchErrorKind classifies why a search request failed
+type SearchErrorKind int
+
+const (
+	// SearchErrorNetwork means no HTTP response was received
+	SearchErrorNetwork SearchErrorKind = iota
+
+	// SearchErrorRateLimited means the backend answered 429 or otherwise
+	// signalled that requests are being throttled
+	SearchErrorRateLimited
+
+	// SearchErrorServer means the backend answered with a 5xx status
+	SearchErrorServer
+
+	// SearchErrorClient means the request itself was rejected (other 4xx
+	// statuses, e.g. a bad API key); retrying won't help
+	SearchErrorClient
+
+	// SearchErrorInvalidResponse means the backend answered 200 with a body
+	// that isn't the expected JSON, such as an HTML error page
+	SearchErrorInvalidResponse
+)
+
+// String implements fmt.Stringer
+func (k SearchErrorKind) String() string {
+	switch k {
+	case SearchErrorNetwork:
+		return "network error"
+	case SearchErrorRateLimited:
+		return "rate limited"
+	case SearchErrorServer:
+		return "server error"
+	case SearchErrorClient:
+		return "client error"
+	case SearchErrorInvalidResponse:
+		return "invalid response"
+	default:
+		return fmt.Sprintf("SearchErrorKind(%d)", int(k))
+	}
+}
+
+// SearchError is returned by the HTTP search backends when a request fails.
+// Use errors.As to inspect it, for example to fall back to MockSearcher
+// when a backend is rate limited:
+//
+//	var searchErr *utils.SearchError
+//	if errors.As(err, &searchErr) && searchErr.Temporary() {
+//		results, err = utils.MockSearcher{}.Search(ctx, query)
+//	}
+type SearchError struct {
+	Kind SearchErrorKind
+
+	// Status is the HTTP status code, or 0 for network errors
+	Status int
+
+	Query string
+
+	// Err describes the failure: the transport error or the response body
+	Err error
+}
+
+// Error implements error
+func (e *SearchError) Error() string {
+	if e.Status == 0 {
+		return fmt.Sprintf("search for %q failed (%s): %v", e.Query, e.Kind, e.Err)
+	}
+	return fmt.Sprintf("search for %q failed with status %d (%s): %v", e.Query, e.Status, e.Kind, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *SearchError) Unwrap() error {
+	return e.Err
+}
+
+// Temporary reports whether the request may succeed if retried later
+func (e *SearchError) Temporary() bool {
+	return e.Kind != SearchErrorClient
+}
+
+// newStatusError builds a SearchError for a non-200 response
+func newStatusError(query string, status int, body []byte) *SearchError {
+	kind := SearchErrorClient
+	switch {
+	case status == http.StatusTooManyRequests:
+		kind = SearchErrorRateLimited
+	case status >= 500:
+		kind = SearchErrorServer
+	}
+
+	message := bodySnippet(body)
+	if message == "" {
+		message = http.StatusText(status)
+	}
+
+	return &SearchError{Kind: kind, Status: status, Query: query, Err: errors.New(message)}
+}
+
+// requestError classifies an HTTPClient.Do error: a status that was still
+// failing after retries keeps its kind, anything else is a network error
+func requestError(query string, err error) *SearchError {
+	var statusErr *RetryStatusError
+	if errors.As(err, &statusErr) {
+		searchErr := newStatusError(query, statusErr.StatusCode, statusErr.Body)
+		searchErr.Err = err
+		return searchErr
+	}
+	return &SearchError{Kind: SearchErrorNetwork, Query: query, Err: err}
+}
+
+// bodySnippet trims a response body for an error message. Error pages can
+// be large; this keeps enough to debug with.
+func bodySnippet(body []byte) string {
+	const maxBody = 512
+	message := strings.TrimSpace(string(body))
+	if len(message) > maxBody {
+		message = strings.ToValidUTF8(message[:maxBody], "") + "..."
+	}
+	return message
+}
+
+// Search retry policy for transient failures (network errors, 429, and
+// 5xx responses). Retries back off exponentially with jitter, or wait as
+// long as a Retry-After header asks.
+var (
+	SearchMaxRetries  = 2
+	SearchBaseBackoff = 500 * time.Millisecond
+
+	// SearchTimeout bounds each search request attempt
+	SearchTimeout = 10 * time.Second
+)
+
+// searchGet performs a GET request for a search API and returns the body.
+// Transient failures are retried; failures are returned as *SearchError.
+func searchGet(ctx context.Context, query, apiURL string, headers map[string]string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	client := &HTTPClient{
+		Timeout:     SearchTimeout,
+		MaxRetries:  SearchMaxRetries,
+		BaseBackoff: SearchBaseBackoff,
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		return nil, requestError(query, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newStatusError(query, resp.StatusCode, body)
+	}
+
+	return body, nil
+}
+
+// resolveDuckDuckGoLink unwraps DuckDuckGo's /l/?uddg= redirect links
+func resolveDuckDuckGoLink(href string) string {
+	if strings.HasPrefix(href, "//") {
+		href = "https:" + href
+	}
+	parsed, err := url.Parse(href)
+	if err != nil {
+		return href
+	}
+	if target := parsed.Query().Get("uddg"); target != "" {
+		return target
+	}
+	return href
+}
+
+// stripTags removes HTML tags and decodes entities
+func stripTags(s string) string {
+	return strings.TrimSpace(html.UnescapeString(tagRe.ReplaceAllString(s, "")))
+}
+
+// DedupeResults drops results whose snippet embedding is more similar than
+// threshold to a result already kept. Original order is preserved, and a
+// result whose embedding fails is kept rather than compared.
+func DedupeResults(results []SearchResult, threshold float64) ([]SearchResult, error) {
+	if len(results) < 2 {
+		return results, nil
+	}
+
+	ctx := context.Background()
+	snippets := make([]string, len(results))
+	for i, result := range results {
+		snippets[i] = result.Snippet
+	}
+
+	// Embed in one request; if that fails, retry per item so one bad input
+	// doesn't prevent deduplicating the rest
+	embeddings, err := GetEmbeddings(ctx, snippets)
+	if err != nil {
+		embeddings = make([][]float32, len(results))
+		for i, snippet := range snippets {
+			if embedding, err := GetEmbedding(ctx, snippet); err == nil {
+				embeddings[i] = embedding
+			}
+		}
+	}
+
+	var kept []SearchResult
+	var keptEmbeddings [][]float32
+
+	for i, result := range results {
+		embedding := embeddings[i]
+		if embedding == nil {
+			kept = append(kept, result)
+			continue
+		}
+
+		duplicate := false
+		for _, other := range keptEmbeddings {
+			if len(other) != len(embedding) {
+				return nil, fmt.Errorf("embedding length mismatch: %d vs %d", len(other), len(embedding))
+			}
+			if CosineSimilarity(embedding, other) > threshold {
+				duplicate = true
+				break
+			}
+		}
+
+		if !duplicate {
+			kept = append(kept, result)
+			keptEmbeddings = append(keptEmbeddings, embedding)
+		}
+	}
+
+	return kept, nil
+}
+
+// MergeResults interleaves several result lists round-robin (the first
+// result of each list, then the second of each, and so on) so no single
+// list dominates the top, dropping results whose URL was already seen.
+// Results without a URL are always kept.
+func MergeResults(lists ...[]SearchResult) []SearchResult {
+	longest := 0
+	for _, list := range lists {
+		longest = max(longest, len(list))
+	}
+
+	merged := []SearchResult{}
+	seen := make(map[string]bool)
+	for i := 0; i < longest; i++ {
+		for _, list := range lists {
+			if i >= len(list) {
+				continue
+			}
+			result := list[i]
+			if key := strings.TrimSuffix(result.URL, "/"); key != "" {
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+			}
+			merged = append(merged, result)
+		}
+	}
+
+	return merged
+}
+
 // FormatSearchResults formats search results into a string
 func FormatSearchResults(results []SearchResult) string {
 	if len(results) == 0 {
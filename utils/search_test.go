@@ -0,0 +1,123 @@
+package utils
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSearchWebDuckDuckGo(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if q := r.URL.Query().Get("q"); q != "golang" || r.URL.Query().Get("format") != "json" {
+			http.Error(w, "unexpected query "+r.URL.RawQuery, http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{
+			"Heading": "Go",
+			"Type": "A",
+			"Abstract": "Go is a programming language.",
+			"AbstractText": "Go is a programming language designed at Google.",
+			"AbstractSource": "Wikipedia",
+			"AbstractURL": "https://en.wikipedia.org/wiki/Go_(programming_language)",
+			"Results": [],
+			"RelatedTopics": [
+				{"Text": "Gopher - the Go mascot", "FirstURL": "https://duckduckgo.com/Gopher"},
+				{"Name": "Category", "Topics": []}
+			]
+		}`)
+	}))
+	defer server.Close()
+
+	previous := DuckDuckGoAPIURL
+	DuckDuckGoAPIURL = server.URL + "/"
+	defer func() { DuckDuckGoAPIURL = previous }()
+
+	results, err := SearchWebDuckDuckGo("golang")
+	if err != nil {
+		t.Fatalf("SearchWebDuckDuckGo() error = %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want 2: %+v", len(results), results)
+	}
+	if results[0].Title != "Wikipedia" || results[0].URL != "https://en.wikipedia.org/wiki/Go_(programming_language)" {
+		t.Errorf("results[0] = %+v, want the abstract", results[0])
+	}
+	if results[1].URL != "https://duckduckgo.com/Gopher" || results[1].Snippet != "Gopher - the Go mascot" {
+		t.Errorf("results[1] = %+v, want the related topic", results[1])
+	}
+}
+
+// roundTripFunc is an http.RoundTripper for HTTPTransport stubs
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestSearchWebDuckDuckGoThroughHTTPTransport(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		wantResults int
+		wantErr     bool
+	}{
+		{name: "no results", body: `{"Abstract": "", "AbstractText": "", "AbstractURL": "", "Heading": "", "RelatedTopics": [], "Results": [], "Type": ""}`},
+		{name: "HTML page", body: "<!DOCTYPE html><html><body>Blocked</body></html>", wantErr: true},
+		{name: "empty body", body: "", wantErr: true},
+		{name: "error object", body: `{"error": "rate limited"}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requested string
+			previous := HTTPTransport
+			HTTPTransport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				requested = req.URL.String()
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Header:     http.Header{"Content-Type": {"application/json"}},
+					Body:       io.NopCloser(strings.NewReader(tt.body)),
+					Request:    req,
+				}, nil
+			})
+			defer func() { HTTPTransport = previous }()
+
+			results, err := SearchWebDuckDuckGo("go concurrency")
+			if !strings.HasPrefix(requested, DuckDuckGoAPIURL) || !strings.Contains(requested, "q=go+concurrency") {
+				t.Errorf("requested %q, want the DuckDuckGo API with the query", requested)
+			}
+
+			if tt.wantErr {
+				var searchErr *SearchError
+				if !errors.As(err, &searchErr) || searchErr.Kind != SearchErrorInvalidResponse {
+					t.Fatalf("SearchWebDuckDuckGo() error = %v, want an invalid response SearchError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SearchWebDuckDuckGo() error = %v", err)
+			}
+			if len(results) != tt.wantResults {
+				t.Errorf("got %d results, want %d", len(results), tt.wantResults)
+			}
+		})
+	}
+}
+
+func TestSearcherFromEnv(t *testing.T) {
+	t.Setenv("SEARCH_PROVIDER", "")
+	if searcher, err := SearcherFromEnv(); err != nil {
+		t.Errorf("SearcherFromEnv() with SEARCH_PROVIDER unset error = %v", err)
+	} else if _, ok := searcher.(MockSearcher); !ok {
+		t.Errorf("SearcherFromEnv() with SEARCH_PROVIDER unset = %T, want MockSearcher", searcher)
+	}
+
+	t.Setenv("SEARCH_PROVIDER", "duckduckgoo")
+	if searcher, err := SearcherFromEnv(); err == nil || !strings.Contains(err.Error(), "duckduckgoo") {
+		t.Errorf("SearcherFromEnv() with a typo = %T, %v; want an error naming the provider", searcher, err)
+	}
+}
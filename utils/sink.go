@@ -0,0 +1,226 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ResultSink persists flow outputs by key. Write may buffer; nothing is
+// guaranteed to be stored until Flush returns. Implementations are safe
+// for concurrent use.
+type ResultSink interface {
+	Write(ctx context.Context, key string, value any) error
+	Flush() error
+}
+
+// SinkEntry is one value written to a sink
+type SinkEntry struct {
+	Key       string          `json:"key"`
+	Value     json.RawMessage `json:"value"`
+	WrittenAt time.Time       `json:"written_at"`
+}
+
+// newSinkEntry encodes value as JSON so later changes to it aren't stored
+func newSinkEntry(key string, value any) (SinkEntry, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return SinkEntry{}, fmt.Errorf("failed to encode %s: %w", key, err)
+	}
+	return SinkEntry{Key: key, Value: data, WrittenAt: time.Now().UTC()}, nil
+}
+
+// FlushSinks flushes every sink, returning all of their errors
+func FlushSinks(sinks ...ResultSink) error {
+	var errs []error
+	for _, sink := range sinks {
+		if err := sink.Flush(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// MemorySink keeps the latest value written for each key in memory
+type MemorySink struct {
+	mu     sync.Mutex
+	values map[string]any
+}
+
+// NewMemorySink returns an empty MemorySink
+func NewMemorySink() *MemorySink {
+	return &MemorySink{values: make(map[string]any)}
+}
+
+// Write implements ResultSink
+func (s *MemorySink) Write(ctx context.Context, key string, value any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = value
+	return nil
+}
+
+// Flush implements ResultSink; values are stored as soon as they're written
+func (s *MemorySink) Flush() error {
+	return nil
+}
+
+// Get returns the latest value written for key
+func (s *MemorySink) Get(key string) (any, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.values[key]
+	return value, ok
+}
+
+// FileSink appends written values to a file as JSON lines of SinkEntry.
+// Writes are buffered and appended together on Flush.
+type FileSink struct {
+	path    string
+	mu      sync.Mutex
+	pending []SinkEntry
+}
+
+// NewFileSink returns a FileSink that appends to path, creating it and
+// its directory on first Flush
+func NewFileSink(path string) *FileSink {
+	return &FileSink{path: path}
+}
+
+// Write implements ResultSink
+func (s *FileSink) Write(ctx context.Context, key string, value any) error {
+	entry, err := newSinkEntry(key, value)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending = append(s.pending, entry)
+	return nil
+}
+
+// Flush implements ResultSink. Entries that fail to be written stay
+// buffered for the next Flush.
+func (s *FileSink) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.pending) == 0 {
+		return nil
+	}
+
+	var data []byte
+	for _, entry := range s.pending {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return fmt.Errorf("failed to encode %s: %w", entry.Key, err)
+		}
+		data = append(append(data, line...), '\n')
+	}
+
+	if dir := filepath.Dir(s.path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("failed to create %s: %w", dir, err)
+		}
+	}
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", s.path, err)
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write %s: %w", s.path, err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", s.path, err)
+	}
+
+	s.pending = nil
+	return nil
+}
+
+// SQLiteSink stores the latest value for each key in a SQLite table with
+// key, value (JSON), and written_at columns. Writes are buffered and saved
+// in one transaction on Flush.
+type SQLiteSink struct {
+	path    string
+	table   string
+	mu      sync.Mutex
+	pending []SinkEntry
+}
+
+// NewSQLiteSink returns a SQLiteSink that writes to table in the SQLite
+// database at path, creating the table on first Flush
+func NewSQLiteSink(path, table string) (*SQLiteSink, error) {
+	if !sqlIdentifierRe.MatchString(table) {
+		return nil, fmt.Errorf("invalid table name %q", table)
+	}
+	return &SQLiteSink{path: path, table: table}, nil
+}
+
+// Write implements ResultSink
+func (s *SQLiteSink) Write(ctx context.Context, key string, value any) error {
+	entry, err := newSinkEntry(key, value)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending = append(s.pending, entry)
+	return nil
+}
+
+// Flush implements ResultSink. Entries stay buffered if the transaction
+// fails.
+func (s *SQLiteSink) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.pending) == 0 {
+		return nil
+	}
+
+	db, err := openSQLite(s.path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// Flush has no context; a fresh one keeps a cancelled flow from
+	// losing the results it did produce
+	ctx := context.Background()
+	_, err = db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %q (
+	key TEXT PRIMARY KEY,
+	value TEXT NOT NULL,
+	written_at TEXT NOT NULL
+)`, s.table))
+	if err != nil {
+		return fmt.Errorf("failed to create table %s in %s: %w", s.table, s.path, err)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to write to %s: %w", s.table, err)
+	}
+	defer tx.Rollback() // No-op after Commit
+
+	query := fmt.Sprintf(`INSERT OR REPLACE INTO %q (key, value, written_at) VALUES (?, ?, ?)`, s.table)
+	for _, entry := range s.pending {
+		if _, err := tx.ExecContext(ctx, query, entry.Key, string(entry.Value), entry.WrittenAt.Format(time.RFC3339Nano)); err != nil {
+			return fmt.Errorf("failed to write to %s: %w", s.table, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to write to %s: %w", s.table, err)
+	}
+
+	s.pending = nil
+	return nil
+}
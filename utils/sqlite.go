@@ -0,0 +1,180 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+)
+
+// SQLiteDriver is the database/sql driver name OpenResultStore and
+// SQLiteSink use. Builds with cgo register github.com/mattn/go-sqlite3 as
+// "sqlite3" (see sqlite_cgo.go); builds without cgo have no SQLite driver.
+// To use another one, such as the pure Go modernc.org/sqlite, import it and
+// set its name here.
+var SQLiteDriver = "sqlite3"
+
+// openSQLite opens the database at path with SQLiteDriver, explaining a
+// missing driver instead of failing with database/sql's "unknown driver"
+func openSQLite(path string) (*sql.DB, error) {
+	if !slices.Contains(sql.Drivers(), SQLiteDriver) {
+		return nil, fmt.Errorf("SQLite support is not built in: no %q driver is registered "+
+			"(build with CGO_ENABLED=1, or import another driver and set utils.SQLiteDriver)", SQLiteDriver)
+	}
+	db, err := sql.Open(SQLiteDriver, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	return db, nil
+}
+
+// sqlIdentifierRe limits table names to plain identifiers, since they
+// can't be passed as query parameters
+var sqlIdentifierRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ResultRecord is one row of a ResultStore table
+type ResultRecord struct {
+	// ID is the row ID; zero when the record hasn't been stored yet
+	ID     int64
+	Item   string
+	Result string
+	Error  string
+
+	// ProcessedAt is when the item was processed; zero for pending rows
+	ProcessedAt time.Time
+}
+
+// ResultStore keeps batch items and their results in a SQLite table with
+// id, item, result, error, created_at, and processed_at columns. Rows
+// whose processed_at is NULL are pending. Writes are serialized, so a
+// ResultStore is safe for concurrent use by batch workers.
+type ResultStore struct {
+	db    *sql.DB
+	table string
+	mu    sync.Mutex
+}
+
+// OpenResultStore opens the SQLite database at path and creates table if
+// it doesn't exist
+func OpenResultStore(ctx context.Context, path, table string) (*ResultStore, error) {
+	if !sqlIdentifierRe.MatchString(table) {
+		return nil, fmt.Errorf("invalid table name %q", table)
+	}
+
+	db, err := openSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows one writer at a time; a single connection avoids
+	// "database is locked" errors between our own goroutines
+	db.SetMaxOpenConns(1)
+
+	_, err = db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %q (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	item TEXT NOT NULL,
+	result TEXT,
+	error TEXT,
+	created_at TEXT NOT NULL,
+	processed_at TEXT
+)`, table))
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create table %s in %s: %w", table, path, err)
+	}
+
+	return &ResultStore{db: db, table: table}, nil
+}
+
+// Close closes the database
+func (s *ResultStore) Close() error {
+	return s.db.Close()
+}
+
+// AddItems stores items as pending rows
+func (s *ResultStore) AddItems(ctx context.Context, items []string) error {
+	records := make([]ResultRecord, len(items))
+	for i, item := range items {
+		records[i].Item = item
+	}
+	return s.Save(ctx, records)
+}
+
+// Pending returns the rows that haven't been processed yet, oldest first
+func (s *ResultStore) Pending(ctx context.Context) ([]ResultRecord, error) {
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`SELECT id, item FROM %q WHERE processed_at IS NULL ORDER BY id`, s.table))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pending rows from %s: %w", s.table, err)
+	}
+	defer rows.Close()
+
+	var records []ResultRecord
+	for rows.Next() {
+		var record ResultRecord
+		if err := rows.Scan(&record.ID, &record.Item); err != nil {
+			return nil, fmt.Errorf("failed to read pending rows from %s: %w", s.table, err)
+		}
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read pending rows from %s: %w", s.table, err)
+	}
+
+	return records, nil
+}
+
+// Save writes records in a single transaction. Records with an ID update
+// that row; the rest are inserted. A record is stored as pending unless
+// ProcessedAt is set.
+func (s *ResultStore) Save(ctx context.Context, records []ResultRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to write to %s: %w", s.table, err)
+	}
+	defer tx.Rollback() // No-op after Commit
+
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	for _, record := range records {
+		var processedAt any
+		if !record.ProcessedAt.IsZero() {
+			processedAt = record.ProcessedAt.UTC().Format(time.RFC3339Nano)
+		}
+
+		var res sql.Result
+		if record.ID > 0 {
+			res, err = tx.ExecContext(ctx,
+				fmt.Sprintf(`UPDATE %q SET result = ?, error = ?, processed_at = ? WHERE id = ?`, s.table),
+				nullString(record.Result), nullString(record.Error), processedAt, record.ID)
+		} else {
+			res, err = tx.ExecContext(ctx,
+				fmt.Sprintf(`INSERT INTO %q (item, result, error, created_at, processed_at) VALUES (?, ?, ?, ?, ?)`, s.table),
+				record.Item, nullString(record.Result), nullString(record.Error), now, processedAt)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write to %s: %w", s.table, err)
+		}
+		if record.ID > 0 {
+			if n, err := res.RowsAffected(); err == nil && n == 0 {
+				return fmt.Errorf("failed to write to %s: no row with id %d", s.table, record.ID)
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to write to %s: %w", s.table, err)
+	}
+	return nil
+}
+
+// nullString stores empty strings as NULL
+func nullString(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
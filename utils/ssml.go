@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"encoding/xml"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// ssmlListNumberRe matches the number SplitSentences cuts off the front
+// of an ordered list item, or of any line starting with one
+var ssmlListNumberRe = regexp.MustCompile(`^\d+\.$`)
+
+// SSMLSentenceBreak is the pause TextToSSML inserts between sentences
+var SSMLSentenceBreak = "400ms"
+
+// TextToSSML converts an answer to SSML for a speech synthesizer. Markdown
+// and HTML are stripped first, each sentence (and each heading or list
+// item) is followed by a <break> unless it is the last, and XML-special
+// characters are escaped. Sentences with nothing to pronounce, such as
+// bare punctuation, are dropped, so empty input gives "<speak></speak>".
+func TextToSSML(text string) string {
+	plain := StripHTML(StripMarkdown(text))
+
+	var sentences []string
+	for _, line := range strings.Split(plain, "\n") {
+		number := ""
+		for i, sentence := range SplitSentences(line) {
+			if i == 0 && ssmlListNumberRe.MatchString(sentence) {
+				// Keep a list item's number with its text
+				number = sentence + " "
+				continue
+			}
+			if strings.IndexFunc(sentence, isSpeakable) >= 0 {
+				sentences = append(sentences, number+sentence)
+			}
+			number = ""
+		}
+		if number != "" {
+			sentences = append(sentences, strings.TrimSpace(number))
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("<speak>")
+	for i, sentence := range sentences {
+		if i > 0 {
+			b.WriteString(`<break time="` + SSMLSentenceBreak + `"/>`)
+		}
+		// EscapeText also replaces characters XML doesn't allow
+		xml.EscapeText(&b, []byte(sentence))
+	}
+	b.WriteString("</speak>")
+	return b.String()
+}
+
+// isSpeakable reports whether r is something a synthesizer reads aloud
+func isSpeakable(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsNumber(r)
+}
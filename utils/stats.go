@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"time"
+	"unicode/utf8"
+)
+
+// ReadingWordsPerMinute is the silent reading speed TextStats assumes for
+// ReadingTime, a typical adult average for English prose
+var ReadingWordsPerMinute = 238
+
+// TextStatistics summarizes a text's size and vocabulary
+type TextStatistics struct {
+	Words     int `json:"words"`
+	Sentences int `json:"sentences"`
+
+	// UniqueWords counts distinct words, ignoring case
+	UniqueWords int `json:"unique_words"`
+
+	// AverageWordLength is in characters, excluding surrounding punctuation
+	AverageWordLength float64 `json:"average_word_length"`
+
+	// ReadingTime estimates how long the text takes to read, rounded to
+	// the second
+	ReadingTime time.Duration `json:"reading_time"`
+}
+
+// TextStats computes word, sentence, and unique word counts, the average
+// word length, and an estimated reading time for text. Words are the
+// tokens of TokenizeText and sentences those of SplitSentences. Empty or
+// blank text yields zeroed statistics.
+func TextStats(text string) TextStatistics {
+	words := TokenizeText(text)
+	if len(words) == 0 {
+		return TextStatistics{}
+	}
+
+	unique := make(map[string]struct{}, len(words))
+	characters := 0
+	for _, word := range words {
+		unique[word] = struct{}{}
+		characters += utf8.RuneCountInString(word)
+	}
+
+	reading := time.Duration(float64(len(words)) / float64(max(ReadingWordsPerMinute, 1)) * float64(time.Minute))
+
+	return TextStatistics{
+		Words:             len(words),
+		Sentences:         len(SplitSentences(text)),
+		UniqueWords:       len(unique),
+		AverageWordLength: math.Round(float64(characters)/float64(len(words))*100) / 100,
+		ReadingTime:       max(reading.Round(time.Second), time.Second),
+	}
+}
+
+// String formats the statistics on one line, as OpStats returns them
+func (s TextStatistics) String() string {
+	return fmt.Sprintf("words: %d, sentences: %d, unique words: %d, average word length: %.2f, reading time: %s",
+		s.Words, s.Sentences, s.UniqueWords, s.AverageWordLength, s.ReadingTime)
+}
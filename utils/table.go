@@ -0,0 +1,143 @@
+package utils
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// TableMaxCellWidth is the widest a FormatTable column may grow, in
+// characters; longer cell lines are wrapped at word boundaries
+var TableMaxCellWidth = 60
+
+// FormatTable renders headers and rows as a plain-text table with columns
+// padded to their widest cell, wrapping cell lines longer than
+// TableMaxCellWidth. Cells may contain newlines; short rows are padded
+// with empty cells. With no headers and no rows it returns "".
+func FormatTable(headers []string, rows [][]string) string {
+	return FormatTableWidth(headers, rows, TableMaxCellWidth)
+}
+
+// FormatTableWidth is FormatTable with an explicit maximum column width;
+// zero or less disables wrapping
+func FormatTableWidth(headers []string, rows [][]string, maxWidth int) string {
+	columns := len(headers)
+	for _, row := range rows {
+		columns = max(columns, len(row))
+	}
+	if columns == 0 {
+		return ""
+	}
+
+	// Split every cell into the lines it will be drawn with
+	split := func(row []string) [][]string {
+		cells := make([][]string, columns)
+		for i := range cells {
+			var cell string
+			if i < len(row) {
+				cell = row[i]
+			}
+			cells[i] = cellLines(cell, maxWidth)
+		}
+		return cells
+	}
+	header := split(headers)
+	body := make([][][]string, len(rows))
+	for i, row := range rows {
+		body[i] = split(row)
+	}
+
+	widths := make([]int, columns)
+	for _, row := range append([][][]string{header}, body...) {
+		for i, lines := range row {
+			for _, line := range lines {
+				widths[i] = max(widths[i], utf8.RuneCountInString(line))
+			}
+		}
+	}
+
+	var b strings.Builder
+	border := func() {
+		b.WriteByte('+')
+		for _, width := range widths {
+			b.WriteString(strings.Repeat("-", width+2))
+			b.WriteByte('+')
+		}
+		b.WriteByte('\n')
+	}
+	writeRow := func(row [][]string) {
+		height := 1
+		for _, lines := range row {
+			height = max(height, len(lines))
+		}
+		for l := 0; l < height; l++ {
+			b.WriteByte('|')
+			for i, lines := range row {
+				var line string
+				if l < len(lines) {
+					line = lines[l]
+				}
+				b.WriteString(" " + line + strings.Repeat(" ", widths[i]-utf8.RuneCountInString(line)) + " |")
+			}
+			b.WriteByte('\n')
+		}
+	}
+
+	border()
+	if len(headers) > 0 {
+		writeRow(header)
+		border()
+	}
+	for _, row := range body {
+		writeRow(row)
+	}
+	if len(body) > 0 {
+		border()
+	}
+
+	return b.String()
+}
+
+// cellLines splits a cell on newlines and wraps each line to maxWidth
+func cellLines(cell string, maxWidth int) []string {
+	cell = strings.ReplaceAll(cell, "\r\n", "\n")
+	cell = strings.ReplaceAll(cell, "\t", "    ")
+
+	var lines []string
+	for _, line := range strings.Split(cell, "\n") {
+		lines = append(lines, wrapLine(strings.TrimRight(line, " "), maxWidth)...)
+	}
+	return lines
+}
+
+// wrapLine breaks line into pieces of at most maxWidth characters at
+// spaces, splitting words that are longer than maxWidth
+func wrapLine(line string, maxWidth int) []string {
+	if maxWidth <= 0 || utf8.RuneCountInString(line) <= maxWidth {
+		return []string{line}
+	}
+
+	var lines []string
+	var current []rune
+	for _, word := range strings.Fields(line) {
+		runes := []rune(word)
+		if len(current) > 0 && len(current)+1+len(runes) > maxWidth {
+			lines = append(lines, string(current))
+			current = nil
+		}
+		for len(runes) > maxWidth-len(current) && len(current) == 0 {
+			lines = append(lines, string(runes[:maxWidth]))
+			runes = runes[maxWidth:]
+		}
+		if len(runes) == 0 {
+			continue
+		}
+		if len(current) > 0 {
+			current = append(current, ' ')
+		}
+		current = append(current, runes...)
+	}
+	if len(current) > 0 || len(lines) == 0 {
+		lines = append(lines, string(current))
+	}
+	return lines
+}
@@ -0,0 +1,42 @@
+package utils
+
+import (
+	"sort"
+	"strings"
+)
+
+// AnswerCount is one distinct answer and how many times it was given
+type AnswerCount struct {
+	Answer string
+	Count  int
+}
+
+// NormalizeAnswer lowercases answer, collapses whitespace, and trims
+// surrounding punctuation, so replies that differ only in formatting
+// compare equal
+func NormalizeAnswer(answer string) string {
+	normalized := strings.Join(strings.Fields(strings.ToLower(answer)), " ")
+	return strings.Trim(normalized, " .,;:!?\"'`*")
+}
+
+// TallyAnswers groups answers that are identical after NormalizeAnswer,
+// most common first, with ties in order of first appearance. Each group
+// keeps the first original answer given.
+func TallyAnswers(answers []string) []AnswerCount {
+	index := map[string]int{}
+	var tally []AnswerCount
+	for _, answer := range answers {
+		key := NormalizeAnswer(answer)
+		if i, ok := index[key]; ok {
+			tally[i].Count++
+			continue
+		}
+		index[key] = len(tally)
+		tally = append(tally, AnswerCount{Answer: answer, Count: 1})
+	}
+
+	sort.SliceStable(tally, func(i, j int) bool {
+		return tally[i].Count > tally[j].Count
+	})
+	return tally
+}
@@ -1,9 +1,17 @@
 package utils
 
 import (
+	"errors"
 	"fmt"
+	"regexp"
+	"sort"
 	"strings"
+	"sync"
 	"unicode"
+	"unicode/utf8"
+
+	"github.com/pkoukk/tiktoken-go"
+	"golang.org/x/net/html"
 )
 
 // TextOperation represents different text processing operations
@@ -14,6 +22,15 @@ const (
 	OpExtract   TextOperation = "extract"
 	OpClean     TextOperation = "clean"
 	OpTokenize  TextOperation = "tokenize"
+
+	// OpDetectLanguage returns the ISO 639-1 code of the text, or "und"
+	OpDetectLanguage TextOperation = "detect_language"
+
+	// OpKeywords returns the most frequent non-stopword terms, comma separated
+	OpKeywords TextOperation = "keywords"
+
+	// OpStats returns the text's TextStatistics formatted on one line
+	OpStats TextOperation = "stats"
 )
 
 // ProcessText performs various text processing operations
@@ -28,22 +45,62 @@ func ProcessText(text string, operation TextOperation) (string, error) {
 	case OpTokenize:
 		tokens := TokenizeText(text)
 		return strings.Join(tokens, " "), nil
+	case OpDetectLanguage:
+		return DetectLanguage(text)
+	case OpKeywords:
+		return strings.Join(ExtractKeywords(text, defaultKeywordCount), ", "), nil
+	case OpStats:
+		return TextStats(text).String(), nil
 	default:
 		return "", fmt.Errorf("unknown operation: %s", operation)
 	}
 }
 
-// SummarizeText creates a summary of the input text
-// In a real implementation, this would use an LLM
+// summarizeThreshold is the length below which text is returned unchanged
+const summarizeThreshold = 100
+
+// SummarizeText creates a summary of the input text using the LLM.
+// Falls back to truncation when no LLM API key is configured.
 func SummarizeText(text string) (string, error) {
-	if len(text) < 100 {
+	return SummarizeTextWithMaxLength(text, 0)
+}
+
+// SummarizeTextWithMaxLength summarizes text in at most maxWords words.
+// A maxWords of zero leaves the summary length up to the model.
+func SummarizeTextWithMaxLength(text string, maxWords int) (string, error) {
+	if len(text) < summarizeThreshold {
 		return text, nil
 	}
 
-	// For demo purposes, return first 100 characters
-	// In production, use CallLLM with a summarization prompt
-	summary := text[:100] + "..."
-	return summary, nil
+	if !HasLLMCredentials() {
+		return truncateSummary(text, maxWords), nil
+	}
+
+	prompt := "Summarize the following text concisely, keeping the key facts.\n\n" + text
+	if maxWords > 0 {
+		prompt = fmt.Sprintf("Summarize the following text in at most %d words, keeping the key facts.\n\n%s", maxWords, text)
+	}
+
+	summary, err := CallLLM(prompt)
+	if err != nil {
+		return "", fmt.Errorf("failed to summarize text: %w", err)
+	}
+
+	return strings.TrimSpace(summary), nil
+}
+
+// truncateSummary is the offline fallback for SummarizeTextWithMaxLength
+func truncateSummary(text string, maxWords int) string {
+	if maxWords > 0 {
+		words := strings.Fields(text)
+		if len(words) <= maxWords {
+			return text
+		}
+		return strings.Join(words[:maxWords], " ") + "..."
+	}
+
+	// Return the first 100 characters
+	return text[:summarizeThreshold] + "..."
 }
 
 // ExtractKeyPoints extracts key points from text
@@ -74,6 +131,98 @@ func ExtractKeyPoints(text string) (string, error) {
 	return strings.Join(keyPoints, ". "), nil
 }
 
+// defaultKeywordCount is how many keywords OpKeywords returns
+const defaultKeywordCount = 10
+
+// StopWords is the default English stopword set: common words that carry
+// little meaning on their own. Treat it as read-only; call SetStopWords to
+// change the words that frequency-based operations skip.
+var StopWords = func() map[string]struct{} {
+	words := map[string]struct{}{}
+	for _, word := range strings.Fields(`
+		a about above after again against all am an and any are as at be
+		because been before being below between both but by can could did do
+		does doing down during each few for from further had has have having
+		he her here hers herself him himself his how i if in into is it its
+		itself just me more most my myself no nor not now of off on once only
+		or other our ours ourselves out over own same she should so some such
+		than that the their theirs them themselves then there these they this
+		those through to too under until up very was we were what when where
+		which while who whom why will with would you your yours yourself
+		yourselves`) {
+		words[word] = struct{}{}
+	}
+	return words
+}()
+
+// activeStopWords is the set consulted by IsStopWord. The map is never
+// mutated after being stored, so swapping it under the lock is enough.
+var (
+	stopWordsMu     sync.RWMutex
+	activeStopWords = StopWords
+)
+
+// SetStopWords replaces the stopword list used by text utilities, e.g. to
+// switch languages. Words are matched case-insensitively. Passing nil
+// restores the English default. Safe to call while other goroutines read.
+func SetStopWords(words []string) {
+	set := StopWords
+	if words != nil {
+		set = make(map[string]struct{}, len(words))
+		for _, word := range words {
+			set[strings.ToLower(strings.TrimSpace(word))] = struct{}{}
+		}
+	}
+
+	stopWordsMu.Lock()
+	activeStopWords = set
+	stopWordsMu.Unlock()
+}
+
+// IsStopWord reports whether word is in the current stopword list,
+// ignoring case
+func IsStopWord(word string) bool {
+	stopWordsMu.RLock()
+	set := activeStopWords
+	stopWordsMu.RUnlock()
+
+	_, ok := set[strings.ToLower(word)]
+	return ok
+}
+
+// ExtractKeywords returns up to topN of the most frequent terms in text,
+// ignoring stopwords (see SetStopWords) and single-character tokens. Terms are ordered by
+// count, then alphabetically so ties are deterministic. A topN of zero or
+// less returns every term. Empty input yields an empty, non-nil slice.
+func ExtractKeywords(text string, topN int) []string {
+	counts := map[string]int{}
+	for _, token := range TokenizeText(text) {
+		if utf8.RuneCountInString(token) < 2 {
+			continue
+		}
+		if IsStopWord(token) {
+			continue
+		}
+		counts[token]++
+	}
+
+	keywords := make([]string, 0, len(counts))
+	for term := range counts {
+		keywords = append(keywords, term)
+	}
+	sort.Slice(keywords, func(i, j int) bool {
+		if counts[keywords[i]] != counts[keywords[j]] {
+			return counts[keywords[i]] > counts[keywords[j]]
+		}
+		return keywords[i] < keywords[j]
+	})
+
+	if topN > 0 && len(keywords) > topN {
+		keywords = keywords[:topN]
+	}
+	return keywords
+}
+
 // CleanText removes extra whitespace and normalizes text
 func CleanText(text string) (string, error) {
 	// Remove extra whitespace
@@ -151,8 +300,192 @@ func ChunkText(text string, chunkSize int) []string {
 	return chunks
 }
 
+// ChunkTextWithOverlap splits text into chunks of chunkSize words where each
+// chunk repeats the last overlap words of the previous one, so context
+// isn't lost at chunk boundaries
+func ChunkTextWithOverlap(text string, chunkSize, overlap int) ([]string, error) {
+	if chunkSize <= 0 {
+		return nil, fmt.Errorf("chunk size must be positive, got %d", chunkSize)
+	}
+	if overlap < 0 || overlap >= chunkSize {
+		return nil, fmt.Errorf("overlap must be between 0 and chunk size (%d), got %d", chunkSize, overlap)
+	}
+
+	words := strings.Fields(text)
+	if len(words) == 0 {
+		return []string{}, nil
+	}
+
+	step := chunkSize - overlap
+	var chunks []string
+
+	for start := 0; ; start += step {
+		end := min(start+chunkSize, len(words))
+		chunks = append(chunks, strings.Join(words[start:end], " "))
+		if end == len(words) {
+			break
+		}
+	}
+
+	return chunks, nil
+}
+
+// abbreviations that end in a period but don't end a sentence
+var abbreviations = map[string]bool{
+	"e.g.": true, "i.e.": true, "etc.": true, "vs.": true, "cf.": true,
+	"dr.": true, "mr.": true, "mrs.": true, "ms.": true, "prof.": true,
+	"sr.": true, "jr.": true, "st.": true, "no.": true, "inc.": true,
+	"ltd.": true, "co.": true, "fig.": true, "approx.": true,
+}
+
+// SplitSentences splits text into sentences on ., ! and ? followed by
+// whitespace, without breaking on common abbreviations like "e.g." or "Dr."
+func SplitSentences(text string) []string {
+	var sentences []string
+	runes := []rune(text)
+	start := 0
+
+	for i, r := range runes {
+		if r != '.' && r != '!' && r != '?' {
+			continue
+		}
+		// Sentence ends only at end of text or before whitespace
+		if i+1 < len(runes) && !unicode.IsSpace(runes[i+1]) {
+			continue
+		}
+		if r == '.' {
+			wordStart := i
+			for wordStart > start && !unicode.IsSpace(runes[wordStart-1]) {
+				wordStart--
+			}
+			if abbreviations[strings.ToLower(string(runes[wordStart:i+1]))] {
+				continue
+			}
+		}
+
+		if sentence := strings.TrimSpace(string(runes[start : i+1])); sentence != "" {
+			sentences = append(sentences, sentence)
+		}
+		start = i + 1
+	}
+
+	// Trailing text without terminal punctuation
+	if sentence := strings.TrimSpace(string(runes[start:])); sentence != "" {
+		sentences = append(sentences, sentence)
+	}
+
+	return sentences
+}
+
+// ChunkTextBySentences groups whole sentences into chunks of at most maxChars.
+// A single sentence longer than maxChars is split on word boundaries.
+func ChunkTextBySentences(text string, maxChars int) []string {
+	if maxChars <= 0 || len(text) <= maxChars {
+		return []string{text}
+	}
+
+	var chunks []string
+	var current strings.Builder
+
+	flush := func() {
+		if current.Len() > 0 {
+			chunks = append(chunks, current.String())
+			current.Reset()
+		}
+	}
+
+	for _, sentence := range SplitSentences(text) {
+		if len(sentence) > maxChars {
+			// Unavoidable: fall back to word-based chunking for this sentence
+			flush()
+			chunks = append(chunks, ChunkText(sentence, maxChars)...)
+			continue
+		}
+
+		// +1 for the joining space
+		if current.Len() > 0 && current.Len()+1+len(sentence) > maxChars {
+			flush()
+		}
+		if current.Len() > 0 {
+			current.WriteByte(' ')
+		}
+		current.WriteString(sentence)
+	}
+	flush()
+
+	return chunks
+}
+
+// blockElements are HTML elements that start a new line of text
+var blockElements = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true,
+	"br": true, "dd": true, "div": true, "dl": true, "dt": true,
+	"figcaption": true, "figure": true, "footer": true, "form": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"header": true, "hr": true, "li": true, "main": true, "nav": true,
+	"ol": true, "p": true, "pre": true, "section": true, "table": true,
+	"td": true, "th": true, "title": true, "tr": true, "ul": true,
+}
+
+// hiddenElements are HTML elements whose content is never shown as text
+var hiddenElements = map[string]bool{
+	"script": true, "style": true, "noscript": true, "template": true,
+}
+
+// StripHTML converts an HTML document or fragment to plain text. Script and
+// style content is dropped, block elements start new lines, entities are
+// decoded once, and each line is normalized with CleanText. Malformed markup
+// is tolerated rather than rejected.
+func StripHTML(htmlText string) string {
+	tokenizer := html.NewTokenizer(strings.NewReader(htmlText))
+
+	var raw strings.Builder
+	hidden := 0
+	for {
+		tokenType := tokenizer.Next()
+		switch tokenType {
+		case html.ErrorToken:
+			// io.EOF or malformed input; either way, use what was read
+			var lines []string
+			for _, line := range strings.Split(raw.String(), "\n") {
+				if cleaned, _ := CleanText(line); cleaned != "" {
+					lines = append(lines, cleaned)
+				}
+			}
+			return strings.Join(lines, "\n")
+		case html.TextToken:
+			if hidden == 0 {
+				raw.Write(tokenizer.Text())
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, _ := tokenizer.TagName()
+			tag := string(name)
+			if hiddenElements[tag] && tokenType == html.StartTagToken {
+				hidden++
+			}
+			if blockElements[tag] {
+				raw.WriteString("\n")
+			} else {
+				// Keep words in adjacent inline elements apart
+				raw.WriteString(" ")
+			}
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			tag := string(name)
+			if hiddenElements[tag] && hidden > 0 {
+				hidden--
+			}
+			if blockElements[tag] {
+				raw.WriteString("\n")
+			}
+		}
+	}
+}
+
 // CountTokens estimates the number of tokens in text
-// This is a simple approximation - for accurate counts use a proper tokenizer
+// This is a simple approximation that works offline - for accurate counts use
+// CountTokensAccurate, which downloads the model's tokenizer on first use
+// unless TIKTOKEN_CACHE_DIR already holds it
 func CountTokens(text string) int {
 	// Rough estimate: 1 token ≈ 4 characters or 0.75 words
 	words := len(strings.Fields(text))
@@ -167,3 +500,220 @@ func CountTokens(text string) int {
 	}
 	return tokensByChars
 }
+
+// FitToTokenBudget returns the longest prefix of text whose CountTokens is
+// at most maxTokens. It cuts at the end of a sentence when at least one
+// whole sentence fits, otherwise at the end of a word, and only splits a
+// word when not even the first one fits. Prefixes are tried at
+// exponentially growing sizes and then narrowed down, so long texts
+// need only a few counts.
+func FitToTokenBudget(text string, maxTokens int) string {
+	if CountTokens(text) <= maxTokens {
+		return text
+	}
+	if maxTokens <= 0 {
+		return ""
+	}
+
+	fits := func(ends []int) int {
+		return largestFitting(len(ends), func(k int) bool {
+			return CountTokens(text[:ends[k-1]]) <= maxTokens
+		})
+	}
+
+	if ends := sentenceEnds(text); len(ends) > 0 {
+		if k := fits(ends); k > 0 {
+			return text[:ends[k-1]]
+		}
+	}
+	if ends := wordEnds(text); len(ends) > 0 {
+		if k := fits(ends); k > 0 {
+			return text[:ends[k-1]]
+		}
+	}
+
+	// Not even one word fits, so cut inside the first one
+	var ends []int
+	for i := range text {
+		if i > 0 {
+			ends = append(ends, i)
+		}
+	}
+	ends = append(ends, len(text))
+	if k := fits(ends); k > 0 {
+		return text[:ends[k-1]]
+	}
+	return ""
+}
+
+// CountWords counts the words in text as TokenizeText splits them
+func CountWords(text string) int {
+	return len(TokenizeText(text))
+}
+
+// TruncateToWords returns the longest prefix of text with at most maxWords
+// words by CountWords. It cuts at the end of a sentence when at least one
+// whole sentence fits, otherwise after a word, marking the cut with "...".
+func TruncateToWords(text string, maxWords int) string {
+	if CountWords(text) <= maxWords {
+		return text
+	}
+	if maxWords <= 0 {
+		return ""
+	}
+
+	fits := func(ends []int) int {
+		return largestFitting(len(ends), func(k int) bool {
+			return CountWords(text[:ends[k-1]]) <= maxWords
+		})
+	}
+
+	if ends := sentenceEnds(text); len(ends) > 0 {
+		if k := fits(ends); k > 0 {
+			return text[:ends[k-1]]
+		}
+	}
+	ends := wordEnds(text)
+	if k := fits(ends); k > 0 {
+		return text[:ends[k-1]] + "..."
+	}
+	return ""
+}
+
+// ExtractAfterMarker returns the text after the last occurrence of marker,
+// such as "Final Answer:", matched case-insensitively, so reasoning that
+// mentions the marker earlier doesn't cut the answer short. Without the
+// marker, or with an empty one, it returns the whole text. Either way
+// surrounding whitespace is trimmed.
+func ExtractAfterMarker(text, marker string) string {
+	marker = strings.TrimSpace(marker)
+	if marker == "" {
+		return strings.TrimSpace(text)
+	}
+
+	matches := regexp.MustCompile("(?i)"+regexp.QuoteMeta(marker)).FindAllStringIndex(text, -1)
+	if len(matches) == 0 {
+		return strings.TrimSpace(text)
+	}
+	return strings.TrimSpace(text[matches[len(matches)-1][1]:])
+}
+
+// largestFitting returns the largest k in [0, n] for which fits(k) holds,
+// assuming fits holds for every k up to some limit and for none after it.
+// fits(0) is assumed true and never called.
+func largestFitting(n int, fits func(k int) bool) int {
+	// Grow exponentially until a size doesn't fit
+	lo, hi := 0, 1
+	for hi <= n && fits(hi) {
+		lo = hi
+		hi *= 2
+	}
+	hi = min(hi, n+1)
+
+	// lo fits and hi doesn't; narrow down between them
+	for hi-lo > 1 {
+		mid := lo + (hi-lo)/2
+		if fits(mid) {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return lo
+}
+
+// sentenceEnds returns the byte offset in text just past each sentence
+// found by SplitSentences
+func sentenceEnds(text string) []int {
+	var ends []int
+	offset := 0
+	for _, sentence := range SplitSentences(text) {
+		i := strings.Index(text[offset:], sentence)
+		if i < 0 {
+			break
+		}
+		offset += i + len(sentence)
+		ends = append(ends, offset)
+	}
+	return ends
+}
+
+// wordEnds returns the byte offset in text just past each word
+func wordEnds(text string) []int {
+	var ends []int
+	inWord := false
+	for i, r := range text {
+		space := unicode.IsSpace(r)
+		if inWord && space {
+			ends = append(ends, i)
+		}
+		inWord = !space
+	}
+	if inWord {
+		ends = append(ends, len(text))
+	}
+	return ends
+}
+
+// encoders caches loaded BPE encoders by model, since loading the
+// rank tables is expensive
+var (
+	encodersMu sync.Mutex
+	encoders   = map[string]*tiktoken.Tiktoken{}
+)
+
+// ErrUnknownTokenizer is returned by CountTokensAccurate for models without
+// a known tiktoken encoding
+var ErrUnknownTokenizer = errors.New("no tokenizer for model")
+
+// CountTokensAccurate counts tokens using the model's tiktoken BPE encoding
+// (cl100k_base for gpt-3.5/gpt-4, o200k_base for gpt-4o). Models without a
+// known encoding return an error wrapping ErrUnknownTokenizer.
+//
+// The encoding's rank table is downloaded from the network on first use and
+// cached under TIKTOKEN_CACHE_DIR when it is set. Offline, point
+// TIKTOKEN_CACHE_DIR at a directory that already holds the table, or use
+// CountTokens; a failed download is reported as a load error, not as an
+// unknown model.
+func CountTokensAccurate(text, model string) (int, error) {
+	if model == "" {
+		model = openAIDefaultModel
+	}
+
+	encodersMu.Lock()
+	enc, ok := encoders[model]
+	if !ok {
+		encoding, found := tokenizerEncoding(model)
+		if !found {
+			encodersMu.Unlock()
+			return 0, fmt.Errorf("%w %q", ErrUnknownTokenizer, model)
+		}
+
+		var err error
+		enc, err = tiktoken.GetEncoding(encoding)
+		if err != nil {
+			encodersMu.Unlock()
+			return 0, fmt.Errorf("loading %s tokenizer for model %q (needs network access on first use, or TIKTOKEN_CACHE_DIR holding the table): %w", encoding, model, err)
+		}
+		encoders[model] = enc
+	}
+	encodersMu.Unlock()
+
+	return len(enc.Encode(text, nil, nil)), nil
+}
+
+// tokenizerEncoding returns the tiktoken encoding name for model, matching
+// an exact model name first and then the longest known prefix
+func tokenizerEncoding(model string) (string, bool) {
+	if encoding, ok := tiktoken.MODEL_TO_ENCODING[model]; ok {
+		return encoding, true
+	}
+
+	encoding, matched := "", ""
+	for prefix, name := range tiktoken.MODEL_PREFIX_TO_ENCODING {
+		if strings.HasPrefix(model, prefix) && len(prefix) > len(matched) {
+			encoding, matched = name, prefix
+		}
+	}
+	return encoding, matched != ""
+}
@@ -0,0 +1,213 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/pkoukk/tiktoken-go"
+)
+
+func TestFitToTokenBudget(t *testing.T) {
+	english := "The quick brown fox jumps over the lazy dog. It was not amused by the fox at all. Then it went back to sleep."
+	russian := "Привет, мир. Это второе предложение. И третье."
+	japanese := "日本語のテキストです。これは二番目の文です。そして三番目の文がここにあります。"
+
+	tests := []struct {
+		name      string
+		text      string
+		maxTokens int
+		want      string // checked when not empty
+		wantEmpty bool
+		cutAt     string // "sentence" or "word", checked when set
+	}{
+		{name: "fits whole", text: english, maxTokens: 1000, want: english},
+		{name: "zero budget", text: english, maxTokens: 0, wantEmpty: true},
+		{name: "negative budget", text: english, maxTokens: -5, wantEmpty: true},
+		{name: "one token", text: english, maxTokens: 1},
+		{name: "less than a sentence", text: english, maxTokens: 5, cutAt: "word"},
+		{name: "one sentence", text: english, maxTokens: CountTokens("The quick brown fox jumps over the lazy dog."), want: "The quick brown fox jumps over the lazy dog."},
+		{name: "two sentences", text: english, maxTokens: CountTokens(english) - 1, cutAt: "sentence"},
+		{name: "empty text", text: "", maxTokens: 0, wantEmpty: true},
+		{name: "multi-byte sentence", text: russian, maxTokens: CountTokens("Привет, мир.") + 1, want: "Привет, мир."},
+		{name: "multi-byte less than a sentence", text: russian, maxTokens: CountTokens("Привет,"), cutAt: "word"},
+		{name: "multi-byte without spaces", text: japanese, maxTokens: 5},
+		{name: "multi-byte tiny budget", text: japanese, maxTokens: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := FitToTokenBudget(tt.text, tt.maxTokens)
+
+			if tokens := CountTokens(got); tokens > max(tt.maxTokens, 0) {
+				t.Errorf("FitToTokenBudget() = %q, %d tokens, over the budget of %d", got, tokens, tt.maxTokens)
+			}
+			if !strings.HasPrefix(tt.text, got) {
+				t.Errorf("FitToTokenBudget() = %q, not a prefix of the input", got)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("FitToTokenBudget() = %q, split a multi-byte character", got)
+			}
+			if tt.wantEmpty && got != "" {
+				t.Errorf("FitToTokenBudget() = %q, want empty", got)
+			}
+			if !tt.wantEmpty && tt.maxTokens > 0 && got == "" {
+				t.Errorf("FitToTokenBudget() is empty, want a non-empty prefix")
+			}
+			if tt.want != "" && got != tt.want {
+				t.Errorf("FitToTokenBudget() = %q, want %q", got, tt.want)
+			}
+
+			switch tt.cutAt {
+			case "sentence":
+				if !strings.HasSuffix(got, ".") {
+					t.Errorf("FitToTokenBudget() = %q, want a cut at a sentence end", got)
+				}
+			case "word":
+				rest := tt.text[len(got):]
+				if strings.HasSuffix(got, " ") || !strings.HasPrefix(rest, " ") {
+					t.Errorf("FitToTokenBudget() = %q, want a cut at a word end", got)
+				}
+			}
+		})
+	}
+}
+
+func TestChunkTextWithOverlapReassembles(t *testing.T) {
+	var words []string
+	for i := range 103 {
+		words = append(words, fmt.Sprintf("w%d", i))
+	}
+	text := strings.Join(words, " \n ")
+
+	tests := []struct {
+		chunkSize, overlap int
+	}{
+		{10, 0},
+		{10, 3},
+		{10, 9},
+		{1, 0},
+		{103, 20},
+		{200, 50},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("size_%d_overlap_%d", tt.chunkSize, tt.overlap), func(t *testing.T) {
+			chunks, err := ChunkTextWithOverlap(text, tt.chunkSize, tt.overlap)
+			if err != nil {
+				t.Fatalf("ChunkTextWithOverlap() error = %v", err)
+			}
+
+			// Drop each chunk's overlap with the one before it
+			var reassembled []string
+			for i, chunk := range chunks {
+				chunkWords := strings.Fields(chunk)
+				if len(chunkWords) > tt.chunkSize {
+					t.Errorf("chunk %d has %d words, want at most %d", i, len(chunkWords), tt.chunkSize)
+				}
+				if i > 0 {
+					previous := strings.Fields(chunks[i-1])
+					if !slices.Equal(chunkWords[:tt.overlap], previous[len(previous)-tt.overlap:]) {
+						t.Errorf("chunk %d doesn't start with the last %d words of chunk %d", i, tt.overlap, i-1)
+					}
+					chunkWords = chunkWords[tt.overlap:]
+				}
+				reassembled = append(reassembled, chunkWords...)
+			}
+
+			if !slices.Equal(reassembled, words) {
+				t.Errorf("reassembled words = %v, want %v", reassembled, words)
+			}
+		})
+	}
+}
+
+func TestChunkTextWithOverlapRejectsBadSizes(t *testing.T) {
+	for _, tt := range []struct{ chunkSize, overlap int }{{0, 0}, {-1, 0}, {5, 5}, {5, -1}} {
+		if _, err := ChunkTextWithOverlap("some words here", tt.chunkSize, tt.overlap); err == nil {
+			t.Errorf("ChunkTextWithOverlap(size %d, overlap %d) error = nil, want an error", tt.chunkSize, tt.overlap)
+		}
+	}
+
+	chunks, err := ChunkTextWithOverlap("  \n ", 5, 1)
+	if err != nil || len(chunks) != 0 {
+		t.Errorf("ChunkTextWithOverlap(blank) = %q, %v, want no chunks", chunks, err)
+	}
+}
+
+func TestStripHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want string
+	}{
+		{name: "empty", html: "", want: ""},
+		{name: "plain text", html: "a < b and c > d", want: "a < b and c > d"},
+		{
+			name: "nested tags",
+			html: "<div><p>Hello <b>bold <i>nested</i></b> world</p><p>Second</p></div>",
+			want: "Hello bold nested world\nSecond",
+		},
+		{name: "inline tags keep words apart", html: "<span>one</span><span>two</span>", want: "one two"},
+		{name: "line breaks", html: "<div>one<br>two</div>", want: "one\ntwo"},
+		{
+			name: "entities decoded once",
+			html: "Fish &amp;amp; chips &amp; peas &lt;b&gt;",
+			want: "Fish &amp; chips & peas <b>",
+		},
+		{name: "unclosed tags", html: "<p>Unclosed paragraph <b>bold text", want: "Unclosed paragraph bold text"},
+		{name: "unknown tag", html: "<p>Tag <unknown-tag>inside</p>", want: "Tag inside"},
+		{name: "stray bracket", html: "<p>text</p><", want: "text\n<"},
+		// An unterminated attribute quote swallows the rest, as in browsers
+		{name: "unterminated attribute", html: `<p>Broken <b attr="x>text</p> after`, want: "Broken"},
+		{
+			name: "script and style removed",
+			html: "<script>alert('x')</script><p>Visible</p><style>p{color:red}</style>",
+			want: "Visible",
+		},
+		{name: "markup inside script", html: "<script>var s = '</p>';</script>Kept", want: "Kept"},
+		{name: "noscript removed", html: "<noscript>hidden</noscript>shown", want: "shown"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := StripHTML(tt.html); got != tt.want {
+				t.Errorf("StripHTML(%q) = %q, want %q", tt.html, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCountTokensAccurateUnknownModel(t *testing.T) {
+	_, err := CountTokensAccurate("hello", "not-a-real-model")
+	if !errors.Is(err, ErrUnknownTokenizer) {
+		t.Errorf("CountTokensAccurate(unknown model) error = %v, want ErrUnknownTokenizer", err)
+	}
+}
+
+// failingBpeLoader stands in for a tokenizer download that can't reach the network
+type failingBpeLoader struct{}
+
+func (failingBpeLoader) LoadTiktokenBpe(string) (map[string]int, error) {
+	return nil, errors.New("dial tcp: no route to host")
+}
+
+func TestCountTokensAccurateLoadFailure(t *testing.T) {
+	tiktoken.SetBpeLoader(failingBpeLoader{})
+	t.Cleanup(func() { tiktoken.SetBpeLoader(tiktoken.NewDefaultBpeLoader()) })
+
+	// davinci uses r50k_base, which no other test loads
+	_, err := CountTokensAccurate("hello", "davinci")
+	if err == nil {
+		t.Fatal("CountTokensAccurate with a failing download error = nil, want an error")
+	}
+	if errors.Is(err, ErrUnknownTokenizer) {
+		t.Errorf("CountTokensAccurate with a failing download error = %v, reported as an unknown model", err)
+	}
+	if !strings.Contains(err.Error(), "TIKTOKEN_CACHE_DIR") {
+		t.Errorf("CountTokensAccurate with a failing download error = %v, want it to mention TIKTOKEN_CACHE_DIR", err)
+	}
+}
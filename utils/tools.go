@@ -0,0 +1,184 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// ToolDef describes a tool the model may call
+type ToolDef struct {
+	Name        string
+	Description string
+
+	// Parameters is the JSON Schema of the tool's arguments object
+	Parameters map[string]any
+}
+
+// ToolCall is a tool invocation requested by the model, in the OpenAI wire
+// format so it can be echoed back in the conversation
+type ToolCall struct {
+	ID       string           `json:"id"`
+	Type     string           `json:"type"` // always "function"
+	Function ToolCallFunction `json:"function"`
+}
+
+// ToolCallFunction names the tool and carries its JSON-encoded arguments
+type ToolCallFunction struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// ToolResponse is the model's reply to CallLLMWithTools: either a final
+// text answer in Content, or one or more ToolCalls to run first
+type ToolResponse struct {
+	Content   string
+	ToolCalls []ToolCall
+}
+
+// ToolCaller is implemented by providers that support function calling
+type ToolCaller interface {
+	ChatWithTools(ctx context.Context, messages []Message, tools []ToolDef, config *LLMConfig) (*ToolResponse, error)
+}
+
+// CallLLMWithTools sends a conversation along with tool definitions to the
+// configured provider. To continue after a tool call, append the returned
+// assistant message (see ToolResponse.Message) and a "tool" message with
+// each result, then call again.
+func CallLLMWithTools(ctx context.Context, messages []Message, tools []ToolDef, config *LLMConfig) (*ToolResponse, error) {
+	caller, ok := DefaultProvider.(ToolCaller)
+	if !ok {
+		return nil, fmt.Errorf("LLM provider %s does not support tool calling", providerName(DefaultProvider))
+	}
+
+	ctx, span := startLLMSpan(ctx, messages, config)
+	response, err := caller.ChatWithTools(ctx, messages, tools, config)
+	EndSpan(span, err)
+	return response, err
+}
+
+// Message returns the assistant message to append to the conversation
+func (r *ToolResponse) Message() Message {
+	return Message{Role: "assistant", Content: r.Content, ToolCalls: r.ToolCalls}
+}
+
+// validateToolMessages is validateMessages extended with "tool" results
+func validateToolMessages(messages []Message) error {
+	if len(messages) == 0 {
+		return fmt.Errorf("conversation must contain at least one message")
+	}
+	for i, msg := range messages {
+		switch msg.Role {
+		case "system", "user", "assistant":
+		case "tool":
+			if msg.ToolCallID == "" {
+				return fmt.Errorf("tool message %d has no tool call ID", i)
+			}
+		default:
+			return fmt.Errorf("message %d has invalid role %q", i, msg.Role)
+		}
+	}
+	return nil
+}
+
+// ChatWithTools implements ToolCaller for OpenAI
+func (p *OpenAIProvider) ChatWithTools(ctx context.Context, messages []Message, tools []ToolDef, config *LLMConfig) (*ToolResponse, error) {
+	if err := validateToolMessages(messages); err != nil {
+		return nil, err
+	}
+
+	apiKey := p.APIKey
+	if apiKey == "" {
+		apiKey = os.Getenv("OPENAI_API_KEY")
+	}
+	if apiKey == "" {
+		return nil, fmt.Errorf("OPENAI_API_KEY environment variable not set")
+	}
+
+	model := config.Model
+	if model == "" {
+		model = openAIDefaultModel
+	}
+
+	headers := map[string]string{
+		"Authorization": "Bearer " + apiKey,
+	}
+
+	return toolChatCompletion(ctx, OpenAIBaseURL+"/chat/completions", headers, model, messages, tools, config)
+}
+
+// toolChatCompletion sends a Chat Completions request with tool
+// definitions to url, as chatCompletion does for plain chats
+func toolChatCompletion(ctx context.Context, url string, headers map[string]string, model string, messages []Message, tools []ToolDef, config *LLMConfig) (*ToolResponse, error) {
+	// Prepare request body
+	requestBody := map[string]any{
+		"model":       model,
+		"messages":    messages,
+		"temperature": config.Temperature,
+	}
+
+	if config.MaxTokens > 0 {
+		requestBody["max_tokens"] = config.MaxTokens
+	}
+
+	if config.Seed != nil {
+		requestBody["seed"] = *config.Seed
+	}
+
+	if len(tools) > 0 {
+		specs := make([]map[string]any, len(tools))
+		for i, tool := range tools {
+			parameters := tool.Parameters
+			if parameters == nil {
+				parameters = map[string]any{"type": "object", "properties": map[string]any{}}
+			}
+			specs[i] = map[string]any{
+				"type": "function",
+				"function": map[string]any{
+					"name":        tool.Name,
+					"description": tool.Description,
+					"parameters":  parameters,
+				},
+			}
+		}
+		requestBody["tools"] = specs
+
+		if config.ToolChoice != "" {
+			requestBody["tool_choice"] = config.ToolChoice
+		}
+	}
+
+	jsonData, err := json.Marshal(requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	body, err := postJSONWithRetry(ctx, url, headers, jsonData, config)
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse response
+	var result struct {
+		Choices []struct {
+			Message struct {
+				Content   string     `json:"content"`
+				ToolCalls []ToolCall `json:"tool_calls"`
+			} `json:"message"`
+		} `json:"choices"`
+		Usage Usage `json:"usage"`
+	}
+
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	if len(result.Choices) == 0 {
+		return nil, fmt.Errorf("no response from API")
+	}
+
+	recordUsage(ctx, model, result.Usage)
+	message := result.Choices[0].Message
+	return &ToolResponse{Content: message.Content, ToolCalls: message.ToolCalls}, nil
+}
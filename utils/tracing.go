@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName identifies spans created by this project
+const tracerName = "flyt-project-template"
+
+// TracingEnabled reports whether OTEL_ENABLED asks for OpenTelemetry tracing
+func TracingEnabled() bool {
+	value := os.Getenv("OTEL_ENABLED")
+	return value == "1" || value == "true"
+}
+
+// InitTracing installs an OpenTelemetry tracer provider that exports spans
+// over OTLP/HTTP when OTEL_ENABLED is set. The collector address comes from
+// the standard OTEL_EXPORTER_OTLP_ENDPOINT (default http://localhost:4318)
+// and the service name from OTEL_SERVICE_NAME. When tracing is disabled,
+// spans are no-ops and nothing is exported. Call the returned function
+// before exiting to flush pending spans.
+func InitTracing(ctx context.Context) (shutdown func(context.Context) error, err error) {
+	if !TracingEnabled() {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+
+	res, err := resource.Merge(
+		resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(tracerName)),
+		resource.Default(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// StartSpan starts a span named name as a child of any span in ctx. It is
+// a no-op unless InitTracing enabled tracing.
+func StartSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// EndSpan ends span, marking it failed when err is non-nil
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// startLLMSpan starts the span for one LLM call
+func startLLMSpan(ctx context.Context, messages []Message, config *LLMConfig) (context.Context, trace.Span) {
+	model := config.Model
+	if model == "" {
+		model = "default"
+	}
+
+	promptTokens := 0
+	for _, msg := range messages {
+		promptTokens += CountTokens(msg.Content)
+	}
+
+	return StartSpan(ctx, "llm.call",
+		attribute.String("llm.provider", providerName(DefaultProvider)),
+		attribute.String("llm.model", model),
+		attribute.Float64("llm.temperature", config.Temperature),
+		// Providers don't report usage, so these are CountTokens estimates
+		attribute.Int("llm.usage.prompt_tokens_estimate", promptTokens),
+	)
+}
+
+// endLLMSpan records the response size and ends an LLM call span
+func endLLMSpan(span trace.Span, response string, err error) {
+	if err == nil {
+		span.SetAttributes(attribute.Int("llm.usage.completion_tokens_estimate", CountTokens(response)))
+	}
+	EndSpan(span, err)
+}